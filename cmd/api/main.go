@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"time"
+	_ "time/tzdata" // user timezones must resolve even without system zoneinfo
 
 	"github.com/Joshua-takyi/expense/server/internal/connection"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
 	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/ratelimit"
 	"github.com/Joshua-takyi/expense/server/internal/router"
+	"github.com/Joshua-takyi/expense/server/internal/storage"
+	"github.com/Joshua-takyi/expense/server/internal/worker"
 )
 
 func main() {
@@ -23,12 +30,28 @@ func main() {
 
 	repo := &models.Repository{DB: connection.Client}
 
+	files, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to configure file storage: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		log.Printf("failed to ensure indexes: %v", err)
+	}
+	worker.Every(ctx, time.Hour, "account erasure", worker.EraseDueAccounts(repo, files))
+	worker.Every(ctx, time.Hour, "export cleanup", worker.PurgeExpiredExports(repo))
+	worker.Every(ctx, time.Hour, "trash purge", worker.PurgeTrash(repo))
+	worker.Every(ctx, time.Hour, "attachment cleanup", worker.PurgeOrphanedAttachments(repo, files))
+	worker.Every(ctx, time.Hour, "idempotency key cleanup", worker.PurgeExpiredIdempotencyKeys(repo))
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 	// Set up the router with the service layer
-	r := router.Router(repo)
+	r := router.Router(repo, mailer.FromEnv(), ratelimit.NewMemoryStore(), files)
 	if err := r.Run(":" + port); err != nil {
 		fmt.Printf("failed to run the server: %v", err)
 	}
@@ -0,0 +1,48 @@
+// Command mockoidc is a minimal OpenID Connect provider for developing and
+// testing single sign-on locally. It signs in every authorization request as
+// the user given by the login_hint parameter (or MOCK_OIDC_EMAIL) without
+// prompting. Never expose it outside a development machine.
+//
+//	go run ./cmd/mockoidc
+//	OIDC_ISSUER=http://localhost:9400 OIDC_CLIENT_ID=expense \
+//	OIDC_REDIRECT_URL=http://localhost:8080/api/v1/auth/oidc/callback go run ./cmd/api
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Joshua-takyi/expense/server/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := os.Getenv("MOCK_OIDC_ADDR")
+	if addr == "" {
+		addr = "localhost:9400"
+	}
+	issuer := os.Getenv("MOCK_OIDC_ISSUER")
+	if issuer == "" {
+		issuer = "http://" + addr
+	}
+	defaultEmail := os.Getenv("MOCK_OIDC_EMAIL")
+	if defaultEmail == "" {
+		defaultEmail = "dev@example.com"
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	provider, err := oidctest.New(issuer, defaultEmail)
+	if err != nil {
+		log.Fatalf("failed to generate signing key: %v", err)
+	}
+
+	// gin.Default in front for request logging
+	r := gin.Default()
+	r.NoRoute(gin.WrapH(provider.Handler()))
+
+	log.Printf("mock oidc provider listening on %s (issuer %s)", addr, issuer)
+	if err := r.Run(addr); err != nil {
+		log.Fatalf("failed to run mock oidc provider: %v", err)
+	}
+}
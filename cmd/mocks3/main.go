@@ -0,0 +1,118 @@
+// Command mocks3 is a minimal in-memory S3-compatible object store for
+// developing and testing the s3 storage backend locally. It serves
+// path-style PUT, GET, HEAD and DELETE of objects in any bucket and accepts
+// every request without checking signatures. Never expose it outside a
+// development machine.
+//
+//	go run ./cmd/mocks3
+//	STORAGE_BACKEND=s3 S3_ENDPOINT=http://localhost:9500 S3_BUCKET=expense go run ./cmd/api
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func main() {
+	addr := os.Getenv("MOCK_S3_ADDR")
+	if addr == "" {
+		addr = "localhost:9500"
+	}
+
+	var (
+		mu      sync.RWMutex
+		objects = map[string]object{}
+	)
+
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.Default()
+
+	notFound := func(c *gin.Context) {
+		c.Header("Content-Type", "application/xml")
+		c.String(http.StatusNotFound, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+	}
+	// objectKey is "<bucket>/<key>"; bucket-level requests aren't supported
+	objectKey := func(c *gin.Context) (string, bool) {
+		path := strings.TrimPrefix(c.Param("path"), "/")
+		if bucket, key, found := strings.Cut(path, "/"); !found || bucket == "" || key == "" {
+			c.String(http.StatusBadRequest, "path-style object requests only")
+			return "", false
+		}
+		return path, true
+	}
+
+	r.PUT("/*path", func(c *gin.Context) {
+		key, ok := objectKey(c)
+		if !ok {
+			return
+		}
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusBadRequest, "failed to read body")
+			return
+		}
+		mu.Lock()
+		objects[key] = object{data: data, contentType: c.GetHeader("Content-Type"), modified: time.Now()}
+		mu.Unlock()
+		c.Header("ETag", `"`+strconv.Itoa(len(data))+`"`)
+		c.Status(http.StatusOK)
+	})
+
+	get := func(c *gin.Context) {
+		key, ok := objectKey(c)
+		if !ok {
+			return
+		}
+		mu.RLock()
+		obj, found := objects[key]
+		mu.RUnlock()
+		if !found {
+			notFound(c)
+			return
+		}
+		contentType := obj.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.Header("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		c.Header("Content-Length", strconv.Itoa(len(obj.data)))
+		if c.Request.Method == http.MethodHead {
+			c.Header("Content-Type", contentType)
+			c.Status(http.StatusOK)
+			return
+		}
+		c.Data(http.StatusOK, contentType, obj.data)
+	}
+	r.GET("/*path", get)
+	r.HEAD("/*path", get)
+
+	r.DELETE("/*path", func(c *gin.Context) {
+		key, ok := objectKey(c)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		delete(objects, key)
+		mu.Unlock()
+		c.Status(http.StatusNoContent)
+	})
+
+	log.Printf("mock s3 listening on %s", addr)
+	if err := r.Run(addr); err != nil {
+		log.Fatalf("failed to run mock s3: %v", err)
+	}
+}
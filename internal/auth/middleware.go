@@ -2,13 +2,35 @@ package auth
 
 import (
 	"os"
+	"strings"
+	"time"
 
+	"github.com/Joshua-takyi/expense/server/internal/csrf"
 	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func Middleware() gin.HandlerFunc {
+const (
+	// context keys set by Middleware
+	ContextAuthMethod = "auth_method"
+	ContextAPIToken   = "api_token"
+	ContextUser       = "current_user"
+
+	AuthMethodSession = "session"
+	AuthMethodToken   = "token"
+)
+
+func Middleware(s models.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// scripts authenticate with a personal access token; there is no cookie
+		// for a browser to send on their behalf so CSRF checks don't apply
+		if bearer, ok := bearerToken(c); ok {
+			authenticateAPIToken(c, s, bearer)
+			return
+		}
+
 		secret := os.Getenv("JWT_SECRET")
 		if secret == "" {
 			c.AbortWithStatusJSON(500, gin.H{"error": "internal server error"})
@@ -17,7 +39,7 @@ func Middleware() gin.HandlerFunc {
 		}
 
 		// get token from the request header
-		token, err := c.Cookie("auth_token")
+		token, err := c.Cookie(csrf.SessionCookie)
 		if err != nil || token == "" {
 			c.JSON(401, gin.H{"error": "authorization token not provided"})
 			c.Abort()
@@ -32,29 +54,132 @@ func Middleware() gin.HandlerFunc {
 			return
 		}
 
+		user, ok := loadUser(c, s, claims.UserID)
+		if !ok {
+			return
+		}
+		if claims.StandardClaims != nil && claims.IssuedAt < user.SessionsRevokedAt.Unix() {
+			c.AbortWithStatusJSON(401, gin.H{"error": "session has been revoked, please sign in again"})
+			return
+		}
+
 		c.Set("user", claims)
+		c.Set(ContextUser, user)
+		c.Set(ContextAuthMethod, AuthMethodSession)
 
-		// csrf token protecting mutation and post requests
-		if c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "DELETE" {
-			csrfCookie, err := c.Cookie("csrf_token")
-			if err != nil {
-				c.AbortWithStatusJSON(403, gin.H{"error": "invalid CSRF token"})
-				c.Abort()
+		// the browser attaches the session cookie to cross-site requests too, so
+		// every state changing request must prove it came from our frontend
+		if csrf.Unsafe(c.Request.Method) {
+			if err := csrf.FromEnv().Verify(c.Request); err != nil {
+				c.AbortWithStatusJSON(403, gin.H{"error": err.Error()})
 				return
 			}
+		}
+		c.Next()
+	}
+}
 
-			csrfHeader := c.GetHeader("X-CSRF-Token")
-			if csrfHeader == "" {
-				c.AbortWithStatusJSON(403, gin.H{"error": "invalid CSRF token"})
-				c.Abort()
-				return
-			}
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return "", false
+	}
+	scheme, value, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
 
-			if csrfCookie != csrfHeader {
-				c.AbortWithStatusJSON(403, gin.H{"error": "invalid CSRF token"})
-				c.Abort()
-				return
-			}
+func authenticateAPIToken(c *gin.Context, s models.Service, bearer string) {
+	if !strings.HasPrefix(bearer, helpers.APITokenPrefix) {
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid authorization token"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	apiToken, err := s.GetAPITokenByHash(ctx, helpers.HashToken(bearer))
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid authorization token"})
+		return
+	}
+
+	now := time.Now()
+	if apiToken.Expired(now) {
+		c.AbortWithStatusJSON(401, gin.H{"error": "authorization token expired"})
+		return
+	}
+	user, ok := loadUser(c, s, apiToken.UserId.Hex())
+	if !ok {
+		return
+	}
+
+	// usage tracking is best effort and must not fail the request
+	_ = s.TouchAPIToken(ctx, apiToken.Id, now)
+
+	c.Set("user", &helpers.UseClaims{UserID: apiToken.UserId.Hex(), Email: user.Email})
+	c.Set(ContextUser, user)
+	c.Set(ContextAuthMethod, AuthMethodToken)
+	c.Set(ContextAPIToken, apiToken)
+	c.Next()
+}
+
+// loadUser fetches the account behind the credentials so that disabled users
+// and revoked sessions are refused immediately rather than at token expiry.
+func loadUser(c *gin.Context, s models.Service, userID string) (*models.User, bool) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid authorization token"})
+		return nil, false
+	}
+	user, err := s.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid authorization token"})
+		return nil, false
+	}
+	if user.Disabled {
+		c.AbortWithStatusJSON(403, gin.H{"error": "account is disabled"})
+		return nil, false
+	}
+	return user, true
+}
+
+// RequireRole allows only users with role. It must run after Middleware.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := c.MustGet(ContextUser).(*models.User)
+		if !ok || user.Role != role {
+			c.AbortWithStatusJSON(403, gin.H{"error": "forbidden access"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope rejects personal access tokens that were not granted scope.
+// Cookie sessions act with the user's full permissions and pass through.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(ContextAuthMethod) != AuthMethodToken {
+			c.Next()
+			return
+		}
+		apiToken, ok := c.MustGet(ContextAPIToken).(*models.APIToken)
+		if !ok || !apiToken.HasScope(scope) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "token is missing the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession restricts a route to interactive browser sessions, e.g. so a
+// leaked token cannot mint further tokens or change security settings.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(ContextAuthMethod) != AuthMethodSession {
+			c.AbortWithStatusJSON(403, gin.H{"error": "this action requires an interactive session"})
+			return
 		}
 		c.Next()
 	}
@@ -0,0 +1,173 @@
+// Package classifier is a small multinomial naive Bayes classifier used to
+// suggest transaction categories. Models are plain data so they can be stored
+// as documents and updated one example at a time.
+package classifier
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Model counts, per class, how many examples were learned and how often each
+// feature occurred in them.
+type Model struct {
+	Docs    int     `bson:"docs" json:"docs"`
+	Classes []Class `bson:"classes" json:"classes"`
+}
+
+type Class struct {
+	Name     string         `bson:"name" json:"name"`
+	Docs     int            `bson:"docs" json:"docs"`
+	Tokens   int            `bson:"tokens" json:"tokens"`
+	Features map[string]int `bson:"features" json:"-"`
+}
+
+type Prediction struct {
+	Class      string  `json:"category"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Features turns a transaction into the features the model learns from: the
+// words of the description and note, a bucket for the order of magnitude of
+// the amount and the transaction type. Feature names only contain letters,
+// digits and underscores.
+func Features(description, note string, amount float64, kind string) []string {
+	var features []string
+	for _, word := range words(description) {
+		features = append(features, "d_"+word)
+	}
+	for _, word := range words(note) {
+		features = append(features, "n_"+word)
+	}
+	if amount > 0 {
+		// 0: under 2, 1: 2-4, 2: 4-8 ... so 45 and 60 share a bucket
+		bucket := int(math.Max(0, math.Floor(math.Log2(amount))))
+		features = append(features, "amt_"+strconv.Itoa(bucket))
+	}
+	if kind != "" {
+		features = append(features, "type_"+strings.ToLower(kind))
+	}
+	return features
+}
+
+// words splits text into lowercase words, dropping single letters and
+// numbers of three or more digits, which are usually references.
+func words(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var out []string
+	for _, f := range fields {
+		if len([]rune(f)) < 2 || (len(f) >= 3 && strings.IndexFunc(f, func(r rune) bool { return !unicode.IsDigit(r) }) < 0) {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func (m *Model) class(name string, create bool) *Class {
+	for i := range m.Classes {
+		if m.Classes[i].Name == name {
+			return &m.Classes[i]
+		}
+	}
+	if !create {
+		return nil
+	}
+	m.Classes = append(m.Classes, Class{Name: name, Features: map[string]int{}})
+	return &m.Classes[len(m.Classes)-1]
+}
+
+// Learn adds one example of class.
+func (m *Model) Learn(class string, features []string) {
+	c := m.class(class, true)
+	if c.Features == nil {
+		c.Features = map[string]int{}
+	}
+	c.Docs++
+	m.Docs++
+	for _, f := range features {
+		c.Features[f]++
+		c.Tokens++
+	}
+}
+
+// Forget removes an example learned earlier, so a model can follow edits and
+// deletions without being rebuilt.
+func (m *Model) Forget(class string, features []string) {
+	c := m.class(class, false)
+	if c == nil || c.Docs == 0 {
+		return
+	}
+	c.Docs--
+	m.Docs--
+	for _, f := range features {
+		if c.Features[f] > 0 {
+			c.Features[f]--
+			c.Tokens--
+			if c.Features[f] == 0 {
+				delete(c.Features, f)
+			}
+		}
+	}
+	if c.Docs == 0 {
+		for i := range m.Classes {
+			if m.Classes[i].Name == class {
+				m.Classes = append(m.Classes[:i], m.Classes[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Predict ranks the classes for features, best first, returning at most n.
+// Confidence is the posterior probability, so the values add up to 1 over
+// all classes.
+func (m *Model) Predict(features []string, n int) []Prediction {
+	if m.Docs == 0 || len(m.Classes) == 0 {
+		return []Prediction{}
+	}
+
+	vocabulary := map[string]bool{}
+	for _, c := range m.Classes {
+		for f := range c.Features {
+			vocabulary[f] = true
+		}
+	}
+	v := float64(len(vocabulary))
+
+	scores := make([]float64, len(m.Classes))
+	best := math.Inf(-1)
+	for i, c := range m.Classes {
+		// log prior plus Laplace-smoothed log likelihoods
+		score := math.Log(float64(c.Docs) / float64(m.Docs))
+		for _, f := range features {
+			if !vocabulary[f] {
+				continue
+			}
+			score += math.Log((float64(c.Features[f]) + 1) / (float64(c.Tokens) + v))
+		}
+		scores[i] = score
+		best = math.Max(best, score)
+	}
+
+	var sum float64
+	predictions := make([]Prediction, len(m.Classes))
+	for i, c := range m.Classes {
+		p := math.Exp(scores[i] - best)
+		sum += p
+		predictions[i] = Prediction{Class: c.Name, Confidence: p}
+	}
+	sort.SliceStable(predictions, func(a, b int) bool { return predictions[a].Confidence > predictions[b].Confidence })
+	if n > 0 && len(predictions) > n {
+		predictions = predictions[:n]
+	}
+	for i := range predictions {
+		predictions[i].Confidence = math.Round(predictions[i].Confidence/sum*1000) / 1000
+	}
+	return predictions
+}
@@ -0,0 +1,136 @@
+package classifier
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		note        string
+		amount      float64
+		kind        string
+		want        []string
+	}{
+		{"words and type", "Tesco Stores 3321", "weekly shop", 45, "Expense", []string{"d_tesco", "d_stores", "n_weekly", "n_shop", "amt_5", "type_expense"}},
+		{"punctuation splits words", "AMZN Mktp US*2K4", "", 0, "", []string{"d_amzn", "d_mktp", "d_us", "d_2k4"}},
+		{"single letters and long numbers are dropped", "a 12 123 x-ray", "", 0, "", []string{"d_12", "d_ray"}},
+		{"same bucket within a power of two", "", "", 60, "", []string{"amt_5"}},
+		{"small amounts share the lowest bucket", "", "", 0.5, "", []string{"amt_0"}},
+		{"nothing", "", "", 0, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Features(tt.description, tt.note, tt.amount, tt.kind); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Features = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPredict(t *testing.T) {
+	var m Model
+	m.Learn("food", []string{"a"})
+	m.Learn("travel", []string{"b"})
+
+	// equal priors, vocabulary of 2: P(a|food) = 2/3 and P(a|travel) = 1/3
+	got := m.Predict([]string{"a"}, 0)
+	want := []Prediction{{Class: "food", Confidence: 0.667}, {Class: "travel", Confidence: 0.333}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Predict = %v, want %v", got, want)
+	}
+
+	// unknown features are ignored, leaving the priors
+	if got := m.Predict([]string{"unseen"}, 0); got[0].Confidence != 0.5 || got[1].Confidence != 0.5 {
+		t.Errorf("Predict(unseen) = %v, want the priors", got)
+	}
+}
+
+func TestPredictRanksAndLimits(t *testing.T) {
+	var m Model
+	for i := 0; i < 5; i++ {
+		m.Learn("groceries", Features("Tesco Stores", "", 45, "expense"))
+	}
+	for i := 0; i < 3; i++ {
+		m.Learn("transport", Features("TfL Travel Charge", "", 7.5, "expense"))
+	}
+	m.Learn("salary", Features("ACME Payroll", "", 2500, "income"))
+
+	got := m.Predict(Features("TESCO STORES 4402", "", 38, "expense"), 0)
+	if len(got) != 3 || got[0].Class != "groceries" {
+		t.Fatalf("Predict = %v, want groceries first", got)
+	}
+	var sum float64
+	for i, p := range got {
+		sum += p.Confidence
+		if i > 0 && p.Confidence > got[i-1].Confidence {
+			t.Errorf("predictions aren't sorted: %v", got)
+		}
+	}
+	if math.Abs(sum-1) > 0.002 {
+		t.Errorf("confidences add up to %v, want 1", sum)
+	}
+
+	if got := m.Predict(Features("tfl travel", "", 7.5, "expense"), 1); len(got) != 1 || got[0].Class != "transport" {
+		t.Errorf("Predict(n=1) = %v, want only transport", got)
+	}
+}
+
+func TestPredictEmptyModel(t *testing.T) {
+	var m Model
+	if got := m.Predict([]string{"d_tesco"}, 3); got == nil || len(got) != 0 {
+		t.Errorf("Predict on an empty model = %#v, want an empty slice", got)
+	}
+}
+
+func TestForgetUndoesLearn(t *testing.T) {
+	var m Model
+	m.Learn("groceries", []string{"d_tesco", "amt_5"})
+	m.Learn("groceries", []string{"d_aldi", "amt_5"})
+	before := clone(m)
+
+	features := []string{"d_tesco", "n_wine", "amt_4"}
+	m.Learn("groceries", features)
+	m.Learn("alcohol", features)
+	m.Forget("alcohol", features)
+	m.Forget("groceries", features)
+	if !reflect.DeepEqual(m, before) {
+		t.Errorf("after learning and forgetting the model is %+v, want %+v", m, before)
+	}
+
+	m.Forget("groceries", []string{"d_tesco", "amt_5"})
+	m.Forget("groceries", []string{"d_aldi", "amt_5"})
+	if m.Docs != 0 || len(m.Classes) != 0 {
+		t.Errorf("forgetting every example left %+v", m)
+	}
+}
+
+func TestForgetIgnoresWhatWasNeverLearned(t *testing.T) {
+	var m Model
+	m.Learn("groceries", []string{"d_tesco"})
+	m.Learn("groceries", []string{"d_aldi"})
+
+	m.Forget("travel", []string{"d_tesco"})
+	// d_unknown was never learned, so its count can't go negative
+	m.Forget("groceries", []string{"d_tesco", "d_unknown"})
+	want := Model{Docs: 1, Classes: []Class{{Name: "groceries", Docs: 1, Tokens: 1, Features: map[string]int{"d_aldi": 1}}}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("model is %+v, want %+v", m, want)
+	}
+}
+
+func clone(m Model) Model {
+	out := Model{Docs: m.Docs}
+	for _, c := range m.Classes {
+		features := make(map[string]int, len(c.Features))
+		for f, n := range c.Features {
+			features[f] = n
+		}
+		c.Features = features
+		out.Classes = append(out.Classes, c)
+	}
+	return out
+}
@@ -17,4 +17,6 @@ const (
 	ErrPasswordMismatch     = "passwords do not match"
 	ErrEmailAlreadyVerified = "email already verified"
 	ErrNoDocuments          = "no documents found"
+	ErrPreconditionFailed   = "precondition failed"
+	ErrPreconditionRequired = "precondition required"
 )
@@ -0,0 +1,215 @@
+// Package csrf protects cookie-authenticated requests from cross-site request
+// forgery.
+//
+// It uses signed double-submit tokens: the token is an HMAC over a random
+// nonce and the session cookie, sent both as a cookie and in the X-CSRF-Token
+// header. An attacker can neither read the cookie nor mint a token for the
+// victim's session, and a token leaked from another session is useless. The
+// Origin (or Referer) header is checked as a second layer.
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	CookieName = "csrf_token"
+	HeaderName = "X-CSRF-Token"
+
+	// SessionCookie is the cookie tokens are bound to.
+	SessionCookie = "auth_token"
+
+	// MaxAge matches the lifetime of the session cookie.
+	MaxAge = 3600 * 24 * 7
+)
+
+var (
+	ErrMissingToken = errors.New("missing CSRF token")
+	ErrInvalidToken = errors.New("invalid CSRF token")
+	ErrBadOrigin    = errors.New("request origin is not trusted")
+)
+
+// Config holds the cookie and origin settings, read from the environment by
+// FromEnv.
+type Config struct {
+	Secret         []byte
+	Domain         string
+	SameSite       http.SameSite
+	Secure         bool
+	TrustedOrigins []string
+}
+
+// FromEnv reads CSRF_SECRET (falling back to JWT_SECRET), COOKIE_DOMAIN,
+// COOKIE_SAMESITE (lax, strict or none) and CSRF_TRUSTED_ORIGINS (comma
+// separated, default APP_URL).
+func FromEnv() Config {
+	secret := os.Getenv("CSRF_SECRET")
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+
+	config := Config{
+		Secret:   []byte(secret),
+		Domain:   os.Getenv("COOKIE_DOMAIN"),
+		SameSite: http.SameSiteLaxMode,
+		Secure:   os.Getenv("GIN_MODE") == "release" || os.Getenv("NODE_ENV") == "production",
+	}
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		// browsers drop SameSite=None cookies that aren't Secure
+		config.SameSite = http.SameSiteNoneMode
+		config.Secure = true
+	}
+
+	origins := os.Getenv("CSRF_TRUSTED_ORIGINS")
+	if origins == "" {
+		origins = os.Getenv("APP_URL")
+	}
+	if origins == "" {
+		origins = "http://localhost:3000"
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			config.TrustedOrigins = append(config.TrustedOrigins, strings.ToLower(origin))
+		}
+	}
+	return config
+}
+
+// Cookie builds a cookie with the configured domain, SameSite and Secure
+// attributes. A negative maxAge deletes it.
+func (cfg Config) Cookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   cfg.Domain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   cfg.Secure,
+		SameSite: cfg.SameSite,
+	}
+}
+
+// NewToken returns a token bound to session, the value of the session cookie.
+func (cfg Config) NewToken(session string) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + cfg.sign(session, encoded), nil
+}
+
+func (cfg Config) sign(session, nonce string) string {
+	mac := hmac.New(sha256.New, cfg.Secret)
+	mac.Write([]byte(session))
+	mac.Write([]byte{0})
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidToken reports whether token was issued for session.
+func (cfg Config) ValidToken(session, token string) bool {
+	nonce, sig, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" || session == "" || len(cfg.Secret) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(cfg.sign(session, nonce)))
+}
+
+// Issue creates a token for the session and sets it as a cookie. The cookie
+// is readable by scripts so the frontend can echo it in the header.
+func (cfg Config) Issue(w http.ResponseWriter, session string) (string, error) {
+	token, err := cfg.NewToken(session)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, cfg.Cookie(CookieName, token, MaxAge, false))
+	return token, nil
+}
+
+// Clear deletes the token cookie.
+func (cfg Config) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, cfg.Cookie(CookieName, "", -1, false))
+}
+
+// Unsafe reports whether method can change state and must be protected.
+func Unsafe(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Verify checks an unsafe request made with a session cookie. The header and
+// cookie tokens must match, be signed for this session, and the request
+// must not come from an untrusted origin.
+func (cfg Config) Verify(r *http.Request) error {
+	if err := cfg.checkOrigin(r); err != nil {
+		return err
+	}
+
+	header := r.Header.Get(HeaderName)
+	cookie, err := r.Cookie(CookieName)
+	if header == "" || err != nil || cookie.Value == "" {
+		return ErrMissingToken
+	}
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return ErrInvalidToken
+	}
+
+	session, err := r.Cookie(SessionCookie)
+	if err != nil || !cfg.ValidToken(session.Value, header) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// checkOrigin compares the Origin header, or the Referer when Origin is
+// absent, with the request's own host and the trusted origins. Requests
+// carrying neither header are left to the token check.
+func (cfg Config) checkOrigin(r *http.Request) error {
+	source := r.Header.Get("Origin")
+	if source == "null" {
+		return ErrBadOrigin
+	}
+	if source == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return nil
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return ErrBadOrigin
+		}
+		source = u.Scheme + "://" + u.Host
+	}
+
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return ErrBadOrigin
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, trusted := range cfg.TrustedOrigins {
+		if origin == trusted {
+			return nil
+		}
+	}
+	return ErrBadOrigin
+}
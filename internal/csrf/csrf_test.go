@@ -0,0 +1,130 @@
+package csrf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testConfig = Config{Secret: []byte("test-secret"), TrustedOrigins: []string{"https://app.example.com"}}
+
+func TestValidToken(t *testing.T) {
+	token, err := testConfig.NewToken("session-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, sig, _ := strings.Cut(token, ".")
+	other, _ := testConfig.NewToken("session-a")
+	if other == token {
+		t.Fatal("NewToken returned the same token twice")
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		session string
+		token   string
+		want    bool
+	}{
+		{"issued for the session", testConfig, "session-a", token, true},
+		{"another token for the session", testConfig, "session-a", other, true},
+		{"another session", testConfig, "session-b", token, false},
+		{"another secret", Config{Secret: []byte("other-secret")}, "session-a", token, false},
+		{"no secret configured", Config{}, "session-a", token, false},
+		{"no session", testConfig, "", token, false},
+		{"tampered nonce", testConfig, "session-a", "x" + nonce + "." + sig, false},
+		{"tampered signature", testConfig, "session-a", nonce + "." + sig[1:], false},
+		{"no signature", testConfig, "session-a", nonce, false},
+		{"empty nonce", testConfig, "session-a", "." + sig, false},
+		{"empty", testConfig, "session-a", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ValidToken(tt.session, tt.token); got != tt.want {
+				t.Errorf("ValidToken = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	token, err := testConfig.NewToken("session-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, _ := testConfig.NewToken("session-b")
+
+	tests := []struct {
+		name    string
+		header  string
+		cookie  string
+		session string
+		origin  string
+		referer string
+		want    error
+	}{
+		{"valid", token, token, "session-a", "", "", nil},
+		{"same host origin", token, token, "session-a", "http://api.test", "", nil},
+		{"trusted origin", token, token, "session-a", "https://APP.example.com", "", nil},
+		{"trusted referer", token, token, "session-a", "", "https://app.example.com/settings", nil},
+		{"untrusted origin", token, token, "session-a", "https://evil.example", "", ErrBadOrigin},
+		{"untrusted referer", token, token, "session-a", "", "https://evil.example/page", ErrBadOrigin},
+		{"null origin", token, token, "session-a", "null", "", ErrBadOrigin},
+		{"missing header", "", token, "session-a", "", "", ErrMissingToken},
+		{"missing cookie", token, "", "session-a", "", "", ErrMissingToken},
+		{"header and cookie differ", token, foreign, "session-a", "", "", ErrInvalidToken},
+		{"token from another session", foreign, foreign, "session-a", "", "", ErrInvalidToken},
+		{"no session cookie", token, token, "", "", "", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://api.test/api/v1/transactions", nil)
+			if tt.header != "" {
+				req.Header.Set(HeaderName, tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CookieName, Value: tt.cookie})
+			}
+			if tt.session != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.session})
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			if err := testConfig.Verify(req); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueSetsReadableCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	token, err := testConfig.Issue(w, "session-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CookieName || cookies[0].Value != token {
+		t.Fatalf("Issue set cookies %v, want %s=%s", cookies, CookieName, token)
+	}
+	if cookies[0].HttpOnly {
+		t.Error("the csrf cookie must be readable by scripts")
+	}
+}
+
+func TestUnsafe(t *testing.T) {
+	for method, want := range map[string]bool{
+		http.MethodGet: false, http.MethodHead: false, http.MethodOptions: false,
+		http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+	} {
+		if got := Unsafe(method); got != want {
+			t.Errorf("Unsafe(%s) = %v, want %v", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/Joshua-takyi/expense/server/internal/format"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// WriteTransactionsCSV writes txs as CSV with dates in the user's timezone
+// and amounts formatted for their locale and currency. The raw amount column
+// stays machine readable. Split transactions get a row per line, sharing the
+// transaction_id, so the amount column can be summed by category.
+func WriteTransactionsCSV(w io.Writer, txs []models.Transaction, prefs *models.Preferences) error {
+	loc := prefs.Calendar().Location
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"date", "type", "category", "description", "note", "amount", "currency", "formatted_amount", "transaction_id"}); err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		for _, line := range tx.Lines() {
+			record := []string{
+				tx.CreatedAt.In(loc).Format("2006-01-02 15:04:05 -0700"),
+				tx.Type,
+				line.Category,
+				tx.Description,
+				line.Note,
+				strconv.FormatFloat(line.Amount, 'f', 2, 64),
+				prefs.Currency,
+				format.Money(prefs.Locale, prefs.Currency, line.Amount),
+				tx.Id.Hex(),
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,120 @@
+// Package export writes personal data export archives to local disk.
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// Retention is how long a finished archive stays downloadable.
+const Retention = 7 * 24 * time.Hour
+
+// Dir returns the directory archives are written to, EXPORTS_DIR or a
+// folder under the system temp dir.
+func Dir() string {
+	if dir := os.Getenv("EXPORTS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "expense-exports")
+}
+
+// Run collects the user's data for job, writes it as a zip archive and
+// records the outcome on the job.
+func Run(ctx context.Context, r models.Service, job *models.DataExport) {
+	if err := r.SetDataExportProcessing(ctx, job.Id); err != nil {
+		return
+	}
+
+	path, size, err := write(ctx, r, job)
+	if err != nil {
+		_ = r.FailDataExport(ctx, job.Id, err.Error())
+		return
+	}
+	if err := r.CompleteDataExport(ctx, job.Id, path, size, time.Now().Add(Retention)); err != nil {
+		_ = os.Remove(path)
+	}
+}
+
+func write(ctx context.Context, r models.Service, job *models.DataExport) (string, int64, error) {
+	data, err := r.CollectUserData(ctx, job.UserId)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to collect user data: %w", err)
+	}
+
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return "", 0, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	path := filepath.Join(Dir(), job.Id.Hex()+".zip")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create export file: %w", err)
+	}
+
+	archive := zip.NewWriter(file)
+	entries := map[string]interface{}{
+		"profile.json":      data.Profile,
+		"preferences.json":  data.Preferences,
+		"transactions.json": data.Transactions,
+		"api_tokens.json":   data.APITokens,
+		"audit_events.json": data.AuditEvents,
+	}
+	for name, docs := range data.Other {
+		entries[name+".json"] = docs
+	}
+	for name, value := range entries {
+		if err = writeJSON(archive, name, value); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		var w io.Writer
+		if w, err = archive.Create("transactions.csv"); err == nil {
+			err = WriteTransactionsCSV(w, data.Transactions, data.Preferences)
+		}
+	}
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", 0, fmt.Errorf("failed to write export archive: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat export archive: %w", err)
+	}
+	return path, info.Size(), nil
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(value)
+}
+
+// Remove deletes an archive file, ignoring files that are already gone.
+func Remove(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
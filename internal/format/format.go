@@ -0,0 +1,25 @@
+// Package format renders values for a user's locale.
+package format
+
+import (
+	"golang.org/x/text/currency"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Money formats amount in currencyCode with the grouping and decimal
+// separators of locale, e.g. "€ 1.234,50" for de-DE. Unknown locales fall back
+// to English and unknown currencies to a plain number.
+func Money(locale, currencyCode string, amount float64) string {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		tag = language.English
+	}
+	p := message.NewPrinter(tag)
+
+	unit, err := currency.ParseISO(currencyCode)
+	if err != nil {
+		return p.Sprintf("%.2f", amount)
+	}
+	return p.Sprint(currency.Symbol(unit.Amount(amount)))
+}
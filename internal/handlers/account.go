@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/export"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+const defaultDeletionGrace = 14 * 24 * time.Hour
+
+// deletionGrace is how long a requested account deletion can still be
+// cancelled, configured with ACCOUNT_DELETION_GRACE (e.g. "72h").
+func deletionGrace() time.Duration {
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE"); value != "" {
+		if grace, err := time.ParseDuration(value); err == nil && grace >= 0 {
+			return grace
+		}
+		log.Printf("invalid ACCOUNT_DELETION_GRACE %q, using default", value)
+	}
+	return defaultDeletionGrace
+}
+
+const defaultExportTimeout = 30 * time.Minute
+
+// exportTimeout bounds a data export job, configured with
+// DATA_EXPORT_TIMEOUT (e.g. "1h"). Large accounts need far longer than a
+// request.
+func exportTimeout() time.Duration {
+	if value := os.Getenv("DATA_EXPORT_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("invalid DATA_EXPORT_TIMEOUT %q, using default", value)
+	}
+	return defaultExportTimeout
+}
+
+func RequestDataExport(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		exports, err := r.ListDataExports(ctx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create data export"})
+			return
+		}
+		for _, e := range exports {
+			if e.Status == models.ExportPending || e.Status == models.ExportProcessing {
+				c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "a data export is already in progress", "data": e})
+				return
+			}
+		}
+
+		job, err := r.CreateDataExport(ctx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create data export"})
+			return
+		}
+
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), exportTimeout())
+			defer cancel()
+			export.Run(ctx, r, job)
+		}()
+
+		audit(c, r, models.AuditDataExportRequested, userID, primitive.NilObjectID, map[string]interface{}{"export_id": job.Id.Hex()})
+		c.JSON(202, gin.H{"message": "data export started", "data": job})
+	}
+}
+
+func ListDataExports(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		exports, err := r.ListDataExports(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list data exports"})
+			return
+		}
+		c.JSON(200, gin.H{"data": exports})
+	}
+}
+
+func DownloadDataExport(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid export ID"})
+			return
+		}
+
+		job, err := r.GetDataExport(c.Request.Context(), id, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "data export not found"})
+			return
+		}
+		if job.Status != models.ExportReady || (job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)) {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "data export is not available for download"})
+			return
+		}
+
+		audit(c, r, models.AuditDataExportDownload, userID, primitive.NilObjectID, map[string]interface{}{"export_id": job.Id.Hex()})
+		c.FileAttachment(job.FilePath, fmt.Sprintf("expense-data-%s.zip", job.CreatedAt.Format("2006-01-02")))
+	}
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// ScheduleAccountDeletion starts the grace period after which the account and
+// all of its data are erased.
+func ScheduleAccountDeletion(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		// accounts without a password may send no body at all
+		var req deleteAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body"})
+			return
+		}
+
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		if user.DeletionScheduledAt != nil {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "account deletion is already scheduled", "deletion_scheduled_at": user.DeletionScheduledAt})
+			return
+		}
+		// accounts created through single sign-on have no password to confirm
+		hasPassword, err := r.UserHasPassword(ctx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to schedule account deletion"})
+			return
+		}
+		if hasPassword {
+			_, err := r.AuthenticateUser(ctx, user.Email, req.Password)
+			switch {
+			case errors.Is(err, models.ErrInvalidCredentials):
+				c.JSON(401, gin.H{"error": constants.ErrInvalidCredentials, "message": "invalid password"})
+				return
+			case errors.Is(err, models.ErrPasswordResetRequired):
+				c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "a password reset is required first"})
+				return
+			case err != nil:
+				c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to schedule account deletion"})
+				return
+			}
+		}
+
+		at := time.Now().Add(deletionGrace())
+		if err := r.ScheduleAccountDeletion(ctx, userID, at); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to schedule account deletion"})
+			return
+		}
+
+		audit(c, r, models.AuditDeletionScheduled, userID, primitive.NilObjectID, map[string]interface{}{"deletion_scheduled_at": at})
+		c.JSON(202, gin.H{"message": "account scheduled for deletion, sign in and cancel before then to keep it", "deletion_scheduled_at": at})
+	}
+}
+
+func CancelAccountDeletion(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		if err := r.CancelAccountDeletion(c.Request.Context(), userID); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "no account deletion is scheduled"})
+			return
+		}
+		audit(c, r, models.AuditDeletionCancelled, userID, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{"message": "account deletion cancelled"})
+	}
+}
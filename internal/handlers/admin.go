@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// adminTarget resolves the :id user an admin action applies to. Admins can't
+// act on their own account here so they can't lock themselves out.
+func adminTarget(c *gin.Context, r models.Service) (adminID primitive.ObjectID, target *models.User, ok bool) {
+	adminID, ok = currentUserID(c)
+	if !ok {
+		return adminID, nil, false
+	}
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+		return adminID, nil, false
+	}
+	if id == adminID {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "admins cannot perform this action on their own account"})
+		return adminID, nil, false
+	}
+
+	target, err = r.GetUserProfile(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+		return adminID, nil, false
+	}
+	return adminID, target, true
+}
+
+func AdminListUsers(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := c.DefaultQuery("limit", "20")
+		offset := c.DefaultQuery("offset", "0")
+		adminID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		users, total, err := r.ListUsers(c.Request.Context(), c.Query("search"), helpers.ParseInt(limit), helpers.ParseInt(offset))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list users"})
+			return
+		}
+		audit(c, r, models.AuditAdminUsersListed, adminID, primitive.NilObjectID, map[string]interface{}{"search": c.Query("search"), "limit": limit, "offset": offset})
+		c.JSON(200, gin.H{"data": users, "total": total})
+	}
+}
+
+func AdminGetUser(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+			return
+		}
+		user, err := r.GetUserProfile(c.Request.Context(), id)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		audit(c, r, models.AuditAdminUserViewed, adminID, user.Id, nil)
+		c.JSON(200, gin.H{"data": user})
+	}
+}
+
+func AdminSetUserDisabled(r models.Service, disabled bool) gin.HandlerFunc {
+	action, message := models.AuditAdminUserEnabled, "user enabled"
+	if disabled {
+		action, message = models.AuditAdminUserDisabled, "user disabled"
+	}
+	return func(c *gin.Context) {
+		adminID, target, ok := adminTarget(c, r)
+		if !ok {
+			return
+		}
+
+		if err := r.SetUserDisabled(c.Request.Context(), target.Id, disabled); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update user"})
+			return
+		}
+		audit(c, r, action, adminID, target.Id, nil)
+		c.JSON(200, gin.H{"message": message})
+	}
+}
+
+type setRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user admin"`
+}
+
+func AdminSetUserRole(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req setRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "role must be user or admin"})
+			return
+		}
+
+		adminID, target, ok := adminTarget(c, r)
+		if !ok {
+			return
+		}
+
+		if err := r.SetUserRole(c.Request.Context(), target.Id, req.Role); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update role"})
+			return
+		}
+		audit(c, r, models.AuditAdminRoleChanged, adminID, target.Id, map[string]interface{}{"from": target.Role, "to": req.Role})
+		c.JSON(200, gin.H{"message": "role updated"})
+	}
+}
+
+func AdminForcePasswordReset(r models.Service, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID, target, ok := adminTarget(c, r)
+		if !ok {
+			return
+		}
+
+		if err := r.RequirePasswordReset(c.Request.Context(), target.Id); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to force password reset"})
+			return
+		}
+		go sendPasswordResetEmail(r, m, target.Email, "An administrator has required you to choose a new password before signing in again.")
+
+		audit(c, r, models.AuditAdminPasswordForced, adminID, target.Id, nil)
+		c.JSON(200, gin.H{"message": "password reset required, the user has been emailed a reset link"})
+	}
+}
+
+func AdminRevokeSessions(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID, target, ok := adminTarget(c, r)
+		if !ok {
+			return
+		}
+
+		if err := r.RevokeUserSessions(c.Request.Context(), target.Id); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to revoke sessions"})
+			return
+		}
+		audit(c, r, models.AuditAdminSessionsRevoke, adminID, target.Id, nil)
+		c.JSON(200, gin.H{"message": "sessions and api tokens revoked"})
+	}
+}
+
+func AdminSystemStats(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		stats, err := r.GetSystemStats(c.Request.Context())
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute statistics"})
+			return
+		}
+		audit(c, r, models.AuditAdminStatsViewed, adminID, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{"data": stats})
+	}
+}
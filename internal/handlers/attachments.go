@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/storage"
+)
+
+const (
+	defaultAttachmentMaxSize     = 10 << 20
+	maxAttachmentsPerTransaction = 20
+)
+
+// attachmentTypes are the sniffed content types accepted as attachments:
+// photos and scans of receipts, PDFs and plain text.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// attachmentMaxSize is the largest accepted file in bytes, configured with
+// ATTACHMENT_MAX_SIZE.
+func attachmentMaxSize() int64 {
+	if value := os.Getenv("ATTACHMENT_MAX_SIZE"); value != "" {
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > 0 {
+			return size
+		}
+		log.Printf("invalid ATTACHMENT_MAX_SIZE %q, using default", value)
+	}
+	return defaultAttachmentMaxSize
+}
+
+// attachmentFilename keeps the base name of an uploaded file without control
+// characters, so it is safe to echo in a Content-Disposition header.
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || strings.TrimSpace(name) == "" {
+		return "attachment"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+	return name
+}
+
+// UploadAttachment stores the multipart "file" field with a transaction. The
+// content type is sniffed from the file itself, not taken from the client.
+func UploadAttachment(r models.Service, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := liveTransaction(c, r, userID, models.LedgerEditor)
+		if !ok {
+			return
+		}
+
+		count, err := r.CountAttachments(ctx, tx.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to count attachments"})
+			return
+		}
+		if count >= maxAttachmentsPerTransaction {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": fmt.Sprintf("a transaction can have at most %d attachments", maxAttachmentsPerTransaction)})
+			return
+		}
+
+		maxSize := attachmentMaxSize()
+		// leave room for the multipart framing around the file
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+64<<10)
+		header, err := c.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(413, gin.H{"error": constants.ErrInvalidInput, "message": fmt.Sprintf("file exceeds the %d byte limit", maxSize)})
+				return
+			}
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "a file is required in the \"file\" field"})
+			return
+		}
+		if header.Size > maxSize {
+			c.JSON(413, gin.H{"error": constants.ErrInvalidInput, "message": fmt.Sprintf("file exceeds the %d byte limit", maxSize)})
+			return
+		}
+		if header.Size == 0 {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "file is empty"})
+			return
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to read upload"})
+			return
+		}
+		defer file.Close()
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to read upload"})
+			return
+		}
+		contentType := http.DetectContentType(head[:n])
+		if mediaType, _, _ := mime.ParseMediaType(contentType); !attachmentTypes[mediaType] {
+			c.JSON(415, gin.H{"error": constants.ErrInvalidInput, "message": "unsupported file type " + contentType + ", upload an image, PDF or text file"})
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to read upload"})
+			return
+		}
+
+		attachment := models.Attachment{
+			Id:            primitive.NewObjectID(),
+			UserId:        tx.UserId,
+			TransactionId: tx.Id,
+			Filename:      attachmentFilename(header.Filename),
+			ContentType:   contentType,
+			Size:          header.Size,
+		}
+		attachment.StorageKey = models.AttachmentKey(attachment.UserId, attachment.Id)
+		if err := files.Put(ctx, attachment.StorageKey, file, attachment.Size, contentType); err != nil {
+			log.Printf("failed to store attachment %s: %v", attachment.Id.Hex(), err)
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to store attachment"})
+			return
+		}
+		if err := r.CreateAttachment(ctx, &attachment); err != nil {
+			if err := files.Delete(ctx, attachment.StorageKey); err != nil {
+				log.Printf("failed to remove stored file of attachment %s: %v", attachment.Id.Hex(), err)
+			}
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to save attachment"})
+			return
+		}
+		c.JSON(201, gin.H{"message": "attachment uploaded", "data": attachment})
+	}
+}
+
+func ListAttachments(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := liveTransaction(c, r, userID, models.LedgerViewer)
+		if !ok {
+			return
+		}
+
+		attachments, err := r.ListAttachments(c.Request.Context(), tx.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list attachments"})
+			return
+		}
+		c.JSON(200, gin.H{"data": attachments})
+	}
+}
+
+// transactionAttachment loads the :attachment_id attachment of a live
+// transaction the user has the role need on, writing the error response if it
+// can't.
+func transactionAttachment(c *gin.Context, r models.Service, need string) (*models.Attachment, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil, false
+	}
+	tx, ok := liveTransaction(c, r, userID, need)
+	if !ok {
+		return nil, false
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("attachment_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid attachment ID"})
+		return nil, false
+	}
+	attachment, err := r.GetAttachment(c.Request.Context(), id, tx.Id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "attachment not found"})
+		return nil, false
+	}
+	return attachment, true
+}
+
+// DownloadAttachment always serves the file as a download with the sniffed
+// content type, so an uploaded file is never rendered as a page of the API.
+func DownloadAttachment(r models.Service, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attachment, ok := transactionAttachment(c, r, models.LedgerViewer)
+		if !ok {
+			return
+		}
+
+		body, err := files.Get(c.Request.Context(), attachment.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "attachment file is missing"})
+			return
+		}
+		if err != nil {
+			log.Printf("failed to read attachment %s: %v", attachment.Id.Hex(), err)
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to read attachment"})
+			return
+		}
+		defer body.Close()
+
+		c.DataFromReader(200, attachment.Size, attachment.ContentType, body, map[string]string{
+			"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}),
+			"X-Content-Type-Options": "nosniff",
+			"Cache-Control":          "private, no-store",
+		})
+	}
+}
+
+func DeleteAttachment(r models.Service, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		attachment, ok := transactionAttachment(c, r, models.LedgerEditor)
+		if !ok {
+			return
+		}
+
+		if err := removeAttachment(c, r, files, attachment); err != nil {
+			log.Printf("failed to delete attachment %s: %v", attachment.Id.Hex(), err)
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to delete attachment"})
+			return
+		}
+		audit(c, r, models.AuditAttachmentDeleted, userID, primitive.NilObjectID, map[string]interface{}{
+			"transaction_id": attachment.TransactionId.Hex(),
+			"attachment_id":  attachment.Id.Hex(),
+			"filename":       attachment.Filename,
+		})
+		c.JSON(200, gin.H{"message": "attachment deleted"})
+	}
+}
+
+// removeAttachment deletes the stored file before the record, so a failure
+// never leaves a file nobody can find.
+func removeAttachment(c *gin.Context, r models.Service, files storage.Store, attachment *models.Attachment) error {
+	if err := files.Delete(c.Request.Context(), attachment.StorageKey); err != nil {
+		return err
+	}
+	return r.DeleteAttachment(c.Request.Context(), attachment.Id)
+}
+
+// removeTransactionAttachments cleans up after a permanent delete. Whatever
+// fails here is picked up by the orphaned attachment sweep.
+func removeTransactionAttachments(c *gin.Context, r models.Service, files storage.Store, ids ...primitive.ObjectID) {
+	for _, id := range ids {
+		attachments, err := r.ListAttachments(c.Request.Context(), id)
+		if err != nil {
+			log.Printf("failed to list attachments of transaction %s: %v", id.Hex(), err)
+			continue
+		}
+		for i := range attachments {
+			if err := removeAttachment(c, r, files, &attachments[i]); err != nil {
+				log.Printf("failed to delete attachment %s: %v", attachments[i].Id.Hex(), err)
+			}
+		}
+	}
+}
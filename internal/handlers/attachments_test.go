@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAttachmentFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "receipt.pdf", "receipt.pdf"},
+		{"unix path", "/home/ada/scans/receipt.pdf", "receipt.pdf"},
+		{"windows path", `C:\Users\Ada\receipt.pdf`, "receipt.pdf"},
+		{"traversal", "../../etc/passwd", "passwd"},
+		{"header injection", "a.pdf\r\nX-Evil: 1", "a.pdfX-Evil: 1"},
+		{"control characters", "re\x00ce\x7fipt.png", "receipt.png"},
+		{"unicode kept", "reçu été.jpg", "reçu été.jpg"},
+		{"empty", "", "attachment"},
+		{"only a slash", "/", "attachment"},
+		{"only a dot", ".", "attachment"},
+		{"blank", "  ", "attachment"},
+		{"only control characters", "\r\n", "attachment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attachmentFilename(tt.in); got != tt.want {
+				t.Errorf("attachmentFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttachmentFilenameIsCapped(t *testing.T) {
+	got := attachmentFilename(strings.Repeat("é", 300) + ".pdf")
+	if n := len([]rune(got)); n != 255 {
+		t.Errorf("kept %d characters, want 255", n)
+	}
+}
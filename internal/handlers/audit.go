@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/requestid"
+)
+
+// audit appends an event for the current request. Failing to write the audit
+// trail is logged but doesn't fail the action that already happened.
+func audit(c *gin.Context, r models.Service, action string, actorID, targetID primitive.ObjectID, details map[string]interface{}) {
+	event := &models.AuditEvent{
+		Action:    action,
+		ActorId:   actorID,
+		TargetId:  targetID,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestId: requestid.Get(c),
+		Details:   details,
+	}
+	if err := r.RecordAuditEvent(c.Request.Context(), event); err != nil {
+		log.Printf("failed to record audit event %s: %v", action, err)
+	}
+}
+
+func auditLogin(c *gin.Context, r models.Service, user *models.User, method string) {
+	audit(c, r, models.AuditLoginSucceeded, user.Id, primitive.NilObjectID, map[string]interface{}{"method": method})
+}
+
+func ListSecurityEvents(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := c.DefaultQuery("limit", "20")
+		offset := c.DefaultQuery("offset", "0")
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		events, err := r.ListUserAuditEvents(c.Request.Context(), userID, helpers.ParseInt(limit), helpers.ParseInt(offset))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list security events"})
+			return
+		}
+		c.JSON(200, gin.H{"data": events})
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+)
+
+// currentUserID reads the claims set by auth.Middleware. On failure it writes
+// the error response and returns false, so handlers can simply return.
+func currentUserID(c *gin.Context) (primitive.ObjectID, bool) {
+	userClaims, exists := c.Get("user")
+	if !exists {
+		c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "unauthorized"})
+		return primitive.NilObjectID, false
+	}
+
+	claims, ok := userClaims.(*helpers.UseClaims)
+	if !ok {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+		return primitive.NilObjectID, false
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
@@ -3,13 +3,21 @@ package handlers
 import (
 	"net/http"
 
-	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/csrf"
 	"github.com/gin-gonic/gin"
 )
 
+// CSRFHandler issues a fresh token bound to the caller's session, e.g. for a
+// frontend that lost the one returned at login.
 func CSRFHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		csrfToken, err := helpers.GenerateCsrfToken()
+		session, err := c.Cookie(csrf.SessionCookie)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization token not provided"})
+			return
+		}
+
+		csrfToken, err := csrf.FromEnv().Issue(c.Writer, session)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"message": "failed to generate csrf token",
@@ -18,8 +26,6 @@ func CSRFHandler() gin.HandlerFunc {
 			return
 		}
 
-		c.SetCookie("csrf_token", csrfToken, 3600*24*7, "/", "localhost", true, false)
-
 		c.JSON(http.StatusOK, gin.H{
 			"message":    "CSRF token provided",
 			"csrf_token": csrfToken,
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+)
+
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersion returns the version the client last saw, taken from the
+// If-Match header, once it matches current. A missing header gets 428 and a
+// stale one 412. "*" matches any version. If-Match uses the strong comparison
+// (RFC 7232 §3.1), so a weak W/"..." tag never matches.
+func ifMatchVersion(c *gin.Context, current int64) (int64, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		c.JSON(428, gin.H{"error": constants.ErrPreconditionRequired, "message": "If-Match header with the resource's ETag is required"})
+		return 0, false
+	}
+	if header == "*" {
+		return current, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == etag(current) {
+			return current, true
+		}
+	}
+	preconditionFailed(c, current)
+	return 0, false
+}
+
+func preconditionFailed(c *gin.Context, current int64) {
+	if current > 0 {
+		c.Header("ETag", etag(current))
+	}
+	c.JSON(412, gin.H{"error": constants.ErrPreconditionFailed, "message": "the resource was modified since you last fetched it"})
+}
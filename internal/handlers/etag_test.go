@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+		status int
+	}{
+		{"missing", "", false, 428},
+		{"current", `"3"`, true, 200},
+		{"any", "*", true, 200},
+		{"one of several", `"1", "3"`, true, 200},
+		{"stale", `"2"`, false, 412},
+		{"weak", `W/"3"`, false, 412},
+		{"unquoted", "3", false, 412},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("PUT", "/", nil)
+			if tt.header != "" {
+				c.Request.Header.Set("If-Match", tt.header)
+			}
+
+			version, ok := ifMatchVersion(c, 3)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && version != 3 {
+				t.Errorf("version = %d, want 3", version)
+			}
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
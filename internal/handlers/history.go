@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// recordRevision appends a transaction history entry and feeds the change to
+// the category suggestions. Like audit, failing to record history is logged
+// but doesn't fail the write that already happened.
+func recordRevision(c *gin.Context, r models.Service, action string, actorID primitive.ObjectID, before, after *models.Transaction) {
+	if err := r.RecordTransactionRevision(c.Request.Context(), action, actorID, before, after); err != nil {
+		log.Printf("failed to record %s revision of transaction %s: %v", action, after.Id.Hex(), err)
+	}
+	learnFromChange(c, r, before, after)
+}
+
+// recordChanges records an update revision for each transaction changed by a
+// bulk write.
+func recordChanges(c *gin.Context, r models.Service, actorID primitive.ObjectID, changes []models.TransactionChange) {
+	for _, change := range changes {
+		recordRevision(c, r, models.RevisionUpdated, actorID, change.Before, change.After)
+	}
+}
+
+func TransactionHistory(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := ownTransaction(c, r, userID, models.LedgerViewer)
+		if !ok {
+			return
+		}
+
+		revisions, err := r.ListTransactionRevisions(c.Request.Context(), tx.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to fetch transaction history"})
+			return
+		}
+		c.JSON(200, gin.H{"data": revisions})
+	}
+}
+
+type revertTransactionRequest struct {
+	Version int64 `json:"version" binding:"required,min=1"`
+}
+
+// RevertTransaction restores the fields of a transaction to how they were at
+// an earlier version. The revert is itself a new version, so it can be undone
+// the same way. Like any update it requires If-Match.
+func RevertTransaction(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req revertTransactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		tx, ok := liveTransaction(c, r, userID, models.LedgerEditor)
+		if !ok || !checkSettlementParty(c, r, userID, tx) {
+			return
+		}
+		version, ok := ifMatchVersion(c, tx.Version)
+		if !ok {
+			return
+		}
+
+		revision, err := r.GetTransactionRevision(ctx, tx.Id, req.Version)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "no history for that version"})
+			return
+		}
+
+		updates, ok := revertUpdates(c, r, userID, tx, &revision.Snapshot)
+		if !ok {
+			return
+		}
+		reverted, err := updateTransaction(c, r, models.RevisionReverted, userID, tx, version, updates)
+		if err != nil {
+			transactionWriteFailed(c, err, "failed to revert transaction")
+			return
+		}
+
+		c.Header("ETag", etag(reverted.Version))
+		c.JSON(200, gin.H{"message": "transaction reverted", "reverted_to": req.Version, "data": reverted})
+	}
+}
+
+// revertUpdates returns the fields to restore from snapshot. The payee,
+// account and sharing it refers to may have changed since, so those that
+// differ from the current transaction are checked again the way
+// UpdateTransaction checks them.
+func revertUpdates(c *gin.Context, r models.Service, userID primitive.ObjectID, tx, snapshot *models.Transaction) (map[string]interface{}, bool) {
+	ctx := c.Request.Context()
+	updates := snapshot.EditableFields()
+
+	if snapshot.PayeeId != nil && !reflect.DeepEqual(snapshot.PayeeId, tx.PayeeId) {
+		if _, err := r.GetPayee(ctx, *snapshot.PayeeId, userID); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "the payee of that version no longer exists"})
+			return nil, false
+		}
+	}
+	if !reflect.DeepEqual(snapshot.AccountId, tx.AccountId) && !checkTransactionAccount(c, r, userID, snapshot.AccountId) {
+		return nil, false
+	}
+
+	if snapshot.Sharing == nil || reflect.DeepEqual(snapshot.Sharing, tx.Sharing) {
+		return updates, true
+	}
+	var ledger *models.Ledger
+	if tx.LedgerId != nil {
+		var err error
+		if ledger, err = r.GetLedger(ctx, *tx.LedgerId); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load ledger"})
+			return nil, false
+		}
+	}
+	sharing := *snapshot.Sharing
+	sharing.Shares = append([]models.MemberShare(nil), snapshot.Sharing.Shares...)
+	if snapshot.Type == models.TypeSettlement {
+		if ledger == nil || len(sharing.Shares) != 1 {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid settlement"})
+			return nil, false
+		}
+		if !checkSettlement(c, r, ledger, sharing.PaidBy, sharing.Shares[0].UserId) {
+			return nil, false
+		}
+	} else if !checkSharing(c, ledger, snapshot.Type, &sharing, snapshot.Amount) {
+		return nil, false
+	}
+	updates["sharing"] = &sharing
+	return updates, true
+}
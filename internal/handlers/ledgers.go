@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// memberLedger loads a ledger the user belongs to with at least the role
+// need, writing the error response itself otherwise. Ledgers the user isn't
+// a member of are reported as missing.
+func memberLedger(c *gin.Context, r models.Service, userID, id primitive.ObjectID, need string) (*models.Ledger, bool) {
+	ledger, err := r.GetLedger(c.Request.Context(), id)
+	if err != nil || ledger.RoleOf(userID) == "" {
+		c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "ledger not found"})
+		return nil, false
+	}
+	if !models.LedgerRoleAllows(ledger.RoleOf(userID), need) {
+		c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "this requires the " + need + " role in the ledger"})
+		return nil, false
+	}
+	return ledger, true
+}
+
+// pathLedger is memberLedger for the ledger named in the path.
+func pathLedger(c *gin.Context, r models.Service, userID primitive.ObjectID, need string) (*models.Ledger, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid ledger ID"})
+		return nil, false
+	}
+	return memberLedger(c, r, userID, id, need)
+}
+
+// transactionScope reads ?ledger_id=: without it queries cover the user's
+// personal transactions, with it every transaction of that ledger.
+func transactionScope(c *gin.Context, r models.Service, userID primitive.ObjectID) (models.Scope, bool) {
+	value := c.Query("ledger_id")
+	if value == "" {
+		return models.PersonalScope(userID), true
+	}
+	id, err := primitive.ObjectIDFromHex(value)
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid ledger ID"})
+		return models.Scope{}, false
+	}
+	if _, ok := memberLedger(c, r, userID, id, models.LedgerViewer); !ok {
+		return models.Scope{}, false
+	}
+	return models.LedgerScope(id), true
+}
+
+// transactionRole is the user's role on tx: owner of their own personal
+// transactions, their ledger role on shared ones, "" otherwise.
+func transactionRole(c *gin.Context, r models.Service, userID primitive.ObjectID, tx *models.Transaction) (string, error) {
+	if tx.LedgerId == nil {
+		if tx.UserId == userID {
+			return models.LedgerOwner, nil
+		}
+		return "", nil
+	}
+	ledger, err := r.GetLedger(c.Request.Context(), *tx.LedgerId)
+	if err != nil {
+		return "", err
+	}
+	return ledger.RoleOf(userID), nil
+}
+
+type ledgerRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+func CreateLedger(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req ledgerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "name is required"})
+			return
+		}
+
+		ledger := models.Ledger{Name: name}
+		if err := r.CreateLedger(c.Request.Context(), &ledger, userID); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create ledger"})
+			return
+		}
+		c.JSON(201, gin.H{"message": "ledger created successfully", "data": ledger})
+	}
+}
+
+func ListLedgers(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		ledgers, err := r.ListLedgers(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list ledgers"})
+			return
+		}
+		c.JSON(200, gin.H{"data": ledgers})
+	}
+}
+
+// GetLedger returns the ledger with the name and email of each member.
+func GetLedger(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		ledger, ok := pathLedger(c, r, userID, models.LedgerViewer)
+		if !ok {
+			return
+		}
+
+		members := make([]gin.H, 0, len(ledger.Members))
+		for _, m := range ledger.Members {
+			member := gin.H{"user_id": m.UserId, "role": m.Role, "joined_at": m.JoinedAt}
+			if user, err := r.GetUserProfile(c.Request.Context(), m.UserId); err == nil {
+				member["name"], member["email"] = user.Name, user.Email
+			}
+			members = append(members, member)
+		}
+		c.JSON(200, gin.H{"data": gin.H{
+			"id":         ledger.Id,
+			"name":       ledger.Name,
+			"role":       ledger.RoleOf(userID),
+			"members":    members,
+			"created_at": ledger.CreatedAt,
+			"updated_at": ledger.UpdatedAt,
+		}})
+	}
+}
+
+func UpdateLedger(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req ledgerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "name is required"})
+			return
+		}
+
+		ledger, ok := pathLedger(c, r, userID, models.LedgerOwner)
+		if !ok {
+			return
+		}
+		updated, err := r.RenameLedger(c.Request.Context(), ledger.Id, name)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update ledger"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "ledger updated successfully", "data": updated})
+	}
+}
+
+func DeleteLedger(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		ledger, ok := pathLedger(c, r, userID, models.LedgerOwner)
+		if !ok {
+			return
+		}
+
+		if err := r.DeleteLedger(c.Request.Context(), ledger.Id); err != nil {
+			if errors.Is(err, models.ErrLedgerNotEmpty) {
+				c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "ledger has transactions, delete them and empty its trash first"})
+				return
+			}
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to delete ledger"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "ledger deleted successfully"})
+	}
+}
+
+// pathMember reads the :user_id of a ledger member from the path.
+func pathMember(c *gin.Context, ledger *models.Ledger) (primitive.ObjectID, bool) {
+	memberID, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+		return primitive.NilObjectID, false
+	}
+	if ledger.RoleOf(memberID) == "" {
+		c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "member not found"})
+		return primitive.NilObjectID, false
+	}
+	return memberID, true
+}
+
+type memberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+// UpdateLedgerMember changes the role of a member. Owners manage roles,
+// including handing over ownership; the last owner can't be demoted.
+func UpdateLedgerMember(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req memberRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		ledger, ok := pathLedger(c, r, userID, models.LedgerOwner)
+		if !ok {
+			return
+		}
+		memberID, ok := pathMember(c, ledger)
+		if !ok {
+			return
+		}
+
+		if err := r.SetLedgerMemberRole(c.Request.Context(), ledger.Id, memberID, req.Role); err != nil {
+			if errors.Is(err, models.ErrLastLedgerOwner) {
+				c.JSON(409, gin.H{"error": constants.ErrConflict, "message": err.Error()})
+				return
+			}
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to change member role"})
+			return
+		}
+		audit(c, r, models.AuditLedgerRoleChanged, userID, memberID, map[string]interface{}{
+			"ledger_id": ledger.Id.Hex(),
+			"from":      ledger.RoleOf(memberID),
+			"to":        req.Role,
+		})
+		c.JSON(200, gin.H{"message": "member role updated"})
+	}
+}
+
+// RemoveLedgerMember removes a member. Owners can remove anyone; everyone
+// can remove themselves to leave the ledger.
+func RemoveLedgerMember(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		ledger, ok := pathLedger(c, r, userID, models.LedgerViewer)
+		if !ok {
+			return
+		}
+		memberID, ok := pathMember(c, ledger)
+		if !ok {
+			return
+		}
+		if memberID != userID && ledger.RoleOf(userID) != models.LedgerOwner {
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "only owners can remove other members"})
+			return
+		}
+
+		if err := r.RemoveLedgerMember(c.Request.Context(), ledger.Id, memberID); err != nil {
+			if errors.Is(err, models.ErrLastLedgerOwner) {
+				c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "make another member owner or delete the ledger instead"})
+				return
+			}
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to remove member"})
+			return
+		}
+		audit(c, r, models.AuditLedgerMemberRemoved, userID, memberID, map[string]interface{}{"ledger_id": ledger.Id.Hex()})
+		c.JSON(200, gin.H{"message": "member removed"})
+	}
+}
+
+type invitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"omitempty,oneof=editor viewer"`
+}
+
+// InviteLedgerMember emails a link to join the ledger. Inviting the same
+// address again replaces the earlier link.
+func InviteLedgerMember(r models.Service, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req invitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		if req.Role == "" {
+			req.Role = models.LedgerEditor
+		}
+
+		ledger, ok := pathLedger(c, r, userID, models.LedgerOwner)
+		if !ok {
+			return
+		}
+		if invitee, err := r.GetUserByEmail(ctx, req.Email); err == nil && ledger.RoleOf(invitee.Id) != "" {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": models.ErrAlreadyLedgerMember.Error()})
+			return
+		}
+		inviter, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+			return
+		}
+
+		token, err := helpers.GenerateOneTimeToken()
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create invitation"})
+			return
+		}
+		invitation := models.LedgerInvitation{
+			LedgerId:  ledger.Id,
+			Email:     req.Email,
+			Role:      req.Role,
+			TokenHash: helpers.HashToken(token),
+			InvitedBy: userID,
+		}
+		if err := r.CreateLedgerInvitation(ctx, &invitation); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create invitation"})
+			return
+		}
+
+		body := fmt.Sprintf("Hi,\n\n%s invited you to the ledger \"%s\" as %s.\nJoin it here within the next 7 days: %s/ledgers/join?token=%s\n\n"+
+			"Sign in with this email address to accept. If you don't know %s you can ignore this email.\n",
+			inviter.Name, ledger.Name, invitation.Role, appURL(), token, inviter.Name)
+		if err := m.Send(ctx, invitation.Email, "You're invited to "+ledger.Name, body); err != nil {
+			log.Printf("failed to send ledger invitation: %v", err)
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to send invitation email"})
+			return
+		}
+		audit(c, r, models.AuditLedgerInvitationSent, userID, primitive.NilObjectID, map[string]interface{}{
+			"ledger_id": ledger.Id.Hex(),
+			"email":     invitation.Email,
+			"role":      invitation.Role,
+		})
+		c.JSON(201, gin.H{"message": "invitation sent", "data": invitation})
+	}
+}
+
+func ListLedgerInvitations(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		ledger, ok := pathLedger(c, r, userID, models.LedgerOwner)
+		if !ok {
+			return
+		}
+
+		invitations, err := r.ListLedgerInvitations(c.Request.Context(), ledger.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list invitations"})
+			return
+		}
+		c.JSON(200, gin.H{"data": invitations})
+	}
+}
+
+func RevokeLedgerInvitation(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		ledger, ok := pathLedger(c, r, userID, models.LedgerOwner)
+		if !ok {
+			return
+		}
+		id, err := primitive.ObjectIDFromHex(c.Param("invitation_id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid invitation ID"})
+			return
+		}
+
+		if err := r.DeleteLedgerInvitation(c.Request.Context(), id, ledger.Id); err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "invitation not found"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "invitation revoked"})
+	}
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// AcceptLedgerInvitation joins the ledger of an emailed invitation. The
+// signed-in user must have the email address the invitation was sent to.
+func AcceptLedgerInvitation(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req acceptInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		user, err := r.GetUserProfile(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+			return
+		}
+
+		ledger, err := r.AcceptLedgerInvitation(c.Request.Context(), helpers.HashToken(req.Token), user)
+		switch {
+		case errors.Is(err, models.ErrInvitationInvalid):
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return
+		case errors.Is(err, models.ErrInvitationEmailMismatch):
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": err.Error()})
+			return
+		case errors.Is(err, models.ErrAlreadyLedgerMember):
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": err.Error()})
+			return
+		case err != nil:
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to join ledger"})
+			return
+		}
+		audit(c, r, models.AuditLedgerMemberJoined, userID, primitive.NilObjectID, map[string]interface{}{
+			"ledger_id": ledger.Id.Hex(),
+			"role":      ledger.RoleOf(userID),
+		})
+		c.JSON(200, gin.H{"message": "joined ledger", "data": ledger})
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+func appURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
+
+// checkLoginThrottle rejects the request with 429 while either the account or
+// the client IP is backing off or locked. The response is the same whether or
+// not the email belongs to an account.
+func checkLoginThrottle(c *gin.Context, r models.Service, email string) bool {
+	ctx := c.Request.Context()
+	now := time.Now()
+
+	accountThrottle, err := r.GetLoginThrottle(ctx, models.AccountThrottleKey(email))
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+		return false
+	}
+	ipThrottle, err := r.GetLoginThrottle(ctx, models.IPThrottleKey(c.ClientIP()))
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+		return false
+	}
+
+	wait := accountThrottle.RetryAfter(models.AccountLoginPolicy, now)
+	if ipWait := ipThrottle.RetryAfter(models.IPLoginPolicy, now); ipWait > wait {
+		wait = ipWait
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(429, gin.H{"error": constants.ErrTooManyRequests, "message": "too many failed login attempts, try again later"})
+	return false
+}
+
+// recordLoginFailure counts a failed attempt against the account and the
+// client IP and audits it. When the account crosses the lockout threshold an
+// unlock link is emailed to its owner.
+func recordLoginFailure(c *gin.Context, r models.Service, m mailer.Mailer, email, reason string) {
+	ctx := c.Request.Context()
+	accountKey := models.AccountThrottleKey(email)
+
+	// the lookup runs for known and unknown emails alike
+	var targetID primitive.ObjectID
+	if target, err := r.GetUserByEmail(ctx, email); err == nil {
+		targetID = target.Id
+	}
+	audit(c, r, models.AuditLoginFailed, primitive.NilObjectID, targetID, map[string]interface{}{"email": email, "reason": reason})
+
+	if _, err := r.RecordLoginFailure(ctx, models.IPThrottleKey(c.ClientIP()), models.IPLoginPolicy); err != nil {
+		log.Printf("failed to record login failure: %v", err)
+	}
+	throttle, err := r.RecordLoginFailure(ctx, accountKey, models.AccountLoginPolicy)
+	if err != nil {
+		log.Printf("failed to record login failure: %v", err)
+		return
+	}
+	if throttle.Failures != models.AccountLoginPolicy.LockoutThreshold {
+		return
+	}
+
+	// the lookup and email happen off the request path so the response time
+	// doesn't reveal whether the account exists
+	go sendUnlockEmail(r, m, accountKey, email)
+}
+
+// newBackgroundContext bounds work that outlives the request, such as
+// sending email.
+func newBackgroundContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 30*time.Second)
+}
+
+func sendUnlockEmail(r models.Service, m mailer.Mailer, accountKey, email string) {
+	ctx, cancel := newBackgroundContext()
+	defer cancel()
+
+	user, err := r.GetUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := helpers.GenerateOneTimeToken()
+	if err != nil {
+		log.Printf("failed to generate unlock token: %v", err)
+		return
+	}
+	if err := r.SetUnlockToken(ctx, accountKey, helpers.HashToken(token)); err != nil {
+		log.Printf("failed to store unlock token: %v", err)
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nYour account was temporarily locked after several failed sign-in attempts.\n"+
+		"If this was you, unlock it now: %s/unlock?token=%s\n\n"+
+		"If it wasn't you, consider changing your password once you are signed in.\n",
+		user.Name, appURL(), token)
+	if err := m.Send(ctx, user.Email, "Your account has been locked", body); err != nil {
+		log.Printf("failed to send unlock email: %v", err)
+	}
+}
+
+type unlockRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func UnlockAccount(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req unlockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid request body"})
+			return
+		}
+
+		ok, err := r.UnlockAccount(c.Request.Context(), helpers.HashToken(req.Token))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to unlock account"})
+			return
+		}
+		if !ok {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid or expired unlock link"})
+			return
+		}
+		audit(c, r, models.AuditAccountUnlocked, primitive.NilObjectID, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{"message": "account unlocked, you can sign in again"})
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+const (
+	totpIssuer        = "ExpenseTracker"
+	recoveryCodeCount = 10
+)
+
+type mfaCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	mfaCodeRequest
+}
+
+type disableMFARequest struct {
+	Password string `json:"password" binding:"required"`
+	mfaCodeRequest
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Accepted codes are burned so they cannot be replayed.
+func verifySecondFactor(ctx context.Context, r models.Service, user *models.User, req mfaCodeRequest) (bool, error) {
+	if req.Code != "" {
+		step, ok := helpers.ValidateTOTP(user.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return r.UseTOTPStep(ctx, user.Id, step)
+	}
+	if req.RecoveryCode != "" {
+		return r.ConsumeRecoveryCode(ctx, user.Id, helpers.HashRecoveryCode(req.RecoveryCode))
+	}
+	return false, nil
+}
+
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = helpers.HashRecoveryCode(code)
+	}
+	return hashes
+}
+
+func EnrollTOTP(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		if user.MFAEnabled {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := helpers.GenerateTOTPSecret()
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate totp secret"})
+			return
+		}
+		if err := r.BeginTOTPEnrollment(ctx, userID, secret); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to start totp enrollment"})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"message":          "scan the provisioning uri and confirm with a code",
+			"secret":           secret,
+			"provisioning_uri": helpers.TOTPProvisioningURI(totpIssuer, user.Email, secret),
+		})
+	}
+}
+
+func ConfirmTOTP(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "code is required"})
+			return
+		}
+
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		if user.PendingTOTPSecret == "" {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "no totp enrollment in progress"})
+			return
+		}
+
+		step, valid := helpers.ValidateTOTP(user.PendingTOTPSecret, req.Code, time.Now())
+		if !valid {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid code"})
+			return
+		}
+
+		codes, err := helpers.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate recovery codes"})
+			return
+		}
+		if err := r.ConfirmTOTPEnrollment(ctx, userID, step, hashRecoveryCodes(codes)); err != nil {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": err.Error()})
+			return
+		}
+
+		audit(c, r, models.AuditMFAEnabled, userID, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{
+			"message":        "two-factor authentication enabled, store these recovery codes safely",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+func DisableTOTP(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req disableMFARequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "password and code are required"})
+			return
+		}
+
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		if !user.MFAEnabled {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "two-factor authentication is not enabled"})
+			return
+		}
+		if _, err := r.AuthenticateUser(ctx, user.Email, req.Password); err != nil {
+			c.JSON(401, gin.H{"error": constants.ErrInvalidCredentials, "message": "invalid password"})
+			return
+		}
+
+		valid, err := verifySecondFactor(ctx, r, user, req.mfaCodeRequest)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to verify code"})
+			return
+		}
+		if !valid {
+			c.JSON(401, gin.H{"error": constants.ErrInvalidCredentials, "message": "invalid code"})
+			return
+		}
+
+		if err := r.DisableTOTP(ctx, userID); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to disable two-factor authentication"})
+			return
+		}
+		audit(c, r, models.AuditMFADisabled, userID, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{"message": "two-factor authentication disabled"})
+	}
+}
+
+func RegenerateRecoveryCodes(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "code is required"})
+			return
+		}
+
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		if !user.MFAEnabled {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "two-factor authentication is not enabled"})
+			return
+		}
+
+		// only a fresh authenticator code may mint new recovery codes
+		valid, err := verifySecondFactor(ctx, r, user, mfaCodeRequest{Code: req.Code})
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to verify code"})
+			return
+		}
+		if !valid {
+			c.JSON(401, gin.H{"error": constants.ErrInvalidCredentials, "message": "invalid code"})
+			return
+		}
+
+		codes, err := helpers.GenerateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate recovery codes"})
+			return
+		}
+		if err := r.ReplaceRecoveryCodes(ctx, userID, hashRecoveryCodes(codes)); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to store recovery codes"})
+			return
+		}
+		audit(c, r, models.AuditRecoveryCodesReset, userID, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{"message": "recovery codes regenerated", "recovery_codes": codes})
+	}
+}
+
+// VerifyMFALogin completes the two-step login started by AuthenticateUser.
+// Wrong codes count towards the same lockout as wrong passwords, and the
+// account is checked again as it may have changed since the password step.
+func VerifyMFALogin(r models.Service, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var req mfaLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid request body"})
+			return
+		}
+
+		claims, err := helpers.ValidateMFAToken(req.MFAToken, os.Getenv("JWT_SECRET"))
+		if err != nil {
+			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "invalid or expired mfa token"})
+			return
+		}
+		userID, err := primitive.ObjectIDFromHex(claims.UserID)
+		if err != nil {
+			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "invalid or expired mfa token"})
+			return
+		}
+
+		// a challenge issued before the sessions were revoked (a password change,
+		// an admin reset) is as stale as the sessions themselves
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil || !user.MFAEnabled || claims.IssuedAt < user.SessionsRevokedAt.Unix() {
+			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "invalid or expired mfa token"})
+			return
+		}
+
+		if !checkLoginThrottle(c, r, user.Email) {
+			return
+		}
+
+		// the account may have been disabled or forced through a reset while the
+		// challenge was pending
+		if user.Disabled {
+			recordLoginFailure(c, r, m, user.Email, "account_disabled")
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "this account has been disabled"})
+			return
+		}
+		if user.PasswordResetRequired {
+			audit(c, r, models.AuditLoginFailed, primitive.NilObjectID, primitive.NilObjectID, map[string]interface{}{"email": user.Email, "reason": "password_reset_required"})
+			go sendPasswordResetEmail(r, m, user.Email, "You need to choose a new password before signing in again.")
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "a password reset is required, check your email for a reset link"})
+			return
+		}
+
+		valid, err := verifySecondFactor(ctx, r, user, req.mfaCodeRequest)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to verify code"})
+			return
+		}
+		if !valid {
+			recordLoginFailure(c, r, m, user.Email, "invalid_mfa_code")
+			c.JSON(401, gin.H{"error": constants.ErrInvalidCredentials, "message": "invalid code"})
+			return
+		}
+
+		_ = r.ClearLoginFailures(ctx, models.AccountThrottleKey(user.Email))
+		method := "password+totp"
+		if req.Code == "" {
+			method = "password+recovery_code"
+		}
+		auditLogin(c, r, user, method)
+		startSession(c, user)
+	}
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/oidc"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+var errEmailNotVerified = errors.New("identity provider did not return a verified email")
+
+// oidcRedirect sends the browser back to the frontend, with an error code
+// when sign-in failed.
+func oidcRedirect(c *gin.Context, path, errCode string) {
+	target := appURL() + path
+	if errCode != "" {
+		target += "?error=" + url.QueryEscape(errCode)
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// OIDCLogin starts the authorization code flow. state, nonce and the PKCE
+// verifier are kept in a short-lived signed cookie until the callback.
+func OIDCLogin(client *oidc.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, err := client.Provider(c.Request.Context())
+		if err != nil {
+			log.Printf("oidc provider unavailable: %v", err)
+			oidcRedirect(c, "/login", "sso_unavailable")
+			return
+		}
+
+		state, err1 := helpers.GenerateOneTimeToken()
+		nonce, err2 := helpers.GenerateOneTimeToken()
+		verifier, err3 := helpers.GenerateOneTimeToken()
+		if err1 != nil || err2 != nil || err3 != nil {
+			oidcRedirect(c, "/login", "sso_failed")
+			return
+		}
+
+		flow := jwt.MapClaims{
+			"state":    state,
+			"nonce":    nonce,
+			"verifier": verifier,
+			"exp":      time.Now().Add(oidcFlowTTL).Unix(),
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, flow).SignedString([]byte(os.Getenv("JWT_SECRET")))
+		if err != nil {
+			oidcRedirect(c, "/login", "sso_failed")
+			return
+		}
+
+		// Lax so the cookie survives the top-level redirect back from the provider
+		isProduction := os.Getenv("GIN_MODE") == "release" || os.Getenv("NODE_ENV") == "production"
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     oidcFlowCookie,
+			Value:    signed,
+			Path:     "/api/v1/auth/oidc",
+			MaxAge:   int(oidcFlowTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   isProduction,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier)))
+	}
+}
+
+// OIDCCallback finishes the flow: it redeems the code, validates the ID token
+// and signs in the linked user. Unknown identities are linked to an existing
+// account by verified email, or a new account is created.
+func OIDCCallback(r models.Service, m mailer.Mailer, client *oidc.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		cookie, err := c.Cookie(oidcFlowCookie)
+		http.SetCookie(c.Writer, &http.Cookie{Name: oidcFlowCookie, Value: "", Path: "/api/v1/auth/oidc", MaxAge: -1, HttpOnly: true})
+		if err != nil {
+			oidcRedirect(c, "/login", "sso_expired")
+			return
+		}
+		if c.Query("error") != "" {
+			oidcRedirect(c, "/login", "sso_denied")
+			return
+		}
+
+		flow := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(cookie, flow, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return []byte(os.Getenv("JWT_SECRET")), nil
+		})
+		if err != nil {
+			oidcRedirect(c, "/login", "sso_expired")
+			return
+		}
+		state, _ := flow["state"].(string)
+		nonce, _ := flow["nonce"].(string)
+		verifier, _ := flow["verifier"].(string)
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+			oidcRedirect(c, "/login", "sso_state_mismatch")
+			return
+		}
+
+		provider, err := client.Provider(ctx)
+		if err != nil {
+			log.Printf("oidc provider unavailable: %v", err)
+			oidcRedirect(c, "/login", "sso_unavailable")
+			return
+		}
+		rawIDToken, err := provider.Exchange(ctx, c.Query("code"), verifier)
+		if err != nil {
+			log.Printf("oidc code exchange failed: %v", err)
+			oidcRedirect(c, "/login", "sso_failed")
+			return
+		}
+		idToken, err := provider.VerifyIDToken(ctx, rawIDToken, nonce)
+		if err != nil {
+			log.Printf("oidc id token rejected: %v", err)
+			oidcRedirect(c, "/login", "sso_failed")
+			return
+		}
+
+		user, err := r.GetUserByIdentity(ctx, idToken.Issuer, idToken.Subject)
+		if err != nil {
+			oidcRedirect(c, "/login", "sso_failed")
+			return
+		}
+		if user == nil {
+			user, err = linkOrCreateOIDCUser(c, r, idToken)
+			if err != nil {
+				log.Printf("oidc account linking failed: %v", err)
+				oidcRedirect(c, "/login", "sso_link_failed")
+				return
+			}
+		}
+
+		// the same account checks as a password login, so SSO can't get
+		// around a disabled account or a forced password reset
+		if user.Disabled {
+			audit(c, r, models.AuditLoginFailed, primitive.NilObjectID, user.Id, map[string]interface{}{"email": user.Email, "method": "oidc", "reason": "account_disabled"})
+			oidcRedirect(c, "/login", "sso_account_disabled")
+			return
+		}
+		if user.PasswordResetRequired {
+			audit(c, r, models.AuditLoginFailed, primitive.NilObjectID, user.Id, map[string]interface{}{"email": user.Email, "method": "oidc", "reason": "password_reset_required"})
+			go sendPasswordResetEmail(r, m, user.Email, "You need to choose a new password before signing in again.")
+			oidcRedirect(c, "/login", "sso_password_reset_required")
+			return
+		}
+
+		if user.MFAEnabled {
+			mfaToken, err := helpers.GenerateMFAToken(&helpers.UseClaims{UserID: user.Id.Hex(), Email: user.Email}, os.Getenv("JWT_SECRET"))
+			if err != nil {
+				oidcRedirect(c, "/login", "sso_failed")
+				return
+			}
+			// the fragment keeps the challenge token out of server logs
+			c.Redirect(http.StatusFound, appURL()+"/login/mfa#mfa_token="+url.QueryEscape(mfaToken))
+			return
+		}
+
+		if _, err := setSessionCookies(c, user); err != nil {
+			oidcRedirect(c, "/login", "sso_failed")
+			return
+		}
+		auditLogin(c, r, user, "oidc")
+		oidcRedirect(c, "/", "")
+	}
+}
+
+func linkOrCreateOIDCUser(c *gin.Context, r models.Service, idToken *oidc.IDToken) (*models.User, error) {
+	ctx := c.Request.Context()
+
+	// an unverified email could belong to someone else, so it must never be
+	// used to take over or create an account
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return nil, errEmailNotVerified
+	}
+
+	identity := models.ExternalIdentity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   idToken.Email,
+	}
+
+	if user, err := r.GetUserByEmail(ctx, idToken.Email); err == nil {
+		if err := r.LinkIdentity(ctx, user.Id, identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	name := idToken.Name
+	if name == "" {
+		name = strings.Split(idToken.Email, "@")[0]
+	}
+	return r.RegisterExternalUser(ctx, &models.User{Name: name, Email: idToken.Email}, identity)
+}
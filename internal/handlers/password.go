@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/password"
+)
+
+const passwordResetTTL = time.Hour
+
+// fieldErrors turns binding errors into messages keyed by JSON field name.
+func fieldErrors(err error) map[string][]string {
+	fields := map[string][]string{}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		fields["body"] = []string{"must be valid JSON"}
+		return fields
+	}
+	for _, fe := range verrs {
+		name := strings.ToLower(fe.Field())
+		var msg string
+		switch fe.Tag() {
+		case "required":
+			msg = "is required"
+		case "email":
+			msg = "must be a valid email address"
+		case "max":
+			msg = fmt.Sprintf("must be at most %s characters long", fe.Param())
+			if fe.Kind() != reflect.String {
+				msg = fmt.Sprintf("must be at most %s", fe.Param())
+			}
+		case "min":
+			msg = fmt.Sprintf("must be at least %s characters long", fe.Param())
+			if fe.Kind() != reflect.String {
+				msg = fmt.Sprintf("must be at least %s", fe.Param())
+			}
+		case "oneof":
+			msg = "must be one of " + strings.ReplaceAll(fe.Param(), " ", ", ")
+		default:
+			msg = "is invalid"
+		}
+		fields[name] = append(fields[name], msg)
+	}
+	return fields
+}
+
+// checkNewPassword writes a 400 with field-level messages when pw doesn't
+// satisfy the password policy.
+func checkNewPassword(c *gin.Context, checker *password.Checker, field, pw string, userInputs ...string) bool {
+	problems, err := checker.Check(strings.TrimSpace(pw), userInputs...)
+	if err != nil {
+		// an unreadable breach list shouldn't block sign-ups, the policy still applies
+		log.Printf("breached password check failed: %v", err)
+	}
+	if len(problems) == 0 {
+		return true
+	}
+	c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "password does not meet the requirements", "fields": gin.H{field: problems}})
+	return false
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+func ChangePassword(r models.Service) gin.HandlerFunc {
+	checker := password.CheckerFromEnv()
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req changePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		user, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		if _, err := r.AuthenticateUser(ctx, user.Email, req.CurrentPassword); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidCredentials, "message": "current password is incorrect", "fields": gin.H{"current_password": []string{"is incorrect"}}})
+			return
+		}
+		if !checkNewPassword(c, checker, "new_password", req.NewPassword, user.Name, user.Email) {
+			return
+		}
+
+		if err := r.UpdatePassword(ctx, userID, req.NewPassword); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to change password"})
+			return
+		}
+		audit(c, r, models.AuditPasswordChanged, userID, primitive.NilObjectID, nil)
+
+		// other sessions were signed out with the old password; this one
+		// continues with fresh cookies
+		csrfToken, err := setSessionCookies(c, user)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "password changed successfully", "csrf_token": csrfToken})
+	}
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ForgotPassword emails a reset link. It answers the same way whether or not
+// the email has an account.
+func ForgotPassword(r models.Service, m mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		go sendPasswordResetEmail(r, m, req.Email, "You asked to reset your password.")
+		c.JSON(202, gin.H{"message": "if an account exists for that email, a reset link has been sent"})
+	}
+}
+
+// sendPasswordResetEmail issues a reset token for the account behind email and
+// mails the link. It runs off the request path so response timing doesn't
+// reveal whether the account exists.
+func sendPasswordResetEmail(r models.Service, m mailer.Mailer, email, reason string) {
+	ctx, cancel := newBackgroundContext()
+	defer cancel()
+
+	user, err := r.GetUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := helpers.GenerateOneTimeToken()
+	if err != nil {
+		log.Printf("failed to generate reset token: %v", err)
+		return
+	}
+	if err := r.SetPasswordResetToken(ctx, user.Id, helpers.HashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		log.Printf("failed to store reset token: %v", err)
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n%s\nChoose a new password here within the next hour: %s/reset-password?token=%s\n\n"+
+		"If you didn't ask for this you can ignore this email.\n",
+		user.Name, reason, appURL(), token)
+	if err := m.Send(ctx, user.Email, "Reset your password", body); err != nil {
+		log.Printf("failed to send reset email: %v", err)
+	}
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func ResetPassword(r models.Service) gin.HandlerFunc {
+	checker := password.CheckerFromEnv()
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		tokenHash := helpers.HashToken(req.Token)
+		user, err := r.GetUserByPasswordResetToken(ctx, tokenHash)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid or expired reset link"})
+			return
+		}
+		if !checkNewPassword(c, checker, "password", req.Password, user.Name, user.Email) {
+			return
+		}
+
+		if err := r.ResetPassword(ctx, tokenHash, req.Password); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid or expired reset link"})
+			return
+		}
+		// a successful reset also lifts any lockout
+		_ = r.ClearLoginFailures(ctx, models.AccountThrottleKey(user.Email))
+		audit(c, r, models.AuditPasswordReset, user.Id, primitive.NilObjectID, nil)
+		c.JSON(200, gin.H{"message": "password reset successfully, you can sign in now"})
+	}
+}
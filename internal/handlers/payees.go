@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+type payeeAliasRequest struct {
+	Pattern string `json:"pattern" binding:"required,max=100"`
+	Match   string `json:"match" binding:"required,oneof=exact prefix contains"`
+}
+
+type payeeRequest struct {
+	Name    *string              `json:"name" binding:"omitempty,min=1,max=100"`
+	Aliases *[]payeeAliasRequest `json:"aliases" binding:"omitempty,max=50,dive"`
+}
+
+func (req *payeeRequest) aliases() []models.PayeeAlias {
+	aliases := []models.PayeeAlias{}
+	if req.Aliases != nil {
+		for _, a := range *req.Aliases {
+			aliases = append(aliases, models.PayeeAlias{Pattern: a.Pattern, Match: a.Match})
+		}
+	}
+	return aliases
+}
+
+// ownPayee loads the payee named in the path, writing the error response
+// itself when it doesn't exist or belongs to someone else.
+func ownPayee(c *gin.Context, r models.Service, userID primitive.ObjectID) (*models.Payee, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid payee ID"})
+		return nil, false
+	}
+	payee, err := r.GetPayee(c.Request.Context(), id, userID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "payee not found"})
+		return nil, false
+	}
+	return payee, true
+}
+
+// resolvePayee checks the payee a new transaction names or, when it names
+// none, matches one from the description through the payees' aliases.
+func resolvePayee(c *gin.Context, r models.Service, userID primitive.ObjectID, tx *models.Transaction) bool {
+	ctx := c.Request.Context()
+	if tx.PayeeId != nil {
+		if _, err := r.GetPayee(ctx, *tx.PayeeId, userID); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "payee not found"})
+			return false
+		}
+		return true
+	}
+
+	payee, err := r.MatchPayee(ctx, userID, tx.Description)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to match payee"})
+		return false
+	}
+	if payee != nil {
+		tx.PayeeId = &payee.Id
+	}
+	return true
+}
+
+func CreatePayee(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req payeeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		if req.Name == nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": map[string][]string{"name": {"is required"}}})
+			return
+		}
+
+		payee := &models.Payee{UserId: userID, Name: *req.Name, Aliases: req.aliases()}
+		if err := r.CreatePayee(c.Request.Context(), payee); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create payee"})
+			return
+		}
+		c.JSON(201, gin.H{"message": "payee created successfully", "data": payee})
+	}
+}
+
+func ListPayees(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		payees, err := r.ListPayees(c.Request.Context(), userID, c.Query("search"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list payees"})
+			return
+		}
+		c.JSON(200, gin.H{"data": payees})
+	}
+}
+
+// GetPayee returns the payee with its income, expense and transaction count.
+func GetPayee(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		payee, ok := ownPayee(c, r, userID)
+		if !ok {
+			return
+		}
+
+		totals, err := r.GetPayeeTotals(c.Request.Context(), payee.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute payee totals"})
+			return
+		}
+		c.JSON(200, gin.H{"data": payee, "totals": totals})
+	}
+}
+
+// UpdatePayee changes the name or replaces the aliases. Existing transactions
+// keep their payee; new ones are matched with the new aliases.
+func UpdatePayee(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req payeeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		if req.Name == nil && req.Aliases == nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "no fields to update"})
+			return
+		}
+
+		payee, ok := ownPayee(c, r, userID)
+		if !ok {
+			return
+		}
+		if req.Name != nil {
+			payee.Name = *req.Name
+		}
+		if req.Aliases != nil {
+			payee.Aliases = req.aliases()
+		}
+		if err := r.UpdatePayee(c.Request.Context(), payee); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update payee"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "payee updated successfully", "data": payee})
+	}
+}
+
+// DeletePayee deletes the payee; its transactions are kept without one.
+func DeletePayee(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		payee, ok := ownPayee(c, r, userID)
+		if !ok {
+			return
+		}
+
+		changes, err := r.DeletePayee(c.Request.Context(), payee.Id, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to delete payee"})
+			return
+		}
+		recordChanges(c, r, userID, changes)
+		c.JSON(200, gin.H{"message": "payee deleted successfully"})
+	}
+}
+
+func PayeeTransactions(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := c.DefaultQuery("limit", "10")
+		offset := c.DefaultQuery("offset", "0")
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		payee, ok := ownPayee(c, r, userID)
+		if !ok {
+			return
+		}
+
+		transactions, err := r.ListPayeeTransactions(c.Request.Context(), payee.Id, helpers.ParseInt(limit), helpers.ParseInt(offset))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list payee transactions"})
+			return
+		}
+		c.JSON(200, gin.H{"data": transactions})
+	}
+}
+
+// MatchPayee shows which payee a description would be matched to, so alias
+// rules can be checked before relying on them.
+func MatchPayee(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		description := c.Query("description")
+		if description == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "description is required"})
+			return
+		}
+
+		payee, err := r.MatchPayee(c.Request.Context(), userID, description)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to match payee"})
+			return
+		}
+		c.JSON(200, gin.H{"normalized": models.NormalizeDescription(description), "data": payee})
+	}
+}
+
+type mergePayeesRequest struct {
+	From []string `json:"from" binding:"required,min=1,max=50"`
+	Into string   `json:"into" binding:"required"`
+}
+
+// MergePayees folds duplicate payees into one, which keeps their names as
+// aliases and takes over their transactions.
+func MergePayees(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req mergePayeesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		into, err := primitive.ObjectIDFromHex(req.Into)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid payee ID"})
+			return
+		}
+		seen := map[primitive.ObjectID]bool{into: true}
+		var from []primitive.ObjectID
+		for _, hex := range req.From {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid payee ID"})
+				return
+			}
+			if !seen[id] {
+				seen[id] = true
+				from = append(from, id)
+			}
+		}
+		if len(from) == 0 {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "nothing to merge"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		moved, err := r.MergePayees(ctx, userID, from, into)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrPayeeNotFound):
+				c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "payee not found"})
+			case errors.Is(err, models.ErrTooManyAliases):
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			default:
+				c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to merge payees"})
+			}
+			return
+		}
+		recordChanges(c, r, userID, moved)
+		payee, err := r.GetPayee(ctx, into, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to fetch payee"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "payees merged", "moved": len(moved), "data": payee})
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/period"
+)
+
+type updatePreferencesRequest struct {
+	Currency         *string `json:"currency" binding:"omitempty,iso4217"`
+	Locale           *string `json:"locale" binding:"omitempty,bcp47_language_tag"`
+	Timezone         *string `json:"timezone" binding:"omitempty,timezone"`
+	WeekStart        *string `json:"week_start" binding:"omitempty,oneof=sunday monday saturday"`
+	FiscalMonthStart *int    `json:"fiscal_month_start" binding:"omitempty,min=1,max=28"`
+}
+
+func GetPreferences(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		prefs, err := r.GetPreferences(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to fetch preferences"})
+			return
+		}
+		c.JSON(200, gin.H{"data": prefs})
+	}
+}
+
+// UpdatePreferences changes only the fields present in the request body.
+func UpdatePreferences(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req updatePreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		prefs, err := r.GetPreferences(ctx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to fetch preferences"})
+			return
+		}
+		if req.Currency != nil {
+			prefs.Currency = *req.Currency
+		}
+		if req.Locale != nil {
+			prefs.Locale = *req.Locale
+		}
+		if req.Timezone != nil {
+			prefs.Timezone = *req.Timezone
+		}
+		if req.WeekStart != nil {
+			prefs.WeekStart = *req.WeekStart
+		}
+		if req.FiscalMonthStart != nil {
+			prefs.FiscalMonthStart = *req.FiscalMonthStart
+		}
+
+		if err := r.UpdatePreferences(ctx, prefs); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update preferences"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "preferences updated successfully", "data": prefs})
+	}
+}
+
+// userPreferences loads the current user's preferences, writing the error
+// response itself when that fails.
+func userPreferences(c *gin.Context, r models.Service, userID primitive.ObjectID) (*models.Preferences, bool) {
+	prefs, err := r.GetPreferences(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to fetch preferences"})
+		return nil, false
+	}
+	return prefs, true
+}
+
+// periodRange reads the date range of a request in the user's calendar:
+// either ?period=day|week|month|quarter|year with an optional ?date= inside
+// it (default today), or ?from= and/or ?to= as inclusive YYYY-MM-DD dates.
+// Zero times mean the range is open on that side.
+func periodRange(c *gin.Context, cal period.Calendar) (time.Time, time.Time, bool) {
+	var from, to time.Time
+
+	if p := c.Query("period"); p != "" {
+		unit, err := period.ParseUnit(p)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return from, to, false
+		}
+		ref := time.Now()
+		if d := c.Query("date"); d != "" {
+			if ref, err = cal.ParseDate(d); err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+				return from, to, false
+			}
+		}
+		from, to = cal.Range(unit, ref)
+		return from, to, true
+	}
+
+	var err error
+	if d := c.Query("from"); d != "" {
+		if from, err = cal.ParseDate(d); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return from, to, false
+		}
+	}
+	if d := c.Query("to"); d != "" {
+		if to, err = cal.ParseDate(d); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return from, to, false
+		}
+		to = cal.Next(period.Day, to)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "from must not be after to"})
+		return from, to, false
+	}
+	return from, to, true
+}
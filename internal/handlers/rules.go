@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+type ruleRequest struct {
+	Name       string                 `json:"name" binding:"required,max=100"`
+	Priority   int                    `json:"priority"`
+	Enabled    *bool                  `json:"enabled"`
+	Conditions []models.RuleCondition `json:"conditions" binding:"required"`
+	Actions    models.RuleActions     `json:"actions"`
+}
+
+func (req *ruleRequest) rule(userID primitive.ObjectID) *models.Rule {
+	enabled := req.Enabled == nil || *req.Enabled
+	return &models.Rule{
+		UserId:     userID,
+		Name:       req.Name,
+		Priority:   req.Priority,
+		Enabled:    enabled,
+		Conditions: req.Conditions,
+		Actions:    req.Actions,
+	}
+}
+
+// checkRule validates a rule before it is saved or tested, including that a
+// payee it assigns belongs to the user.
+func checkRule(c *gin.Context, r models.Service, rule *models.Rule) bool {
+	if err := rule.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+		return false
+	}
+	if rule.Actions.PayeeId != nil {
+		if _, err := r.GetPayee(c.Request.Context(), *rule.Actions.PayeeId, rule.UserId); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "payee not found"})
+			return false
+		}
+	}
+	return true
+}
+
+// applyRules runs the user's rules over a new transaction.
+func applyRules(c *gin.Context, r models.Service, userID primitive.ObjectID, tx *models.Transaction) bool {
+	rules, err := r.ListRules(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load rules"})
+		return false
+	}
+	models.ApplyRules(rules, tx)
+	return true
+}
+
+// ownRule loads the rule named in the path, writing the error response
+// itself when it doesn't exist or belongs to someone else.
+func ownRule(c *gin.Context, r models.Service, userID primitive.ObjectID) (*models.Rule, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid rule ID"})
+		return nil, false
+	}
+	rule, err := r.GetRule(c.Request.Context(), id, userID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "rule not found"})
+		return nil, false
+	}
+	return rule, true
+}
+
+func CreateRule(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req ruleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		rule := req.rule(userID)
+		if !checkRule(c, r, rule) {
+			return
+		}
+		if err := r.CreateRule(c.Request.Context(), rule); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create rule"})
+			return
+		}
+		c.JSON(201, gin.H{"message": "rule created successfully", "data": rule})
+	}
+}
+
+// ListRules returns the user's rules in the order they run.
+func ListRules(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		rules, err := r.ListRules(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list rules"})
+			return
+		}
+		c.JSON(200, gin.H{"data": rules})
+	}
+}
+
+func GetRule(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		rule, ok := ownRule(c, r, userID)
+		if !ok {
+			return
+		}
+		c.JSON(200, gin.H{"data": rule})
+	}
+}
+
+// UpdateRule replaces the rule with the body.
+func UpdateRule(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req ruleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		existing, ok := ownRule(c, r, userID)
+		if !ok {
+			return
+		}
+		rule := req.rule(userID)
+		rule.Id = existing.Id
+		rule.CreatedAt = existing.CreatedAt
+		if !checkRule(c, r, rule) {
+			return
+		}
+		if err := r.UpdateRule(c.Request.Context(), rule); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update rule"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "rule updated successfully", "data": rule})
+	}
+}
+
+func DeleteRule(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		rule, ok := ownRule(c, r, userID)
+		if !ok {
+			return
+		}
+
+		if err := r.DeleteRule(c.Request.Context(), rule.Id, userID); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to delete rule"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "rule deleted successfully"})
+	}
+}
+
+type testRuleRequest struct {
+	// either an unsaved rule or the id of a saved one
+	Rule   *ruleRequest `json:"rule"`
+	RuleId string       `json:"rule_id"`
+	// a sample to run the rule on; without one the rule is run over the
+	// user's most recent transactions
+	Transaction *models.Transaction `json:"transaction"`
+	Limit       int                 `json:"limit" binding:"omitempty,min=1,max=1000"`
+}
+
+type ruleMatch struct {
+	TransactionId primitive.ObjectID     `json:"transaction_id"`
+	Description   string                 `json:"description"`
+	Changes       map[string]interface{} `json:"changes"`
+}
+
+// TestRule is a dry run: it shows what a rule would do to a sample
+// transaction or to the user's recent transactions without saving anything.
+func TestRule(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req testRuleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		var rule *models.Rule
+		switch {
+		case req.Rule != nil:
+			rule = req.Rule.rule(userID)
+			if !checkRule(c, r, rule) {
+				return
+			}
+		case req.RuleId != "":
+			id, err := primitive.ObjectIDFromHex(req.RuleId)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid rule ID"})
+				return
+			}
+			if rule, err = r.GetRule(ctx, id, userID); err != nil {
+				c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "rule not found"})
+				return
+			}
+		default:
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "rule or rule_id is required"})
+			return
+		}
+
+		if req.Transaction != nil {
+			sample := *req.Transaction
+			enabled := *rule
+			enabled.Enabled = true
+			matched := len(models.ApplyRules([]models.Rule{enabled}, &sample)) > 0
+			c.JSON(200, gin.H{"matched": matched, "data": sample})
+			return
+		}
+
+		limit := req.Limit
+		if limit == 0 {
+			limit = 100
+		}
+		transactions, err := r.GetTransactionByQuery(ctx, models.PersonalScope(userID), "", nil, models.TagFilter{}, time.Time{}, time.Time{}, "new", limit, 0)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load transactions"})
+			return
+		}
+		matches := []ruleMatch{}
+		for i := range transactions {
+			if updates := models.RuleUpdates(rule, &transactions[i]); updates != nil {
+				matches = append(matches, ruleMatch{TransactionId: transactions[i].Id, Description: transactions[i].Description, Changes: updates})
+			}
+		}
+		c.JSON(200, gin.H{"checked": len(transactions), "data": matches})
+	}
+}
+
+// Applying a rule walks the user's transactions in pages of ruleApplyPage
+// and stops after ruleApplyMax per request, so one call never loads or
+// rewrites an unbounded number of them.
+const (
+	ruleApplyPage = 200
+	ruleApplyMax  = 5000
+)
+
+// ApplyRule runs a saved rule over the user's existing transactions and saves
+// the changes, recording each in the transaction's history. Transactions
+// edited concurrently are skipped. When more transactions are left than one
+// request handles, the response carries a next cursor to pass back as after.
+func ApplyRule(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		var after primitive.ObjectID
+		if hex := c.Query("after"); hex != "" {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid after cursor"})
+				return
+			}
+			after = id
+		}
+		rule, ok := ownRule(c, r, userID)
+		if !ok {
+			return
+		}
+
+		var checked, updated, skipped int
+		for checked < ruleApplyMax {
+			transactions, err := r.ListTransactionsAfter(c.Request.Context(), models.PersonalScope(userID), after, ruleApplyPage)
+			if err != nil {
+				c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load transactions", "updated": updated})
+				return
+			}
+			for i := range transactions {
+				tx := &transactions[i]
+				updates := models.RuleUpdates(rule, tx)
+				if updates == nil {
+					continue
+				}
+				if _, err := updateTransaction(c, r, models.RevisionUpdated, userID, tx, tx.Version, updates); err != nil {
+					if errors.Is(err, models.ErrVersionConflict) {
+						skipped++
+						continue
+					}
+					c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to apply rule", "updated": updated})
+					return
+				}
+				updated++
+			}
+			checked += len(transactions)
+			if len(transactions) < ruleApplyPage {
+				c.JSON(200, gin.H{"message": "rule applied", "checked": checked, "updated": updated, "skipped": skipped})
+				return
+			}
+			after = transactions[len(transactions)-1].Id
+		}
+		c.JSON(200, gin.H{"message": "rule partly applied", "checked": checked, "updated": updated, "skipped": skipped, "next": after.Hex()})
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// checkSharing validates how a shared expense of amount is divided and
+// computes each participant's amount. Sharing needs an expense in a ledger,
+// paid by and divided between its current members; an equal division
+// without participants is between all members.
+func checkSharing(c *gin.Context, ledger *models.Ledger, kind string, sharing *models.Sharing, amount float64) bool {
+	if ledger == nil {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "only transactions in a ledger can be shared, send a ledger_id"})
+		return false
+	}
+	if kind != "expense" {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "only expenses can be shared"})
+		return false
+	}
+	if sharing.Method == models.ShareEqually && len(sharing.Shares) == 0 {
+		for _, m := range ledger.Members {
+			sharing.Shares = append(sharing.Shares, models.MemberShare{UserId: m.UserId})
+		}
+	}
+	if ledger.RoleOf(sharing.PaidBy) == "" {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "paid_by must be a member of the ledger"})
+		return false
+	}
+	for _, share := range sharing.Shares {
+		if ledger.RoleOf(share.UserId) == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "every participant must be a member of the ledger"})
+			return false
+		}
+	}
+	if err := sharing.Allocate(amount); err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+		return false
+	}
+	return true
+}
+
+// sharingUpdates adds the sharing of an update to updates. Sending null
+// stops sharing the expense. Without new sharing the existing one is divided
+// again when the amount changes, except exact amounts which must be resent.
+// Settlements keep who paid whom and follow their amount.
+func sharingUpdates(c *gin.Context, r models.Service, tx *models.Transaction, req *updateTransactionRequest, updates map[string]interface{}) bool {
+	amount, kind := tx.Amount, tx.Type
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if req.Type != nil {
+		kind = *req.Type
+	}
+
+	if tx.Type == models.TypeSettlement {
+		switch {
+		case req.Type != nil:
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "the type of a settlement can't be changed"})
+			return false
+		case len(req.Sharing) > 0:
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "record a new settlement instead of changing who paid whom"})
+			return false
+		case req.Amount != nil:
+			if amount <= 0 {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "amount must be positive"})
+				return false
+			}
+			updates["sharing"] = settlementSharing(tx.Sharing.PaidBy, tx.Sharing.Shares[0].UserId, amount)
+		}
+		return true
+	}
+
+	var sharing *models.Sharing
+	switch {
+	case string(req.Sharing) == "null":
+		updates["sharing"] = nil
+		return true
+	case len(req.Sharing) > 0:
+		sharing = &models.Sharing{}
+		if err := json.Unmarshal(req.Sharing, sharing); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid sharing"})
+			return false
+		}
+	case tx.Sharing != nil && (req.Amount != nil || req.Type != nil):
+		if tx.Sharing.Method == models.ShareExactly && req.Amount != nil && *req.Amount != tx.Amount {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "send sharing that adds up to the new amount"})
+			return false
+		}
+		copied := *tx.Sharing
+		copied.Shares = append([]models.MemberShare(nil), tx.Sharing.Shares...)
+		sharing = &copied
+	default:
+		return true
+	}
+
+	var ledger *models.Ledger
+	if tx.LedgerId != nil {
+		var err error
+		if ledger, err = r.GetLedger(c.Request.Context(), *tx.LedgerId); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load ledger"})
+			return false
+		}
+	}
+	if !checkSharing(c, ledger, kind, sharing, amount) {
+		return false
+	}
+	updates["sharing"] = sharing
+	return true
+}
+
+// checkSettlement validates both sides of a settlement: each must be a
+// member of the ledger or still have an open balance in it, like a member who
+// left before settling up.
+func checkSettlement(c *gin.Context, r models.Service, ledger *models.Ledger, from, to primitive.ObjectID) bool {
+	shared, err := r.ListSharedTransactions(c.Request.Context(), ledger.Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute balances"})
+		return false
+	}
+	open := map[primitive.ObjectID]bool{}
+	for _, b := range models.Balances(shared) {
+		open[b.UserId] = b.Balance != 0
+	}
+	for _, id := range []primitive.ObjectID{from, to} {
+		if ledger.RoleOf(id) == "" && !open[id] {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "both sides must be members of the ledger or have an open balance"})
+			return false
+		}
+	}
+	return true
+}
+
+// maySettle reports whether userID may record, change or remove a
+// settlement from one member to another: editors only their own payments,
+// owners any of them.
+func maySettle(ledger *models.Ledger, userID, from, to primitive.ObjectID) bool {
+	return from == userID || to == userID || ledger.RoleOf(userID) == models.LedgerOwner
+}
+
+// checkSettlementParty applies the rule of maySettle to an existing
+// settlement before it is updated, reverted, deleted or restored; other
+// transactions pass unchecked.
+func checkSettlementParty(c *gin.Context, r models.Service, userID primitive.ObjectID, tx *models.Transaction) bool {
+	if tx.Type != models.TypeSettlement || tx.LedgerId == nil || tx.Sharing == nil || len(tx.Sharing.Shares) != 1 {
+		return true
+	}
+	ledger, err := r.GetLedger(c.Request.Context(), *tx.LedgerId)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load ledger"})
+		return false
+	}
+	if !maySettle(ledger, userID, tx.Sharing.PaidBy, tx.Sharing.Shares[0].UserId) {
+		c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "only ledger owners can change settlements between other members"})
+		return false
+	}
+	return true
+}
+
+// settlementSharing records a payment from one member to another: the payer
+// covers the whole amount for the receiver.
+func settlementSharing(from, to primitive.ObjectID, amount float64) *models.Sharing {
+	return &models.Sharing{
+		PaidBy: from,
+		Method: models.ShareExactly,
+		Shares: []models.MemberShare{{UserId: to, Value: amount, Amount: amount}},
+	}
+}
+
+// LedgerBalances returns what each member paid and owes across the ledger's
+// shared expenses and settlements, and the payments that would settle up.
+func LedgerBalances(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		ledger, ok := pathLedger(c, r, userID, models.LedgerViewer)
+		if !ok {
+			return
+		}
+
+		transactions, err := r.ListSharedTransactions(c.Request.Context(), ledger.Id)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute balances"})
+			return
+		}
+		balances := models.Balances(transactions)
+		c.JSON(200, gin.H{"data": gin.H{
+			"balances":  balances,
+			"settle_up": models.SettleUp(balances),
+		}})
+	}
+}
+
+type settlementRequest struct {
+	// defaults to the signed-in member
+	From   string  `json:"from"`
+	To     string  `json:"to" binding:"required"`
+	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Note   string  `json:"note" binding:"omitempty,max=1000"`
+}
+
+// RecordSettlement records a payment from one member to another that settles
+// (part of) what they owe, as a settlement transaction in the ledger.
+// Members who left can still settle an open balance. Editors record their
+// own payments; only owners can record one between two other members.
+func RecordSettlement(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req settlementRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		from := userID
+		if req.From != "" {
+			id, err := primitive.ObjectIDFromHex(req.From)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid from user ID"})
+				return
+			}
+			from = id
+		}
+		to, err := primitive.ObjectIDFromHex(req.To)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid to user ID"})
+			return
+		}
+		if from == to {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "a member can't settle with themselves"})
+			return
+		}
+
+		ledger, ok := pathLedger(c, r, userID, models.LedgerEditor)
+		if !ok {
+			return
+		}
+		if !maySettle(ledger, userID, from, to) {
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "only ledger owners can record settlements between other members"})
+			return
+		}
+		if !checkSettlement(c, r, ledger, from, to) {
+			return
+		}
+
+		tx := models.Transaction{
+			Amount:      req.Amount,
+			Type:        models.TypeSettlement,
+			Description: "Settlement",
+			Note:        strings.TrimSpace(req.Note),
+			Category:    models.TypeSettlement,
+			LedgerId:    &ledger.Id,
+			Sharing:     settlementSharing(from, to, req.Amount),
+		}
+		if err := r.AddTransaction(ctx, &tx, userID); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to record settlement"})
+			return
+		}
+		recordRevision(c, r, models.RevisionCreated, userID, nil, &tx)
+		c.Header("ETag", etag(tx.Version))
+		c.JSON(201, gin.H{"message": "settlement recorded", "data": tx})
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/classifier"
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// learnFromChange retrains the user's category model incrementally: what
+// before taught it is forgotten and what after teaches is learned. Like
+// history, a failure is logged; a retrain fixes any drift.
+func learnFromChange(c *gin.Context, r models.Service, before, after *models.Transaction) {
+	forget, learn := before.TrainingCategories(), after.TrainingCategories()
+	if len(forget) == 0 && len(learn) == 0 {
+		return
+	}
+	var userID primitive.ObjectID
+	if after != nil {
+		userID = after.UserId
+	} else {
+		userID = before.UserId
+	}
+	err := r.UpdateCategoryModel(c.Request.Context(), userID, func(m *classifier.Model) {
+		if len(forget) > 0 {
+			features := models.CategoryFeatures(before)
+			for _, category := range forget {
+				m.Forget(category, features)
+			}
+		}
+		if len(learn) > 0 {
+			features := models.CategoryFeatures(after)
+			for _, category := range learn {
+				m.Learn(category, features)
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("failed to update category model of user %s: %v", userID.Hex(), err)
+	}
+}
+
+// SuggestCategory ranks the user's categories for a transaction that is
+// being entered, from ?description=, ?note=, ?amount= and ?type=. The model
+// is built on first use.
+func SuggestCategory(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		tx := models.Transaction{
+			Description: strings.TrimSpace(c.Query("description")),
+			Note:        strings.TrimSpace(c.Query("note")),
+			Type:        c.DefaultQuery("type", "expense"),
+		}
+		if tx.Description == "" && tx.Note == "" {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "description or note is required"})
+			return
+		}
+		if amount := c.Query("amount"); amount != "" {
+			value, err := strconv.ParseFloat(amount, 64)
+			if err != nil || value < 0 {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "amount must be a positive number"})
+				return
+			}
+			tx.Amount = value
+		}
+
+		model, err := r.GetCategoryModel(c.Request.Context(), userID)
+		if err == nil && model == nil {
+			model, err = r.RebuildCategoryModel(c.Request.Context(), userID)
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to load category model"})
+			return
+		}
+
+		limit := helpers.ParseInt(c.DefaultQuery("limit", "5"))
+		if limit <= 0 || limit > 20 {
+			limit = 5
+		}
+		predictions := model.Model.Predict(models.CategoryFeatures(&tx), limit)
+		if predictions == nil {
+			predictions = []classifier.Prediction{}
+		}
+		c.JSON(200, gin.H{"data": predictions})
+	}
+}
+
+// RetrainSuggestions rebuilds the user's category model from scratch.
+func RetrainSuggestions(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		model, err := r.RebuildCategoryModel(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to retrain category model"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "category model retrained", "data": gin.H{
+			"transactions": model.Model.Docs,
+			"categories":   len(model.Model.Classes),
+			"updated_at":   model.UpdatedAt,
+		}})
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+// tagFilter reads ?tag= (any of), ?tag_all= and ?tag_none=, each repeatable.
+func tagFilter(c *gin.Context) (models.TagFilter, bool) {
+	var filter models.TagFilter
+	for _, param := range []struct {
+		name   string
+		target *[]string
+	}{
+		{"tag", &filter.Any},
+		{"tag_all", &filter.All},
+		{"tag_none", &filter.None},
+	} {
+		values := c.QueryArray(param.name)
+		if len(values) == 0 {
+			continue
+		}
+		tags := make([]string, 0, len(values))
+		for _, v := range values {
+			tag, err := models.NormalizeTag(v)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+				return filter, false
+			}
+			tags = append(tags, tag)
+		}
+		*param.target = tags
+	}
+	return filter, true
+}
+
+// ListTags autocompletes tags from ?prefix=, most used first.
+func ListTags(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var prefix string
+		if p := c.Query("prefix"); p != "" {
+			// normalized the same way as the tags, without rejecting partial input
+			prefix, _ = models.NormalizeTag(p)
+			if prefix == "" {
+				c.JSON(200, gin.H{"data": []models.TagCount{}})
+				return
+			}
+		}
+		tags, err := r.ListTags(c.Request.Context(), userID, prefix, helpers.ParseInt(c.DefaultQuery("limit", "10")))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list tags"})
+			return
+		}
+		c.JSON(200, gin.H{"data": tags})
+	}
+}
+
+type renameTagRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// RenameTag renames a tag on all of the user's transactions. Renaming to a
+// tag that already exists merges the two.
+func RenameTag(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req renameTagRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		from, err := models.NormalizeTag(c.Param("tag"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return
+		}
+		into, err := models.NormalizeTag(req.Name)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return
+		}
+		if from == into {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "the new name is the same as the old one"})
+			return
+		}
+
+		changes, err := r.MergeTags(c.Request.Context(), userID, []string{from}, into)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to rename tag"})
+			return
+		}
+		recordChanges(c, r, userID, changes)
+		c.JSON(200, gin.H{"message": "tag renamed", "tag": into, "updated": len(changes)})
+	}
+}
+
+type mergeTagsRequest struct {
+	Tags []string `json:"tags" binding:"required,min=1"`
+	Into string   `json:"into" binding:"required"`
+}
+
+// MergeTags replaces several tags with one on all of the user's transactions.
+func MergeTags(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req mergeTagsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		into, err := models.NormalizeTag(req.Into)
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return
+		}
+		var from []string
+		for _, t := range req.Tags {
+			tag, err := models.NormalizeTag(t)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+				return
+			}
+			if tag != into {
+				from = append(from, tag)
+			}
+		}
+		if len(from) == 0 {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "nothing to merge"})
+			return
+		}
+
+		changes, err := r.MergeTags(c.Request.Context(), userID, from, into)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to merge tags"})
+			return
+		}
+		recordChanges(c, r, userID, changes)
+		c.JSON(200, gin.H{"message": "tags merged", "tag": into, "updated": len(changes)})
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+type createTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=transactions:read transactions:write admin"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+func CreateAPIToken(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req createTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "name and at least one valid scope are required"})
+			return
+		}
+
+		plain, err := helpers.GenerateAPIToken()
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate token"})
+			return
+		}
+
+		token := &models.APIToken{
+			UserId:    userID,
+			Name:      req.Name,
+			TokenHash: helpers.HashToken(plain),
+			Hint:      plain[len(plain)-4:],
+			Scopes:    req.Scopes,
+		}
+		if req.ExpiresInDays > 0 {
+			expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+			token.ExpiresAt = &expiresAt
+		}
+
+		if err := r.CreateAPIToken(ctx, token); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create token"})
+			return
+		}
+
+		audit(c, r, models.AuditTokenCreated, userID, primitive.NilObjectID, map[string]interface{}{"token_id": token.Id.Hex(), "name": token.Name, "scopes": token.Scopes})
+
+		// the plaintext token is only ever returned here
+		c.JSON(201, gin.H{"message": "token created, copy it now as it won't be shown again", "token": plain, "data": token})
+	}
+}
+
+func ListAPITokens(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		tokens, err := r.ListAPITokens(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list tokens"})
+			return
+		}
+		c.JSON(200, gin.H{"data": tokens})
+	}
+}
+
+func RevokeAPIToken(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid token ID"})
+			return
+		}
+
+		if err := r.RevokeAPIToken(c.Request.Context(), id, userID); err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "token not found"})
+			return
+		}
+		audit(c, r, models.AuditTokenRevoked, userID, primitive.NilObjectID, map[string]interface{}{"token_id": id.Hex()})
+		c.JSON(200, gin.H{"message": "token revoked successfully"})
+	}
+}
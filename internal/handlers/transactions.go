@@ -1,12 +1,20 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/export"
 	"github.com/Joshua-takyi/expense/server/internal/helpers"
 	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/period"
+	"github.com/Joshua-takyi/expense/server/internal/storage"
 )
 
 func AddTransaction(r models.Service) gin.HandlerFunc {
@@ -18,28 +26,57 @@ func AddTransaction(r models.Service) gin.HandlerFunc {
 			return
 		}
 
-		userClaims, exists := c.Get("user")
-		if !exists {
-			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "unauthorized"})
+		userID, ok := currentUserID(c)
+		if !ok {
 			return
 		}
-
-		claims, ok := userClaims.(*helpers.UseClaims)
-		if !ok {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+		if tx.Type == models.TypeTransfer {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "use POST /transfers to move money between accounts"})
 			return
-
 		}
-
-		userID, err := primitive.ObjectIDFromHex(claims.UserID)
-		if err != nil {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+		if tx.Type == models.TypeSettlement {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "use POST /ledgers/:id/settlements to settle up"})
+			return
+		}
+		// only CreateTransfer links transactions together, and a new
+		// transaction never starts out in the trash
+		tx.LinkedId, tx.Direction = nil, ""
+		tx.DeletedAt = nil
+		var ledger *models.Ledger
+		if tx.LedgerId != nil {
+			if ledger, ok = memberLedger(c, r, userID, *tx.LedgerId, models.LedgerEditor); !ok {
+				return
+			}
+		}
+		if len(tx.Splits) > 0 {
+			if err := models.ValidateSplits(tx.Amount, tx.Splits); err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+				return
+			}
+		}
+		if _, err := models.NormalizeTags(tx.Tags); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return
+		}
+		if !checkTransactionAccount(c, r, userID, tx.AccountId) {
+			return
+		}
+		if !resolvePayee(c, r, userID, &tx) {
+			return
+		}
+		if !applyRules(c, r, userID, &tx) {
+			return
+		}
+		// after the rules, which may change the type
+		if tx.Sharing != nil && !checkSharing(c, ledger, tx.Type, tx.Sharing, tx.Amount) {
 			return
 		}
 		if err := r.AddTransaction(ctx, &tx, userID); err != nil {
 			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to add transaction"})
 			return
 		}
+		recordRevision(c, r, models.RevisionCreated, userID, nil, &tx)
+		c.Header("ETag", etag(tx.Version))
 		c.JSON(201, gin.H{"message": "transaction added successfully", "transaction": tx})
 	}
 }
@@ -49,21 +86,26 @@ func QueryTransactions(r models.Service) gin.HandlerFunc {
 		limit := c.DefaultQuery("limit", "10")
 		offset := c.DefaultQuery("offset", "0")
 		ctx := c.Request.Context()
-		userClaims, exists := c.Get("user")
-		if !exists {
-			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "unauthorized"})
+		userID, ok := currentUserID(c)
+		if !ok {
 			return
 		}
 
-		claims, ok := userClaims.(*helpers.UseClaims)
+		scope, ok := transactionScope(c, r, userID)
+		if !ok {
+			return
+		}
+		prefs, ok := userPreferences(c, r, userID)
+		if !ok {
+			return
+		}
+		from, to, ok := periodRange(c, prefs.Calendar())
 		if !ok {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
 			return
 		}
 
-		userID, err := primitive.ObjectIDFromHex(claims.UserID)
-		if err != nil {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+		tags, ok := tagFilter(c)
+		if !ok {
 			return
 		}
 
@@ -71,7 +113,7 @@ func QueryTransactions(r models.Service) gin.HandlerFunc {
 		category := c.QueryArray("category")
 		order := c.Query("order")
 
-		transactions, err := r.GetTransactionByQuery(ctx, userID, query, category, order, helpers.ParseInt(limit), helpers.ParseInt(offset))
+		transactions, err := r.GetTransactionByQuery(ctx, scope, query, category, tags, from, to, order, helpers.ParseInt(limit), helpers.ParseInt(offset))
 		if err != nil {
 			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to search transactions"})
 			return
@@ -85,80 +127,443 @@ func ListUserTransactions(r models.Service) gin.HandlerFunc {
 		limit := c.DefaultQuery("limit", "10")
 		offset := c.DefaultQuery("offset", "0")
 		ctx := c.Request.Context()
-		userClaims, exists := c.Get("user")
-		if !exists {
-			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "unauthorized"})
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		scope, ok := transactionScope(c, r, userID)
+		if !ok {
+			return
+		}
+
+		transactions, err := r.ListUserTransactions(ctx, scope, helpers.ParseInt(limit), helpers.ParseInt(offset))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list transactions"})
+			return
+		}
+		c.JSON(200, gin.H{"data": transactions})
+	}
+}
+
+func RemoveTransaction(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := liveTransaction(c, r, userID, models.LedgerEditor)
+		if !ok || !checkSettlementParty(c, r, userID, tx) {
+			return
+		}
+
+		version, ok := ifMatchVersion(c, tx.Version)
+		if !ok {
+			return
+		}
+		if _, err := removeTransaction(c, r, userID, tx, version); err != nil {
+			transactionWriteFailed(c, err, "failed to remove transaction")
 			return
 		}
+		audit(c, r, models.AuditTransactionDeleted, userID, primitive.NilObjectID, map[string]interface{}{
+			"transaction_id": tx.Id.Hex(),
+			"amount":         tx.Amount,
+			"type":           tx.Type,
+			"category":       tx.Category,
+			"description":    tx.Description,
+		})
+		c.JSON(200, gin.H{"message": "transaction moved to trash"})
+	}
+}
 
-		claims, ok := userClaims.(*helpers.UseClaims)
+// TransactionSummary totals income and expenses per day, week, month, quarter
+// or year (?group_by=) in the user's calendar, plus totals per category, for
+// the user's personal transactions or a ?ledger_id=.
+// Without a range it covers the current year. ?category= narrows the totals to
+// those categories, counting only the matching lines of split transactions,
+// and the tag filters of the search apply too.
+func TransactionSummary(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		scope, ok := transactionScope(c, r, userID)
+		if !ok {
+			return
+		}
+		prefs, ok := userPreferences(c, r, userID)
 		if !ok {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
 			return
 		}
+		cal := prefs.Calendar()
 
-		userID, err := primitive.ObjectIDFromHex(claims.UserID)
+		unit, err := period.ParseUnit(c.DefaultQuery("group_by", "month"))
 		if err != nil {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return
+		}
+		from, to, ok := periodRange(c, cal)
+		if !ok {
 			return
 		}
+		if from.IsZero() && to.IsZero() {
+			from, to = cal.Range(period.Year, time.Now())
+		}
 
-		transactions, err := r.ListUserTransactions(ctx, userID, helpers.ParseInt(limit), helpers.ParseInt(offset))
+		tags, ok := tagFilter(c)
+		if !ok {
+			return
+		}
+		category := c.QueryArray("category")
+		transactions, err := r.GetTransactionByQuery(c.Request.Context(), scope, "", category, tags, from, to, "old", 0, 0)
 		if err != nil {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list transactions"})
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to summarize transactions"})
 			return
 		}
-		c.JSON(200, gin.H{"data": transactions})
+		c.JSON(200, gin.H{
+			"currency":   prefs.Currency,
+			"timezone":   cal.Location.String(),
+			"data":       models.SummarizeTransactions(transactions, cal, unit, from, to, category),
+			"categories": models.SummarizeCategories(transactions, category),
+			"tags":       models.SummarizeTags(transactions, category),
+		})
 	}
 }
 
-func RemoveTransaction(r models.Service) gin.HandlerFunc {
+// ExportTransactions downloads the user's transactions in a date range as
+// CSV, accepting the same range and ledger parameters as the query endpoint.
+func ExportTransactions(r models.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx := c.Request.Context()
-		txID := c.Param("id")
-		if txID == "" {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "transaction ID is required"})
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		scope, ok := transactionScope(c, r, userID)
+		if !ok {
+			return
+		}
+		prefs, ok := userPreferences(c, r, userID)
+		if !ok {
+			return
+		}
+		from, to, ok := periodRange(c, prefs.Calendar())
+		if !ok {
+			return
+		}
+
+		tags, ok := tagFilter(c)
+		if !ok {
+			return
+		}
+
+		transactions, err := r.GetTransactionByQuery(c.Request.Context(), scope, "", nil, tags, from, to, "old", 0, 0)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to export transactions"})
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := export.WriteTransactionsCSV(&buf, transactions, prefs); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to export transactions"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="transactions.csv"`)
+		c.Data(200, "text/csv; charset=utf-8", buf.Bytes())
+	}
+}
+
+// ownTransaction loads the transaction named in the path, writing the error
+// response itself when it is missing or the user lacks the role need on it.
+// Personal transactions are accessible only to their author; shared ones to
+// the members of their ledger by role, see transactionRole.
+func ownTransaction(c *gin.Context, r models.Service, userID primitive.ObjectID, need string) (*models.Transaction, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid transaction ID"})
+		return nil, false
+	}
+	tx, err := r.GetTransactionDetails(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(404, gin.H{"error": constants.ErrNoDocuments, "message": "transaction not found"})
+		return nil, false
+	}
+	role, err := transactionRole(c, r, userID, tx)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to check access"})
+		return nil, false
+	}
+	if !models.LedgerRoleAllows(role, need) {
+		c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "forbidden"})
+		return nil, false
+	}
+	return tx, true
+}
+
+// liveTransaction is ownTransaction for transactions outside the trash.
+func liveTransaction(c *gin.Context, r models.Service, userID primitive.ObjectID, need string) (*models.Transaction, bool) {
+	tx, ok := ownTransaction(c, r, userID, need)
+	if ok && tx.DeletedAt != nil {
+		c.JSON(404, gin.H{"error": constants.ErrNoDocuments, "message": "transaction not found"})
+		return nil, false
+	}
+	return tx, ok
+}
+
+func GetTransaction(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := liveTransaction(c, r, userID, models.LedgerViewer)
+		if !ok {
+			return
+		}
+		c.Header("ETag", etag(tx.Version))
+		c.JSON(200, gin.H{"data": tx})
+	}
+}
+
+type updateTransactionRequest struct {
+	Amount      *float64 `json:"amount"`
+	Type        *string  `json:"type" binding:"omitempty,oneof=income expense"`
+	Description *string  `json:"description" binding:"omitempty,max=500"`
+	Note        *string  `json:"note" binding:"omitempty,max=1000"`
+	Category    *string  `json:"category" binding:"omitempty,max=100"`
+	AccountId   *string  `json:"account_id"`
+	// an empty list turns a split transaction back into a plain one, which
+	// then needs a category
+	Splits *[]models.Split `json:"splits"`
+	Tags   *[]string       `json:"tags"`
+	// an empty string removes the payee
+	PayeeId *string `json:"payee_id"`
+	// null stops sharing the expense between ledger members
+	Sharing json.RawMessage `json:"sharing"`
+}
+
+// UpdateTransaction changes the fields present in the body. The client must
+// send the ETag it last saw in If-Match so concurrent edits from another
+// device are rejected with 412 rather than silently overwritten.
+func UpdateTransaction(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req updateTransactionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		updates := map[string]interface{}{}
+		if req.Amount != nil {
+			updates["amount"] = *req.Amount
+		}
+		if req.Type != nil {
+			updates["type"] = *req.Type
+		}
+		if req.Description != nil {
+			updates["description"] = *req.Description
+		}
+		if req.Note != nil {
+			updates["note"] = *req.Note
+		}
+		if req.Category != nil {
+			updates["category"] = strings.ToLower(strings.TrimSpace(*req.Category))
+		}
+		if req.Tags != nil {
+			tags, err := models.NormalizeTags(*req.Tags)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+				return
+			}
+			updates["tags"] = tags
+		}
+		if req.PayeeId != nil {
+			if *req.PayeeId == "" {
+				updates["payee_id"] = nil
+			} else {
+				payeeID, err := primitive.ObjectIDFromHex(*req.PayeeId)
+				if err != nil {
+					c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid payee ID"})
+					return
+				}
+				if _, err := r.GetPayee(c.Request.Context(), payeeID, userID); err != nil {
+					c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "payee not found"})
+					return
+				}
+				updates["payee_id"] = payeeID
+			}
+		}
+		if req.AccountId != nil {
+			accountID, err := primitive.ObjectIDFromHex(*req.AccountId)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid account ID"})
+				return
+			}
+			if !checkTransactionAccount(c, r, userID, &accountID) {
+				return
+			}
+			updates["account_id"] = accountID
+		}
+		if len(updates) == 0 && req.Splits == nil && req.Sharing == nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "no fields to update"})
+			return
+		}
+
+		tx, ok := liveTransaction(c, r, userID, models.LedgerEditor)
+		if !ok || !checkSettlementParty(c, r, userID, tx) {
+			return
+		}
+		if tx.IsTransfer() && req.Type != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "the type of a transfer can't be changed"})
+			return
+		}
+		if !splitUpdates(c, tx, &req, updates) {
+			return
+		}
+		if !sharingUpdates(c, r, tx, &req, updates) {
+			return
+		}
+		version, ok := ifMatchVersion(c, tx.Version)
+		if !ok {
 			return
 		}
-		id, err := primitive.ObjectIDFromHex(txID)
+
+		updated, err := updateTransaction(c, r, models.RevisionUpdated, userID, tx, version, updates)
 		if err != nil {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid transaction ID"})
+			transactionWriteFailed(c, err, "failed to update transaction")
 			return
 		}
+		c.Header("ETag", etag(updated.Version))
+		c.JSON(200, gin.H{"message": "transaction updated successfully", "data": updated})
+	}
+}
+
+// splitUpdates adds the split lines of an update to updates, checking that
+// they still add up to the (possibly new) amount. Split transactions get
+// their category from the lines, so it can only be set when removing them.
+func splitUpdates(c *gin.Context, tx *models.Transaction, req *updateTransactionRequest, updates map[string]interface{}) bool {
+	amount := tx.Amount
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+
+	switch {
+	case req.Splits != nil && tx.IsTransfer():
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "transfers can't be split"})
+		return false
+	case req.Splits != nil && len(*req.Splits) == 0:
+		if req.Category == nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "category is required when removing splits"})
+			return false
+		}
+		updates["splits"] = nil
+	case req.Splits != nil:
+		if req.Category != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "the category of a split transaction comes from its lines"})
+			return false
+		}
+		if err := models.ValidateSplits(amount, *req.Splits); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+			return false
+		}
+		updates["splits"] = *req.Splits
+		updates["category"] = models.CategorySplit
+	case len(tx.Splits) > 0:
+		if req.Category != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "the category of a split transaction comes from its lines"})
+			return false
+		}
+		if err := models.ValidateSplits(amount, tx.Splits); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "send splits that add up to the new amount"})
+			return false
+		}
+	}
+	return true
+}
 
-		userClaims, exists := c.Get("user")
-		if !exists {
-			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "unauthorized"})
+func ListTrash(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := c.DefaultQuery("limit", "10")
+		offset := c.DefaultQuery("offset", "0")
+		userID, ok := currentUserID(c)
+		if !ok {
 			return
 		}
 
-		claims, ok := userClaims.(*helpers.UseClaims)
+		scope, ok := transactionScope(c, r, userID)
 		if !ok {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
 			return
 		}
 
-		userID, err := primitive.ObjectIDFromHex(claims.UserID)
+		transactions, err := r.ListDeletedTransactions(c.Request.Context(), scope, helpers.ParseInt(limit), helpers.ParseInt(offset))
 		if err != nil {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid user ID"})
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list trash"})
+			return
+		}
+		c.JSON(200, gin.H{"data": transactions})
+	}
+}
+
+func RestoreTransaction(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := ownTransaction(c, r, userID, models.LedgerEditor)
+		if !ok {
+			return
+		}
+		if tx.DeletedAt == nil {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "transaction is not in the trash"})
+			return
+		}
+		if !checkSettlementParty(c, r, userID, tx) {
 			return
 		}
 
-		tx, err := r.GetTransactionDetails(ctx, id)
+		restored, err := restoreTransaction(c, r, userID, tx)
 		if err != nil {
-			c.JSON(404, gin.H{"error": constants.ErrNoDocuments, "message": "transaction not found"})
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "transaction is not in the trash"})
 			return
 		}
+		audit(c, r, models.AuditTransactionRestored, userID, primitive.NilObjectID, map[string]interface{}{"transaction_id": tx.Id.Hex()})
+		c.Header("ETag", etag(restored.Version))
+		c.JSON(200, gin.H{"message": "transaction restored", "data": restored})
+	}
+}
 
-		if tx.UserId != userID {
-			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "forbidden"})
+// PurgeTransaction permanently deletes a transaction that is already in the
+// trash, along with its attachments.
+func PurgeTransaction(r models.Service, files storage.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		tx, ok := ownTransaction(c, r, userID, models.LedgerEditor)
+		if !ok {
+			return
+		}
+		if tx.DeletedAt == nil {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "move the transaction to the trash before deleting it permanently"})
 			return
 		}
 
-		if err := r.RemoveTransaction(ctx, id); err != nil {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to remove transaction"})
+		if err := purgeTransaction(c, r, tx); err != nil {
+			c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "transaction is not in the trash"})
 			return
 		}
-		c.JSON(200, gin.H{"message": "transaction removed successfully"})
+		purged := []primitive.ObjectID{tx.Id}
+		if tx.LinkedId != nil {
+			purged = append(purged, *tx.LinkedId)
+		}
+		removeTransactionAttachments(c, r, files, purged...)
+		audit(c, r, models.AuditTransactionPurged, userID, primitive.NilObjectID, map[string]interface{}{"transaction_id": tx.Id.Hex()})
+		c.JSON(200, gin.H{"message": "transaction permanently deleted"})
 	}
 }
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+type createTransferRequest struct {
+	FromAccountId string  `json:"from_account_id" binding:"required"`
+	ToAccountId   string  `json:"to_account_id" binding:"required"`
+	Amount        float64 `json:"amount" binding:"required,gt=0"`
+	Description   string  `json:"description" binding:"max=500"`
+	Note          string  `json:"note" binding:"max=1000"`
+}
+
+// transferAccount loads one side of a new transfer, which must be an active
+// account of the user.
+func transferAccount(c *gin.Context, r models.Service, userID primitive.ObjectID, field, hex string) (*models.Account, bool) {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid " + field})
+		return nil, false
+	}
+	account, err := r.GetAccount(c.Request.Context(), id, userID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": field + " not found"})
+		return nil, false
+	}
+	if account.Archived {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": field + " is archived"})
+		return nil, false
+	}
+	return account, true
+}
+
+// CreateTransfer moves money between two of the user's accounts, writing
+// both legs in one database transaction.
+func CreateTransfer(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req createTransferRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		from, ok := transferAccount(c, r, userID, "from_account_id", req.FromAccountId)
+		if !ok {
+			return
+		}
+		to, ok := transferAccount(c, r, userID, "to_account_id", req.ToAccountId)
+		if !ok {
+			return
+		}
+		if from.Id == to.Id {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "can't transfer to the same account"})
+			return
+		}
+		if from.Currency != to.Currency {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "both accounts must use the same currency"})
+			return
+		}
+
+		legs := [2]models.Transaction{}
+		for i, account := range []*models.Account{from, to} {
+			accountID := account.Id
+			legs[i] = models.Transaction{
+				Amount:      req.Amount,
+				Description: req.Description,
+				Note:        req.Note,
+				Category:    models.TypeTransfer,
+				AccountId:   &accountID,
+			}
+		}
+		out, in := &legs[0], &legs[1]
+		if err := r.CreateTransfer(c.Request.Context(), userID, out, in); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create transfer"})
+			return
+		}
+		recordRevision(c, r, models.RevisionCreated, userID, nil, out)
+		recordRevision(c, r, models.RevisionCreated, userID, nil, in)
+		c.JSON(201, gin.H{"message": "transfer created successfully", "data": gin.H{"out": out, "in": in}})
+	}
+}
+
+// The helpers below change a transaction the way its handler asked, and for
+// a transfer leg do the same to the other leg. They record history for every
+// transaction they changed and leave the HTTP response to the caller.
+
+func recordPeerRevision(c *gin.Context, r models.Service, action string, actorID primitive.ObjectID, peer *models.PeerChange) {
+	if peer != nil {
+		recordRevision(c, r, action, actorID, peer.Before, peer.After)
+	}
+}
+
+func updateTransaction(c *gin.Context, r models.Service, action string, actorID primitive.ObjectID, tx *models.Transaction, version int64, updates map[string]interface{}) (*models.Transaction, error) {
+	ctx := c.Request.Context()
+	if !tx.IsTransfer() {
+		updated, err := r.UpdateTransaction(ctx, tx.Id, version, updates)
+		if err == nil {
+			recordRevision(c, r, action, actorID, tx, updated)
+		}
+		return updated, err
+	}
+
+	updated, peer, err := r.UpdateTransfer(ctx, tx.Id, version, updates)
+	if err == nil {
+		recordRevision(c, r, action, actorID, tx, updated)
+		recordPeerRevision(c, r, action, actorID, peer)
+	}
+	return updated, err
+}
+
+func removeTransaction(c *gin.Context, r models.Service, actorID primitive.ObjectID, tx *models.Transaction, version int64) (*models.Transaction, error) {
+	ctx := c.Request.Context()
+	if !tx.IsTransfer() {
+		deleted, err := r.RemoveTransaction(ctx, tx.Id, version)
+		if err == nil {
+			recordRevision(c, r, models.RevisionDeleted, actorID, tx, deleted)
+		}
+		return deleted, err
+	}
+
+	deleted, peer, err := r.RemoveTransfer(ctx, tx.Id, version)
+	if err == nil {
+		recordRevision(c, r, models.RevisionDeleted, actorID, tx, deleted)
+		recordPeerRevision(c, r, models.RevisionDeleted, actorID, peer)
+	}
+	return deleted, err
+}
+
+func restoreTransaction(c *gin.Context, r models.Service, actorID primitive.ObjectID, tx *models.Transaction) (*models.Transaction, error) {
+	ctx := c.Request.Context()
+	if !tx.IsTransfer() {
+		restored, err := r.RestoreTransaction(ctx, tx.Id)
+		if err == nil {
+			recordRevision(c, r, models.RevisionRestored, actorID, tx, restored)
+		}
+		return restored, err
+	}
+
+	restored, peer, err := r.RestoreTransfer(ctx, tx.Id)
+	if err == nil {
+		recordRevision(c, r, models.RevisionRestored, actorID, tx, restored)
+		recordPeerRevision(c, r, models.RevisionRestored, actorID, peer)
+	}
+	return restored, err
+}
+
+func purgeTransaction(c *gin.Context, r models.Service, tx *models.Transaction) error {
+	if tx.IsTransfer() {
+		return r.PurgeTransfer(c.Request.Context(), tx.Id)
+	}
+	return r.PurgeTransaction(c.Request.Context(), tx.Id)
+}
+
+// transactionWriteFailed writes the response for an error from
+// updateTransaction or removeTransaction.
+func transactionWriteFailed(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrVersionConflict):
+		preconditionFailed(c, 0)
+	case errors.Is(err, models.ErrTransferSameAccount), errors.Is(err, models.ErrTransferCurrency):
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+	default:
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": message})
+	}
+}
@@ -1,25 +1,41 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/csrf"
 	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
 	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/password"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type registerRequest struct {
+	Name     string `json:"name" binding:"required,max=100"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
 func RegisterUser(r models.Service) gin.HandlerFunc {
+	checker := password.CheckerFromEnv()
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
 
-		var user models.User
-		if err := c.ShouldBindJSON(&user); err != nil {
-			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid request body"})
+		var req registerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid request body", "fields": fieldErrors(err)})
 			return
 		}
+		if !checkNewPassword(c, checker, "password", req.Password, req.Name, req.Email) {
+			return
+		}
+
+		user := models.User{Name: req.Name, Email: req.Email, Password: req.Password}
 		userData, err := r.RegisterUser(ctx, &user)
 		if err != nil {
 			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": err.Error()})
@@ -29,71 +45,162 @@ func RegisterUser(r models.Service) gin.HandlerFunc {
 	}
 }
 
-func AuthenticateUser(r models.Service) gin.HandlerFunc {
+type loginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+func AuthenticateUser(r models.Service, m mailer.Mailer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
-		req := &models.User{}
+		var req loginRequest
 
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid request body"})
 			return
 		}
 
+		if !checkLoginThrottle(c, r, req.Email) {
+			return
+		}
+
 		user, err := r.AuthenticateUser(ctx, req.Email, req.Password)
-		if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			recordLoginFailure(c, r, m, req.Email, "invalid_credentials")
 			c.JSON(401, gin.H{"error": constants.ErrUnauthorized, "message": "invalid email or password"})
 			return
 		}
-		secret := os.Getenv("JWT_SECRET")
-		claims := &helpers.UseClaims{
-			UserID: user.Id.Hex(),
-			Email:  user.Email,
+		if errors.Is(err, models.ErrAccountDisabled) {
+			recordLoginFailure(c, r, m, req.Email, "account_disabled")
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "this account has been disabled"})
+			return
+		}
+		if errors.Is(err, models.ErrPasswordResetRequired) {
+			audit(c, r, models.AuditLoginFailed, primitive.NilObjectID, primitive.NilObjectID, map[string]interface{}{"email": req.Email, "reason": "password_reset_required"})
+			go sendPasswordResetEmail(r, m, req.Email, "You need to choose a new password before signing in again.")
+			c.JSON(403, gin.H{"error": constants.ErrForbidden, "message": "a password reset is required, check your email for a reset link"})
+			return
 		}
-
-		token, err := helpers.GenerateJWT(claims, secret)
 		if err != nil {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate token"})
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
 			return
 		}
 
-		// generate csrf token
-		csrfToken, err := helpers.GenerateCsrfToken()
-		if err != nil {
-			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate csrf token"})
+		// with 2FA enabled the password only earns a challenge token; the session
+		// cookies are issued by VerifyMFALogin once the code checks out
+		if user.MFAEnabled {
+			mfaToken, err := helpers.GenerateMFAToken(&helpers.UseClaims{UserID: user.Id.Hex(), Email: user.Email}, os.Getenv("JWT_SECRET"))
+			if err != nil {
+				c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to generate token"})
+				return
+			}
+			c.JSON(200, gin.H{"mfa_required": true, "mfa_token": mfaToken})
 			return
 		}
 
-		// set secure to false for development, true for production
-		isProduction := os.Getenv("GIN_MODE") == "release" || os.Getenv("NODE_ENV") == "production"
-		c.SetCookie("csrf_token", csrfToken, 3600*24*7, "/", "", isProduction, true)
-		c.SetCookie("auth_token", token, 3600*24*7, "/", "", isProduction, true)
+		_ = r.ClearLoginFailures(ctx, models.AccountThrottleKey(user.Email))
+		auditLogin(c, r, user, "password")
+		startSession(c, user)
+	}
+}
+
+// startSession issues the auth and csrf cookies for an authenticated user and
+// writes the login response.
+func startSession(c *gin.Context, user *models.User) {
+	csrfToken, err := setSessionCookies(c, user)
+	if err != nil {
+		c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": user, "csrf_token": csrfToken})
+}
+
+func setSessionCookies(c *gin.Context, user *models.User) (string, error) {
+	secret := os.Getenv("JWT_SECRET")
+	claims := &helpers.UseClaims{
+		UserID: user.Id.Hex(),
+		Email:  user.Email,
+	}
+
+	token, err := helpers.GenerateJWT(claims, secret)
+	if err != nil {
+		return "", errors.New("failed to generate token")
+	}
+
+	cfg := csrf.FromEnv()
+	http.SetCookie(c.Writer, cfg.Cookie(csrf.SessionCookie, token, csrf.MaxAge, true))
+
+	// the csrf token is bound to this session cookie
+	csrfToken, err := cfg.Issue(c.Writer, token)
+	if err != nil {
+		return "", errors.New("failed to generate csrf token")
+	}
+	return csrfToken, nil
+}
 
-		c.JSON(200, gin.H{"data": user, "csrf_token": csrfToken})
+func LogoutUser(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
 
+		audit(c, r, models.AuditLogout, userID, primitive.NilObjectID, nil)
+		clearSessionCookies(c)
+		c.JSON(200, gin.H{"message": "logged out successfully"})
 	}
 }
 
-func LogoutUser(c *gin.Context) {
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "auth_token",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   os.Getenv("GIN_MODE") == "release" || os.Getenv("NODE_ENV") == "production",
-		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Unix(0, 0),
-	})
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "csrf_token",
-		Value:    "",
-		Path:     "/",
-		MaxAge:   -1,
-		HttpOnly: true,
-		Secure:   os.Getenv("GIN_MODE") == "release" || os.Getenv("NODE_ENV") == "production",
-		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Unix(0, 0),
-	})
-
-	c.JSON(200, gin.H{"message": "logged out successfully"})
+func clearSessionCookies(c *gin.Context) {
+	cfg := csrf.FromEnv()
+	http.SetCookie(c.Writer, cfg.Cookie(csrf.SessionCookie, "", -1, true))
+	cfg.Clear(c.Writer)
+}
+
+type updateProfileRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+func UpdateProfile(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req updateProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+
+		before, err := r.GetUserProfile(ctx, userID)
+		if err != nil {
+			c.JSON(404, gin.H{"error": constants.ErrUserNotFound, "message": "user not found"})
+			return
+		}
+		version, ok := ifMatchVersion(c, before.Version)
+		if !ok {
+			return
+		}
+		newVersion, err := r.UpdateUserProfile(ctx, userID, version, &models.User{Name: req.Name})
+		if errors.Is(err, models.ErrVersionConflict) {
+			preconditionFailed(c, 0)
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update profile"})
+			return
+		}
+
+		audit(c, r, models.AuditProfileUpdated, userID, primitive.NilObjectID, map[string]interface{}{
+			"name": map[string]string{"from": before.Name, "to": req.Name},
+		})
+		before.Name = req.Name
+		before.Version = newVersion
+		c.Header("ETag", etag(newVersion))
+		c.JSON(200, gin.H{"message": "profile updated successfully", "user": before})
+	}
 }
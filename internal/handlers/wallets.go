@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/period"
+)
+
+type createAccountRequest struct {
+	Name           string  `json:"name" binding:"required,max=100"`
+	Kind           string  `json:"kind" binding:"required,oneof=cash checking credit savings"`
+	Currency       string  `json:"currency" binding:"omitempty,iso4217"`
+	OpeningBalance float64 `json:"opening_balance"`
+}
+
+type updateAccountRequest struct {
+	Name           *string  `json:"name" binding:"omitempty,min=1,max=100"`
+	Kind           *string  `json:"kind" binding:"omitempty,oneof=cash checking credit savings"`
+	Currency       *string  `json:"currency" binding:"omitempty,iso4217"`
+	OpeningBalance *float64 `json:"opening_balance"`
+	Archived       *bool    `json:"archived"`
+}
+
+// ownAccount loads the account named in the path, writing the error response
+// itself when it doesn't exist or belongs to someone else.
+func ownAccount(c *gin.Context, r models.Service, userID primitive.ObjectID) (*models.Account, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrBadRequest, "message": "invalid account ID"})
+		return nil, false
+	}
+	account, err := r.GetAccount(c.Request.Context(), id, userID)
+	if err != nil {
+		c.JSON(404, gin.H{"error": constants.ErrResourceNotFound, "message": "account not found"})
+		return nil, false
+	}
+	return account, true
+}
+
+// checkTransactionAccount validates the account a transaction is booked to.
+// Users who have created accounts must pick one; others may leave it out.
+func checkTransactionAccount(c *gin.Context, r models.Service, userID primitive.ObjectID, accountID *primitive.ObjectID) bool {
+	ctx := c.Request.Context()
+	if accountID == nil || accountID.IsZero() {
+		n, err := r.CountAccounts(ctx, userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to check accounts"})
+			return false
+		}
+		if n > 0 {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "account_id is required"})
+			return false
+		}
+		return true
+	}
+
+	account, err := r.GetAccount(ctx, *accountID, userID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "account not found"})
+		return false
+	}
+	if account.Archived {
+		c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "account is archived"})
+		return false
+	}
+	return true
+}
+
+func CreateAccount(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req createAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		if req.Currency == "" {
+			prefs, ok := userPreferences(c, r, userID)
+			if !ok {
+				return
+			}
+			req.Currency = prefs.Currency
+		}
+
+		account := &models.Account{
+			UserId:         userID,
+			Name:           req.Name,
+			Kind:           req.Kind,
+			Currency:       req.Currency,
+			OpeningBalance: req.OpeningBalance,
+		}
+		if err := r.CreateAccount(c.Request.Context(), account); err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to create account"})
+			return
+		}
+		account.Balance = &account.OpeningBalance
+		c.JSON(201, gin.H{"message": "account created successfully", "data": account})
+	}
+}
+
+// ListAccounts returns the user's accounts with their current balances.
+func ListAccounts(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		accounts, err := r.ListAccounts(ctx, userID, c.Query("archived") == "true")
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list accounts"})
+			return
+		}
+		ids := make([]primitive.ObjectID, len(accounts))
+		for i := range accounts {
+			ids[i] = accounts[i].Id
+		}
+		balances, err := r.AccountBalances(ctx, ids)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute balances"})
+			return
+		}
+		for i := range accounts {
+			balance := accounts[i].OpeningBalance + balances[accounts[i].Id]
+			accounts[i].Balance = &balance
+		}
+		c.JSON(200, gin.H{"data": accounts})
+	}
+}
+
+func GetAccount(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		account, ok := ownAccount(c, r, userID)
+		if !ok {
+			return
+		}
+
+		balance, err := r.AccountBalance(c.Request.Context(), account, time.Now())
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute balance"})
+			return
+		}
+		account.Balance = &balance
+		c.JSON(200, gin.H{"data": account})
+	}
+}
+
+func UpdateAccount(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+
+		var req updateAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "invalid request body", "fields": fieldErrors(err)})
+			return
+		}
+		updates := map[string]interface{}{}
+		if req.Name != nil {
+			updates["name"] = *req.Name
+		}
+		if req.Kind != nil {
+			updates["kind"] = *req.Kind
+		}
+		if req.Currency != nil {
+			updates["currency"] = *req.Currency
+		}
+		if req.OpeningBalance != nil {
+			updates["opening_balance"] = *req.OpeningBalance
+		}
+		if req.Archived != nil {
+			updates["archived"] = *req.Archived
+		}
+		if len(updates) == 0 {
+			c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": "no fields to update"})
+			return
+		}
+
+		account, ok := ownAccount(c, r, userID)
+		if !ok {
+			return
+		}
+		if req.Currency != nil && *req.Currency == account.Currency {
+			delete(updates, "currency")
+		}
+		updated, err := r.UpdateAccount(c.Request.Context(), account.Id, userID, updates)
+		if err != nil {
+			if errors.Is(err, models.ErrAccountInUse) {
+				c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "account has transactions, its currency can't change"})
+				return
+			}
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to update account"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "account updated successfully", "data": updated})
+	}
+}
+
+// DeleteAccount removes an account without transactions. Accounts with
+// history should be archived instead so their balances stay correct.
+func DeleteAccount(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		account, ok := ownAccount(c, r, userID)
+		if !ok {
+			return
+		}
+
+		if err := r.DeleteAccount(c.Request.Context(), account.Id, userID); err != nil {
+			if errors.Is(err, models.ErrAccountInUse) {
+				c.JSON(409, gin.H{"error": constants.ErrConflict, "message": "account has transactions, archive it instead"})
+				return
+			}
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to delete account"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "account deleted successfully"})
+	}
+}
+
+// AccountBalance returns the balance at the end of ?date= (YYYY-MM-DD in the
+// user's timezone), or now when no date is given.
+func AccountBalance(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		account, ok := ownAccount(c, r, userID)
+		if !ok {
+			return
+		}
+
+		asOf := time.Now()
+		if d := c.Query("date"); d != "" {
+			prefs, ok := userPreferences(c, r, userID)
+			if !ok {
+				return
+			}
+			cal := prefs.Calendar()
+			day, err := cal.ParseDate(d)
+			if err != nil {
+				c.JSON(400, gin.H{"error": constants.ErrInvalidInput, "message": err.Error()})
+				return
+			}
+			asOf = cal.Next(period.Day, day)
+		}
+
+		balance, err := r.AccountBalance(c.Request.Context(), account, asOf)
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to compute balance"})
+			return
+		}
+		c.JSON(200, gin.H{"data": gin.H{
+			"account_id": account.Id,
+			"as_of":      asOf,
+			"balance":    balance,
+			"currency":   account.Currency,
+		}})
+	}
+}
+
+// AccountTransactions lists an account's transactions, newest first, with
+// the running balance after each one.
+func AccountTransactions(r models.Service) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := c.DefaultQuery("limit", "10")
+		offset := c.DefaultQuery("offset", "0")
+		userID, ok := currentUserID(c)
+		if !ok {
+			return
+		}
+		account, ok := ownAccount(c, r, userID)
+		if !ok {
+			return
+		}
+
+		rows, err := r.ListAccountTransactions(c.Request.Context(), account, helpers.ParseInt(limit), helpers.ParseInt(offset))
+		if err != nil {
+			c.JSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to list account transactions"})
+			return
+		}
+		c.JSON(200, gin.H{"data": rows})
+	}
+}
@@ -2,12 +2,14 @@ package helpers
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"strconv"
-	"strings"
 	"time"
-	"unicode"
 
+	"github.com/Joshua-takyi/expense/server/internal/password"
 	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -46,55 +48,68 @@ func GenerateJWT(claims *UseClaims, secretKey string) (string, error) {
 	return signedToken, nil
 }
 
-func ValidateToken(tokenStr, secretKey string) (*UseClaims, error) {
+// MFAAudience marks short-lived challenge tokens issued after a correct
+// password when the account has two-factor authentication enabled. They only
+// prove the first factor and must never be accepted as a session token.
+const MFAAudience = "mfa"
+
+// GenerateMFAToken issues the challenge token exchanged for a session once the
+// second factor has been verified.
+func GenerateMFAToken(claims *UseClaims, secretKey string) (string, error) {
+	claims.StandardClaims = &jwt.StandardClaims{
+		Issuer:    "expensetracker",
+		Audience:  MFAAudience,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secretKey))
+}
+
+func parseToken(tokenStr, secretKey string) (*UseClaims, error) {
 	claims := &UseClaims{}
 	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
 		return []byte(secretKey), nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	if !token.Valid {
+	if !token.Valid || claims.StandardClaims == nil {
 		return nil, jwt.ErrSignatureInvalid
 	}
 	return claims, nil
 }
 
-func GenerateCsrfToken() (string, error) {
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", err
+func ValidateToken(tokenStr, secretKey string) (*UseClaims, error) {
+	claims, err := parseToken(tokenStr, secretKey)
+	if err != nil {
+		return nil, err
 	}
-	return base64.URLEncoding.EncodeToString(tokenBytes), nil
-}
-
-func ValidateCsfrToken(token, expectedToken string) bool {
-	return token == expectedToken
+	if claims.Audience == MFAAudience {
+		return nil, fmt.Errorf("mfa challenge token cannot be used as a session token")
+	}
+	return claims, nil
 }
 
-func IsStrongPassword(password string) bool {
-	var (
-		hasMinLen  = len(password) >= 8
-		hasUpper   = false
-		hasLower   = false
-		hasNumber  = false
-		hasSpecial = false
-	)
-
-	for _, char := range password {
-		switch {
-		case unicode.IsUpper(char):
-			hasUpper = true
-		case unicode.IsLower(char):
-			hasLower = true
-		case unicode.IsDigit(char):
-			hasNumber = true
-		case strings.ContainsRune("!@#$%^&*()-_=+[]{}|;:',.<>?/", char):
-			hasSpecial = true
-		}
+func ValidateMFAToken(tokenStr, secretKey string) (*UseClaims, error) {
+	claims, err := parseToken(tokenStr, secretKey)
+	if err != nil {
+		return nil, err
 	}
+	if claims.Audience != MFAAudience {
+		return nil, fmt.Errorf("not an mfa challenge token")
+	}
+	return claims, nil
+}
 
-	return hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial
+// IsStrongPassword reports whether password satisfies the default password
+// policy.
+func IsStrongPassword(pw string) bool {
+	return len(password.DefaultPolicy.Validate(pw)) == 0
 }
 
 func ParseInt(n string) int {
@@ -104,3 +119,32 @@ func ParseInt(n string) int {
 	}
 	return x
 }
+
+// APITokenPrefix lets the middleware (and secret scanners) recognise personal
+// access tokens without a database lookup.
+const APITokenPrefix = "exp_pat_"
+
+// GenerateAPIToken returns a new personal access token. Only its hash is stored.
+func GenerateAPIToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return APITokenPrefix + base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// GenerateOneTimeToken returns a random URL-safe token for emailed links.
+func GenerateOneTimeToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// HashToken hashes a high-entropy bearer secret (API token, emailed link
+// token) for storage and lookup.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,120 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 // seconds per time step (RFC 6238 default)
+	TOTPSkew   = 1  // accepted time steps either side of now to absorb clock drift
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160-bit secret encoded as unpadded base32,
+// which is the format authenticator apps expect.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI builds the otpauth:// URI that is rendered as a QR code
+// by the client.
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(TOTPPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPStep returns the RFC 6238 time step counter for t.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// TOTPCode computes the HOTP value (RFC 4226) for the given step.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTP checks code against the steps around t and returns the matching
+// step so callers can reject replays of an already used code.
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(t)
+	for i := -TOTPSkew; i <= TOTPSkew; i++ {
+		step := current + int64(i)
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n random one-time codes formatted as
+// xxxx-xxxx-xxxx-xxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		raw := make([]byte, 8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(raw)
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode normalizes and hashes a recovery code for storage. The codes
+// are random and high entropy so a fast hash is sufficient.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the RFC 6238 SHA-1 secret "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeNormalizesSecret(t *testing.T) {
+	want, _ := TOTPCode(rfcSecret, 1)
+	got, err := TOTPCode(" "+strings.ToLower(rfcSecret)+" ", 1)
+	if err != nil || got != want {
+		t.Errorf("TOTPCode(lowercase) = %q, %v, want %q", got, err, want)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+	code := func(s int64) string {
+		c, err := TOTPCode(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		want   int64
+		wantOK bool
+	}{
+		{"current step", code(step), step, true},
+		{"previous step", code(step - 1), step - 1, true},
+		{"next step", code(step + 1), step + 1, true},
+		{"two steps behind", code(step - 2), 0, false},
+		{"two steps ahead", code(step + 2), 0, false},
+		{"spaces are ignored", code(step)[:3] + " " + code(step)[3:], step, true},
+		{"too short", code(step)[:5], 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("ValidateTOTP(%q) = %d, %v, want %d, %v", tt.code, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("recovery code %q isn't formatted xxxx-xxxx-xxxx-xxxx", code)
+		}
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if HashRecoveryCode(typed) != HashRecoveryCode(code) {
+			t.Errorf("HashRecoveryCode(%q) differs from HashRecoveryCode(%q)", typed, code)
+		}
+	}
+}
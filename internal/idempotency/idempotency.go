@@ -0,0 +1,160 @@
+// Package idempotency lets clients safely retry non-idempotent requests by
+// sending an Idempotency-Key header.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Joshua-takyi/expense/server/internal/auth"
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+)
+
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+
+	// how long a request may hold a key before another may take it over
+	lockTimeout = 30 * time.Second
+	// how long a concurrent duplicate waits for the first request to finish
+	waitTimeout  = 10 * time.Second
+	pollInterval = 100 * time.Millisecond
+)
+
+// Retention is how long responses are kept, IDEMPOTENCY_KEY_TTL or 24 hours.
+func Retention() time.Duration {
+	if value := os.Getenv("IDEMPOTENCY_KEY_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("invalid IDEMPOTENCY_KEY_TTL %q, using default", value)
+	}
+	return 24 * time.Hour
+}
+
+// Middleware stores the first response for each user and Idempotency-Key and
+// replays it for retries. A concurrent duplicate waits for the first request
+// to finish; reusing a key with a different body is rejected with 422.
+// Requests without the header pass through. It must run after auth.Middleware.
+func Middleware(s models.IdempotencyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(400, gin.H{"error": constants.ErrBadRequest, "message": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		user, ok := c.MustGet(auth.ContextUser).(*models.User)
+		if !ok {
+			c.AbortWithStatusJSON(500, gin.H{"error": constants.ErrInternalServer, "message": "internal server error"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": constants.ErrBadRequest, "message": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(c, body)
+
+		ctx := c.Request.Context()
+		deadline := time.Now().Add(waitTimeout)
+		for {
+			record, owned, err := s.ClaimIdempotencyKey(ctx, user.Id, key, hash, lockTimeout, Retention())
+			if errors.Is(err, models.ErrIdempotencyReleased) {
+				continue
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(500, gin.H{"error": constants.ErrInternalServer, "message": "failed to process idempotency key"})
+				return
+			}
+			if owned {
+				break
+			}
+			if record.RequestHash != hash {
+				c.AbortWithStatusJSON(422, gin.H{"error": constants.ErrInvalidInput, "message": "Idempotency-Key was already used with a different request"})
+				return
+			}
+			if record.Status == models.IdempotencyCompleted {
+				c.Header(ReplayedHeader, "true")
+				if record.ETag != "" {
+					c.Header("ETag", record.ETag)
+				}
+				c.Data(record.StatusCode, record.ContentType, record.Body)
+				c.Abort()
+				return
+			}
+			if time.Now().After(deadline) {
+				c.Header("Retry-After", "1")
+				c.AbortWithStatusJSON(409, gin.H{"error": constants.ErrConflict, "message": "a request with this Idempotency-Key is still being processed"})
+				return
+			}
+			select {
+			case <-ctx.Done():
+				c.Abort()
+				return
+			case <-time.After(pollInterval):
+			}
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// the outcome must be stored even if the client has gone away
+		ctx = context.WithoutCancel(ctx)
+
+		// server errors aren't cached so the client can retry them
+		if recorder.Status() >= 500 {
+			if err := s.ReleaseIdempotencyKey(ctx, user.Id, key); err != nil {
+				log.Printf("failed to release idempotency key: %v", err)
+			}
+			return
+		}
+		if err := s.CompleteIdempotencyKey(ctx, user.Id, key, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.Header().Get("ETag"), recorder.body.Bytes()); err != nil {
+			log.Printf("failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+// requestHash identifies a request by method, path and body so a key can't be
+// replayed against a different request.
+func requestHash(c *gin.Context, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder copies the response body while writing it to the client.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Mailer delivers transactional email such as account unlock links.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// FromEnv returns an SMTP mailer when SMTP_HOST is configured and otherwise a
+// mailer that writes messages to the log, which is enough for local development.
+func FromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@expensetracker.local"
+	}
+	return &SMTPMailer{
+		Addr:     net.JoinHostPort(host, port),
+		Host:     host,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := "From: " + m.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		body
+
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("email to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
@@ -0,0 +1,278 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ExportPending    = "pending"
+	ExportProcessing = "processing"
+	ExportReady      = "ready"
+	ExportFailed     = "failed"
+)
+
+// DataExport tracks a "download my data" job and the archive it produced.
+type DataExport struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId      primitive.ObjectID `bson:"user_id" json:"-"`
+	Status      string             `bson:"status" json:"status"`
+	FilePath    string             `bson:"file_path,omitempty" json:"-"`
+	Size        int64              `bson:"size,omitempty" json:"size,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// UserData is everything stored about a user, as included in a data export.
+type UserData struct {
+	Profile      *User                    `json:"profile"`
+	Preferences  *Preferences             `json:"preferences"`
+	Transactions []Transaction            `json:"transactions"`
+	APITokens    []APIToken               `json:"api_tokens"`
+	AuditEvents  []AuditEvent             `json:"audit_events"`
+	Other        map[string][]interface{} `json:"other,omitempty"`
+}
+
+type AccountService interface {
+	CreateDataExport(ctx context.Context, userID primitive.ObjectID) (*DataExport, error)
+	SetDataExportProcessing(ctx context.Context, id primitive.ObjectID) error
+	CompleteDataExport(ctx context.Context, id primitive.ObjectID, filePath string, size int64, expiresAt time.Time) error
+	FailDataExport(ctx context.Context, id primitive.ObjectID, reason string) error
+	GetDataExport(ctx context.Context, id, userID primitive.ObjectID) (*DataExport, error)
+	ListDataExports(ctx context.Context, userID primitive.ObjectID) ([]DataExport, error)
+	DeleteExpiredDataExports(ctx context.Context, now time.Time) ([]DataExport, error)
+	CollectUserData(ctx context.Context, userID primitive.ObjectID) (*UserData, error)
+
+	ScheduleAccountDeletion(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	CancelAccountDeletion(ctx context.Context, id primitive.ObjectID) error
+	ListDueAccountDeletions(ctx context.Context, now time.Time) ([]User, error)
+}
+
+func (r *Repository) CreateDataExport(ctx context.Context, userID primitive.ObjectID) (*DataExport, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	export := &DataExport{
+		Id:        primitive.NewObjectID(),
+		UserId:    userID,
+		Status:    ExportPending,
+		CreatedAt: time.Now(),
+	}
+	if _, err := r.DB.Database("expensetracker").Collection("data_exports").InsertOne(ctx, export); err != nil {
+		return nil, fmt.Errorf("failed to create data export: %w", err)
+	}
+	return export, nil
+}
+
+func (r *Repository) updateDataExport(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if _, err := r.DB.Database("expensetracker").Collection("data_exports").UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		return fmt.Errorf("failed to update data export: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) SetDataExportProcessing(ctx context.Context, id primitive.ObjectID) error {
+	return r.updateDataExport(ctx, id, bson.M{"$set": bson.M{"status": ExportProcessing}})
+}
+
+func (r *Repository) CompleteDataExport(ctx context.Context, id primitive.ObjectID, filePath string, size int64, expiresAt time.Time) error {
+	return r.updateDataExport(ctx, id, bson.M{"$set": bson.M{
+		"status":       ExportReady,
+		"file_path":    filePath,
+		"size":         size,
+		"completed_at": time.Now(),
+		"expires_at":   expiresAt,
+	}})
+}
+
+func (r *Repository) FailDataExport(ctx context.Context, id primitive.ObjectID, reason string) error {
+	return r.updateDataExport(ctx, id, bson.M{"$set": bson.M{
+		"status":       ExportFailed,
+		"error":        reason,
+		"completed_at": time.Now(),
+	}})
+}
+
+func (r *Repository) GetDataExport(ctx context.Context, id, userID primitive.ObjectID) (*DataExport, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var export DataExport
+	err := r.DB.Database("expensetracker").Collection("data_exports").FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&export)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("data export not found")
+		}
+		return nil, fmt.Errorf("error fetching data export: %w", err)
+	}
+	return &export, nil
+}
+
+func (r *Repository) ListDataExports(ctx context.Context, userID primitive.ObjectID) ([]DataExport, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("data_exports").Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data exports: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	exports := []DataExport{}
+	if err := cursor.All(ctx, &exports); err != nil {
+		return nil, fmt.Errorf("failed to decode data exports: %w", err)
+	}
+	return exports, nil
+}
+
+// DeleteExpiredDataExports removes export records past their expiry and
+// returns them so the caller can delete the archive files.
+func (r *Repository) DeleteExpiredDataExports(ctx context.Context, now time.Time) ([]DataExport, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	collection := r.DB.Database("expensetracker").Collection("data_exports")
+	filter := bson.M{"expires_at": bson.M{"$lte": now}}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired data exports: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	expired := []DataExport{}
+	if err := cursor.All(ctx, &expired); err != nil {
+		return nil, fmt.Errorf("failed to decode data exports: %w", err)
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(expired))
+	for i, e := range expired {
+		ids[i] = e.Id
+	}
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return nil, fmt.Errorf("failed to delete expired data exports: %w", err)
+	}
+	return expired, nil
+}
+
+// CollectUserData gathers every record tied to the user for a data export.
+// Secrets (password hash, TOTP secret, token hashes) are never included.
+func (r *Repository) CollectUserData(ctx context.Context, userID primitive.ObjectID) (*UserData, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	profile, err := r.GetUserProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := r.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := &UserData{Profile: profile, Preferences: prefs, Other: map[string][]interface{}{}}
+
+	db := r.DB.Database("expensetracker")
+	find := func(collection string, filter bson.M, out interface{}) error {
+		cursor, err := db.Collection(collection).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", collection, err)
+		}
+		defer cursor.Close(ctx)
+		return cursor.All(ctx, out)
+	}
+
+	if err := find("transactions", bson.M{"user_id": userID}, &data.Transactions); err != nil {
+		return nil, err
+	}
+	if err := find("api_tokens", bson.M{"user_id": userID}, &data.APITokens); err != nil {
+		return nil, err
+	}
+	if err := find("audit_events", bson.M{"$or": []bson.M{{"actor_id": userID}, {"target_id": userID}}}, &data.AuditEvents); err != nil {
+		return nil, err
+	}
+
+	// collections without a dedicated field above are exported as raw
+	// documents; idempotency keys only cache responses already exported
+	covered := map[string]bool{"transactions": true, "api_tokens": true, "data_exports": true, "preferences": true, "idempotency_keys": true}
+	for _, name := range userOwnedCollections {
+		if covered[name] {
+			continue
+		}
+		var docs []bson.M
+		if err := find(name, bson.M{"user_id": userID}, &docs); err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			data.Other[name] = append(data.Other[name], doc)
+		}
+	}
+	var ledgers []bson.M
+	if err := find("ledgers", bson.M{"members.user_id": userID}, &ledgers); err != nil {
+		return nil, err
+	}
+	for _, doc := range ledgers {
+		data.Other["ledgers"] = append(data.Other["ledgers"], doc)
+	}
+	return data, nil
+}
+
+func (r *Repository) ScheduleAccountDeletion(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	return r.updateUserFields(ctx, id, bson.M{"deletion_scheduled_at": at})
+}
+
+func (r *Repository) CancelAccountDeletion(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{"$unset": bson.M{"deletion_scheduled_at": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, bson.M{"_id": id, "deletion_scheduled_at": bson.M{"$exists": true}}, update)
+	if err != nil {
+		return fmt.Errorf("error cancelling account deletion: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no account deletion is scheduled")
+	}
+	return nil
+}
+
+func (r *Repository) ListDueAccountDeletions(ctx context.Context, now time.Time) ([]User, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"deletion_scheduled_at": bson.M{"$lte": now}}
+	cursor, err := r.DB.Database("expensetracker").Collection("users").Find(ctx, filter, options.Find().SetProjection(userListProjection))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due account deletions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %w", err)
+	}
+	return users, nil
+}
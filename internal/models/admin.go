@@ -0,0 +1,217 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SystemStats struct {
+	Users              int64   `json:"users"`
+	DisabledUsers      int64   `json:"disabled_users"`
+	AdminUsers         int64   `json:"admin_users"`
+	MFAUsers           int64   `json:"mfa_users"`
+	NewUsersLast30d    int64   `json:"new_users_last_30d"`
+	Transactions       int64   `json:"transactions"`
+	TotalIncome        float64 `json:"total_income"`
+	TotalExpense       float64 `json:"total_expense"`
+	ActiveAPITokens    int64   `json:"active_api_tokens"`
+	LockedLoginKeys    int64   `json:"locked_login_keys"`
+	TransactionsLast7d int64   `json:"transactions_last_7d"`
+}
+
+type AdminService interface {
+	ListUsers(ctx context.Context, search string, limit, offset int) ([]User, int64, error)
+	SetUserDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error
+	SetUserRole(ctx context.Context, id primitive.ObjectID, role string) error
+	RevokeUserSessions(ctx context.Context, id primitive.ObjectID) error
+	RequirePasswordReset(ctx context.Context, id primitive.ObjectID) error
+	GetSystemStats(ctx context.Context) (*SystemStats, error)
+}
+
+// userListProjection keeps secrets out of admin listings.
+var userListProjection = bson.M{
+	"password":            0,
+	"totp_secret":         0,
+	"pending_totp_secret": 0,
+	"recovery_codes":      0,
+	"password_reset_hash": 0,
+}
+
+func (r *Repository) ListUsers(ctx context.Context, search string, limit, offset int) ([]User, int64, error) {
+	if r.DB == nil {
+		return nil, 0, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{}
+	if search != "" {
+		pattern := regexp.QuoteMeta(search)
+		filter["$or"] = []bson.M{
+			{"name": bson.M{"$regex": pattern, "$options": "i"}},
+			{"email": bson.M{"$regex": pattern, "$options": "i"}},
+		}
+	}
+
+	collection := r.DB.Database("expensetracker").Collection("users")
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	opts := options.Find().SetProjection(userListProjection).SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode users: %w", err)
+	}
+	return users, total, nil
+}
+
+func (r *Repository) updateUserFields(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	set["updated_at"] = time.Now()
+	update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("error updating user: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no user found with id %s", id)
+	}
+	return nil
+}
+
+// SetUserDisabled blocks or restores access. Disabling also ends the user's
+// current sessions.
+func (r *Repository) SetUserDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	set := bson.M{"disabled": disabled}
+	if disabled {
+		set["sessions_revoked_at"] = time.Now()
+	}
+	return r.updateUserFields(ctx, id, set)
+}
+
+func (r *Repository) SetUserRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if role != RoleUser && role != RoleAdmin {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	return r.updateUserFields(ctx, id, bson.M{"role": role})
+}
+
+// RevokeUserSessions signs the user out everywhere: cookie sessions issued
+// before now stop working and all personal access tokens are deleted.
+func (r *Repository) RevokeUserSessions(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	if err := r.updateUserFields(ctx, id, bson.M{"sessions_revoked_at": time.Now()}); err != nil {
+		return err
+	}
+	if _, err := r.DB.Database("expensetracker").Collection("api_tokens").DeleteMany(ctx, bson.M{"user_id": id}); err != nil {
+		return fmt.Errorf("error revoking api tokens: %w", err)
+	}
+	return nil
+}
+
+// RequirePasswordReset refuses password logins until the user resets their
+// password, and ends their current sessions.
+func (r *Repository) RequirePasswordReset(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	return r.updateUserFields(ctx, id, bson.M{"password_reset_required": true, "sessions_revoked_at": time.Now()})
+}
+
+func (r *Repository) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	db := r.DB.Database("expensetracker")
+	users := db.Collection("users")
+	transactions := db.Collection("transactions")
+	now := time.Now()
+
+	var stats SystemStats
+	counts := []struct {
+		target *int64
+		count  func() (int64, error)
+	}{
+		{&stats.Users, func() (int64, error) { return users.CountDocuments(ctx, bson.M{}) }},
+		{&stats.DisabledUsers, func() (int64, error) { return users.CountDocuments(ctx, bson.M{"disabled": true}) }},
+		{&stats.AdminUsers, func() (int64, error) { return users.CountDocuments(ctx, bson.M{"role": RoleAdmin}) }},
+		{&stats.MFAUsers, func() (int64, error) { return users.CountDocuments(ctx, bson.M{"mfa_enabled": true}) }},
+		{&stats.NewUsersLast30d, func() (int64, error) {
+			return users.CountDocuments(ctx, bson.M{"created_at": bson.M{"$gte": now.AddDate(0, 0, -30)}})
+		}},
+		{&stats.Transactions, func() (int64, error) { return transactions.CountDocuments(ctx, bson.M{"deleted_at": live}) }},
+		{&stats.TransactionsLast7d, func() (int64, error) {
+			return transactions.CountDocuments(ctx, bson.M{"created_at": bson.M{"$gte": now.AddDate(0, 0, -7)}, "deleted_at": live})
+		}},
+		{&stats.ActiveAPITokens, func() (int64, error) {
+			return db.Collection("api_tokens").CountDocuments(ctx, bson.M{"$or": []bson.M{
+				{"expires_at": bson.M{"$exists": false}},
+				{"expires_at": bson.M{"$gt": now}},
+			}})
+		}},
+		{&stats.LockedLoginKeys, func() (int64, error) {
+			return db.Collection("login_throttles").CountDocuments(ctx, bson.M{"locked_until": bson.M{"$gt": now}})
+		}},
+	}
+	for _, c := range counts {
+		n, err := c.count()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute stats: %w", err)
+		}
+		*c.target = n
+	}
+
+	cursor, err := transactions.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"deleted_at": live}},
+		{"$group": bson.M{"_id": "$type", "total": bson.M{"$sum": "$amount"}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute stats: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Type  string  `bson:"_id"`
+		Total float64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+	for _, t := range totals {
+		switch t.Type {
+		case "income":
+			stats.TotalIncome = t.Total
+		case "expense":
+			stats.TotalExpense = t.Total
+		}
+	}
+	return &stats, nil
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Attachment is a file such as a receipt kept with a transaction. The file
+// itself lives in the storage backend under StorageKey.
+type Attachment struct {
+	Id            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId        primitive.ObjectID `bson:"user_id" json:"-"`
+	TransactionId primitive.ObjectID `bson:"transaction_id" json:"transaction_id"`
+	StorageKey    string             `bson:"storage_key" json:"-"`
+	Filename      string             `bson:"filename" json:"filename"`
+	ContentType   string             `bson:"content_type" json:"content_type"`
+	Size          int64              `bson:"size" json:"size"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// AttachmentKey is where the file of an attachment is stored.
+func AttachmentKey(userID, id primitive.ObjectID) string {
+	return "attachments/" + userID.Hex() + "/" + id.Hex()
+}
+
+type AttachmentService interface {
+	CreateAttachment(ctx context.Context, attachment *Attachment) error
+	GetAttachment(ctx context.Context, id, transactionID primitive.ObjectID) (*Attachment, error)
+	ListAttachments(ctx context.Context, transactionID primitive.ObjectID) ([]Attachment, error)
+	CountAttachments(ctx context.Context, transactionID primitive.ObjectID) (int64, error)
+	DeleteAttachment(ctx context.Context, id primitive.ObjectID) error
+	ListPersonalAttachments(ctx context.Context, userID primitive.ObjectID) ([]Attachment, error)
+	ListOrphanedAttachments(ctx context.Context, limit int) ([]Attachment, error)
+}
+
+func (r *Repository) CreateAttachment(ctx context.Context, attachment *Attachment) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	if attachment.Id.IsZero() {
+		attachment.Id = primitive.NewObjectID()
+	}
+	attachment.CreatedAt = time.Now()
+	if _, err := r.DB.Database("expensetracker").Collection("attachments").InsertOne(ctx, attachment); err != nil {
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetAttachment(ctx context.Context, id, transactionID primitive.ObjectID) (*Attachment, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var attachment Attachment
+	filter := bson.M{"_id": id, "transaction_id": transactionID}
+	if err := r.DB.Database("expensetracker").Collection("attachments").FindOne(ctx, filter).Decode(&attachment); err != nil {
+		return nil, fmt.Errorf("error fetching attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+func (r *Repository) ListAttachments(ctx context.Context, transactionID primitive.ObjectID) ([]Attachment, error) {
+	return r.findAttachments(ctx, bson.M{"transaction_id": transactionID})
+}
+
+// ListPersonalAttachments returns the attachments the user added, except
+// those on ledger transactions, which stay with the ledger.
+func (r *Repository) ListPersonalAttachments(ctx context.Context, userID primitive.ObjectID) ([]Attachment, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter, err := personalAttachments(ctx, r.DB.Database("expensetracker"), userID)
+	if err != nil {
+		return nil, err
+	}
+	return r.findAttachments(ctx, filter)
+}
+
+// personalAttachments filters the attachments the user added to anything but
+// a ledger transaction.
+func personalAttachments(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) (bson.M, error) {
+	attached, err := db.Collection("attachments").Distinct(ctx, "transaction_id", bson.M{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	if len(attached) == 0 {
+		return bson.M{"user_id": userID}, nil
+	}
+	shared, err := db.Collection("transactions").Distinct(ctx, "_id", bson.M{"_id": bson.M{"$in": attached}, "ledger_id": bson.M{"$ne": nil}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ledger transactions: %w", err)
+	}
+	return bson.M{"user_id": userID, "transaction_id": bson.M{"$nin": shared}}, nil
+}
+
+func (r *Repository) findAttachments(ctx context.Context, filter bson.M) ([]Attachment, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("attachments").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	attachments := []Attachment{}
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, fmt.Errorf("failed to decode attachments: %w", err)
+	}
+	return attachments, nil
+}
+
+func (r *Repository) CountAttachments(ctx context.Context, transactionID primitive.ObjectID) (int64, error) {
+	if r.DB == nil {
+		return 0, fmt.Errorf("database connection is not initialized")
+	}
+
+	n, err := r.DB.Database("expensetracker").Collection("attachments").CountDocuments(ctx, bson.M{"transaction_id": transactionID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count attachments: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteAttachment removes the record of an attachment; the caller deletes
+// the stored file first.
+func (r *Repository) DeleteAttachment(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	if _, err := r.DB.Database("expensetracker").Collection("attachments").DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
+
+// ListOrphanedAttachments finds attachments whose transaction was
+// permanently deleted, so their files can be cleaned up.
+func (r *Repository) ListOrphanedAttachments(ctx context.Context, limit int) ([]Attachment, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	pipeline := []bson.M{
+		{"$lookup": bson.M{"from": "transactions", "localField": "transaction_id", "foreignField": "_id", "as": "transaction"}},
+		{"$match": bson.M{"transaction": bson.M{"$size": 0}}},
+		{"$project": bson.M{"transaction": 0}},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+	cursor, err := r.DB.Database("expensetracker").Collection("attachments").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find orphaned attachments: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	attachments := []Attachment{}
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return nil, fmt.Errorf("failed to decode attachments: %w", err)
+	}
+	return attachments, nil
+}
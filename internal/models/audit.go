@@ -0,0 +1,111 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditLoginSucceeded       = "auth.login_succeeded"
+	AuditLoginFailed          = "auth.login_failed"
+	AuditLogout               = "auth.logout"
+	AuditAccountUnlocked      = "auth.account_unlocked"
+	AuditPasswordChanged      = "auth.password_changed"
+	AuditPasswordReset        = "auth.password_reset"
+	AuditMFAEnabled           = "auth.mfa_enabled"
+	AuditMFADisabled          = "auth.mfa_disabled"
+	AuditRecoveryCodesReset   = "auth.recovery_codes_regenerated"
+	AuditTokenCreated         = "token.created"
+	AuditTokenRevoked         = "token.revoked"
+	AuditProfileUpdated       = "user.profile_updated"
+	AuditTransactionDeleted   = "transaction.deleted"
+	AuditTransactionRestored  = "transaction.restored"
+	AuditTransactionPurged    = "transaction.purged"
+	AuditAttachmentDeleted    = "transaction.attachment_deleted"
+	AuditLedgerInvitationSent = "ledger.invitation_sent"
+	AuditLedgerMemberJoined   = "ledger.member_joined"
+	AuditLedgerMemberRemoved  = "ledger.member_removed"
+	AuditLedgerRoleChanged    = "ledger.role_changed"
+	AuditAdminUserDisabled    = "admin.user_disabled"
+	AuditAdminUserEnabled     = "admin.user_enabled"
+	AuditAdminRoleChanged     = "admin.role_changed"
+	AuditAdminPasswordForced  = "admin.password_reset_forced"
+	AuditAdminSessionsRevoke  = "admin.sessions_revoked"
+	AuditAdminUsersListed     = "admin.users_listed"
+	AuditAdminUserViewed      = "admin.user_viewed"
+	AuditAdminStatsViewed     = "admin.stats_viewed"
+	AuditDataExportRequested  = "account.export_requested"
+	AuditDataExportDownload   = "account.export_downloaded"
+	AuditDeletionScheduled    = "account.deletion_scheduled"
+	AuditDeletionCancelled    = "account.deletion_cancelled"
+)
+
+// AuditEvent is an append-only record of a security relevant action. ActorId
+// is who acted (empty for failed logins to unknown accounts), TargetId the
+// account affected when it differs or the actor is unknown.
+type AuditEvent struct {
+	Id        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Action    string                 `bson:"action" json:"action"`
+	ActorId   primitive.ObjectID     `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	TargetId  primitive.ObjectID     `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	IP        string                 `bson:"ip" json:"ip"`
+	UserAgent string                 `bson:"user_agent" json:"user_agent"`
+	RequestId string                 `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Details   map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// AuditService deliberately has no update or delete: events are only ever
+// appended.
+type AuditService interface {
+	RecordAuditEvent(ctx context.Context, event *AuditEvent) error
+	ListUserAuditEvents(ctx context.Context, userID primitive.ObjectID, limit, offset int) ([]AuditEvent, error)
+}
+
+func (r *Repository) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	event.Id = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+
+	if _, err := r.DB.Database("expensetracker").Collection("audit_events").InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// ListUserAuditEvents returns the events a user performed or that affected
+// their account, newest first.
+func (r *Repository) ListUserAuditEvents(ctx context.Context, userID primitive.ObjectID, limit, offset int) ([]AuditEvent, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"$or": []bson.M{{"actor_id": userID}, {"target_id": userID}}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+
+	cursor, err := r.DB.Database("expensetracker").Collection("audit_events").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode audit events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	RevisionCreated  = "created"
+	RevisionUpdated  = "updated"
+	RevisionDeleted  = "deleted"
+	RevisionRestored = "restored"
+	RevisionReverted = "reverted"
+)
+
+// FieldChange is the before and after value of one changed field.
+type FieldChange struct {
+	From interface{} `bson:"from" json:"from"`
+	To   interface{} `bson:"to" json:"to"`
+}
+
+// TransactionRevision records one change to a transaction: who made it, the
+// fields it changed and the full transaction as it was afterwards, so any
+// version can be inspected or reverted to.
+type TransactionRevision struct {
+	Id            primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	TransactionId primitive.ObjectID     `bson:"transaction_id" json:"transaction_id"`
+	UserId        primitive.ObjectID     `bson:"user_id" json:"-"`
+	Version       int64                  `bson:"version" json:"version"`
+	Action        string                 `bson:"action" json:"action"`
+	ActorId       primitive.ObjectID     `bson:"actor_id" json:"actor_id"`
+	Changes       map[string]FieldChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	Snapshot      Transaction            `bson:"snapshot" json:"snapshot"`
+	CreatedAt     time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// untrackedFields change on every write and would only add noise to diffs.
+var untrackedFields = map[string]bool{"_id": true, "user_id": true, "version": true, "created_at": true, "updated_at": true}
+
+// DiffTransactions returns the fields that differ between before and after,
+// keyed by their stored name. A nil before means the transaction is new.
+func DiffTransactions(before, after *Transaction) (map[string]FieldChange, error) {
+	from, err := transactionFields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := transactionFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]FieldChange{}
+	for key, value := range to {
+		if !untrackedFields[key] && !reflect.DeepEqual(from[key], value) {
+			changes[key] = FieldChange{From: from[key], To: value}
+		}
+	}
+	for key, value := range from {
+		if _, ok := to[key]; !ok && !untrackedFields[key] {
+			changes[key] = FieldChange{From: value, To: nil}
+		}
+	}
+	return changes, nil
+}
+
+func transactionFields(tx *Transaction) (bson.M, error) {
+	if tx == nil {
+		return bson.M{}, nil
+	}
+	raw, err := bson.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode transaction: %w", err)
+	}
+	fields := bson.M{}
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction: %w", err)
+	}
+	return fields, nil
+}
+
+type HistoryService interface {
+	RecordTransactionRevision(ctx context.Context, action string, actorID primitive.ObjectID, before, after *Transaction) error
+	ListTransactionRevisions(ctx context.Context, transactionID primitive.ObjectID) ([]TransactionRevision, error)
+	GetTransactionRevision(ctx context.Context, transactionID primitive.ObjectID, version int64) (*TransactionRevision, error)
+}
+
+// RecordTransactionRevision appends a history entry for a write that turned
+// before into after. For deletions after is the trashed transaction.
+func (r *Repository) RecordTransactionRevision(ctx context.Context, action string, actorID primitive.ObjectID, before, after *Transaction) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	changes, err := DiffTransactions(before, after)
+	if err != nil {
+		return err
+	}
+	revision := &TransactionRevision{
+		Id:            primitive.NewObjectID(),
+		TransactionId: after.Id,
+		UserId:        after.UserId,
+		Version:       after.Version,
+		Action:        action,
+		ActorId:       actorID,
+		Changes:       changes,
+		Snapshot:      *after,
+		CreatedAt:     time.Now(),
+	}
+	if _, err := r.DB.Database("expensetracker").Collection("transaction_history").InsertOne(ctx, revision); err != nil {
+		return fmt.Errorf("failed to record transaction history: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) deleteTransactionHistory(ctx context.Context, transactionIDs []primitive.ObjectID) error {
+	filter := bson.M{"transaction_id": bson.M{"$in": transactionIDs}}
+	if _, err := r.DB.Database("expensetracker").Collection("transaction_history").DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete transaction history: %w", err)
+	}
+	return nil
+}
+
+// ListTransactionRevisions returns the history of a transaction, newest first.
+func (r *Repository) ListTransactionRevisions(ctx context.Context, transactionID primitive.ObjectID) ([]TransactionRevision, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: -1}, {Key: "created_at", Value: -1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("transaction_history").Find(ctx, bson.M{"transaction_id": transactionID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transaction history: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []TransactionRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, fmt.Errorf("failed to decode transaction history: %w", err)
+	}
+	return revisions, nil
+}
+
+func (r *Repository) GetTransactionRevision(ctx context.Context, transactionID primitive.ObjectID, version int64) (*TransactionRevision, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var revision TransactionRevision
+	filter := bson.M{"transaction_id": transactionID, "version": version}
+	err := r.DB.Database("expensetracker").Collection("transaction_history").FindOne(ctx, filter).Decode(&revision)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("no history for version %d", version)
+		}
+		return nil, fmt.Errorf("failed to fetch transaction history: %w", err)
+	}
+	return &revision, nil
+}
+
+// TransactionChange is one transaction before and after a bulk write, so the
+// caller can record its history like for a single update.
+type TransactionChange struct {
+	Before *Transaction
+	After  *Transaction
+}
+
+// updateTransactions applies update to every transaction matching filter and
+// returns the ones it changed. Run it in a transaction so nothing slips in
+// between reading them and writing.
+func updateTransactions(ctx context.Context, db *mongo.Database, filter bson.M, update interface{}) ([]TransactionChange, error) {
+	coll := db.Collection("transactions")
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find transactions: %w", err)
+	}
+	var before []Transaction
+	if err := cursor.All(ctx, &before); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %w", err)
+	}
+	if len(before) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(before))
+	for i := range before {
+		ids[i] = before[i].Id
+	}
+	if _, err := coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+		return nil, fmt.Errorf("failed to update transactions: %w", err)
+	}
+
+	cursor, err = coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find transactions: %w", err)
+	}
+	var after []Transaction
+	if err := cursor.All(ctx, &after); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %w", err)
+	}
+	updated := make(map[primitive.ObjectID]*Transaction, len(after))
+	for i := range after {
+		updated[after[i].Id] = &after[i]
+	}
+	changes := make([]TransactionChange, 0, len(before))
+	for i := range before {
+		if tx, ok := updated[before[i].Id]; ok && tx.Version != before[i].Version {
+			changes = append(changes, TransactionChange{Before: &before[i], After: tx})
+		}
+	}
+	return changes, nil
+}
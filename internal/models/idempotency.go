@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// ErrIdempotencyReleased is returned when the key was released between a
+// failed claim and reading the record back; claiming again will succeed.
+var ErrIdempotencyReleased = errors.New("idempotency key was released concurrently")
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key so that retries of it can be answered without repeating
+// the side effects. Records are unique per user and key.
+type IdempotencyRecord struct {
+	Id          string             `bson:"_id" json:"-"`
+	UserId      primitive.ObjectID `bson:"user_id" json:"-"`
+	Key         string             `bson:"key" json:"key"`
+	RequestHash string             `bson:"request_hash" json:"-"`
+	Status      string             `bson:"status" json:"status"`
+	StatusCode  int                `bson:"status_code,omitempty" json:"-"`
+	ContentType string             `bson:"content_type,omitempty" json:"-"`
+	ETag        string             `bson:"etag,omitempty" json:"-"`
+	Body        []byte             `bson:"body,omitempty" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	// an in-progress record whose owner died is taken over after this
+	LockedUntil time.Time `bson:"locked_until" json:"-"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+func idempotencyID(userID primitive.ObjectID, key string) string {
+	return userID.Hex() + ":" + key
+}
+
+type IdempotencyService interface {
+	ClaimIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key, requestHash string, lock, retention time.Duration) (*IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string, statusCode int, contentType, etag string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+// ClaimIdempotencyKey tries to take ownership of key for a new request. It
+// returns true when the caller now owns the key and must process the request;
+// otherwise the existing record is returned, which is either finished or still
+// being processed by a concurrent request.
+func (r *Repository) ClaimIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key, requestHash string, lock, retention time.Duration) (*IdempotencyRecord, bool, error) {
+	if r.DB == nil {
+		return nil, false, fmt.Errorf("database connection is not initialized")
+	}
+
+	now := time.Now()
+	collection := r.DB.Database("expensetracker").Collection("idempotency_keys")
+	record := &IdempotencyRecord{
+		Id:          idempotencyID(userID, key),
+		UserId:      userID,
+		Key:         key,
+		RequestHash: requestHash,
+		Status:      IdempotencyInProgress,
+		CreatedAt:   now,
+		LockedUntil: now.Add(lock),
+		ExpiresAt:   now.Add(retention),
+	}
+
+	// the unique _id makes the insert the lock; expired records and abandoned
+	// in-progress ones are replaced in place
+	_, err := collection.InsertOne(ctx, record)
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, false, fmt.Errorf("error claiming idempotency key: %w", err)
+	}
+
+	takeover := bson.M{"_id": record.Id, "$or": []bson.M{
+		{"expires_at": bson.M{"$lte": now}},
+		{"status": IdempotencyInProgress, "locked_until": bson.M{"$lte": now}, "request_hash": requestHash},
+	}}
+	result, err := collection.ReplaceOne(ctx, takeover, record)
+	if err != nil {
+		return nil, false, fmt.Errorf("error claiming idempotency key: %w", err)
+	}
+	if result.MatchedCount == 1 {
+		return record, true, nil
+	}
+
+	var existing IdempotencyRecord
+	if err := collection.FindOne(ctx, bson.M{"_id": record.Id}).Decode(&existing); err != nil {
+		if err == mongo.ErrNoDocuments {
+			// released between our insert and read, let the caller retry
+			return nil, false, ErrIdempotencyReleased
+		}
+		return nil, false, fmt.Errorf("error fetching idempotency key: %w", err)
+	}
+	return &existing, false, nil
+}
+
+func (r *Repository) CompleteIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string, statusCode int, contentType, etag string, body []byte) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{
+		"status":       IdempotencyCompleted,
+		"status_code":  statusCode,
+		"content_type": contentType,
+		"etag":         etag,
+		"body":         body,
+	}}
+	if _, err := r.DB.Database("expensetracker").Collection("idempotency_keys").UpdateOne(ctx, bson.M{"_id": idempotencyID(userID, key)}, update); err != nil {
+		return fmt.Errorf("error storing idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets an in-progress key, e.g. after a server
+// error, so the client can retry it.
+func (r *Repository) ReleaseIdempotencyKey(ctx context.Context, userID primitive.ObjectID, key string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": idempotencyID(userID, key), "status": IdempotencyInProgress}
+	if _, err := r.DB.Database("expensetracker").Collection("idempotency_keys").DeleteOne(ctx, filter); err != nil {
+		return fmt.Errorf("error releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	if r.DB == nil {
+		return 0, fmt.Errorf("database connection is not initialized")
+	}
+
+	result, err := r.DB.Database("expensetracker").Collection("idempotency_keys").DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	return result.DeletedCount, nil
+}
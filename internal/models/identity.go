@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type IdentityService interface {
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	LinkIdentity(ctx context.Context, id primitive.ObjectID, identity ExternalIdentity) error
+	RegisterExternalUser(ctx context.Context, user *User, identity ExternalIdentity) (*User, error)
+}
+
+// GetUserByIdentity returns nil without an error when no user is linked.
+func (r *Repository) GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	user := User{}
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}}}
+	err := r.DB.Database("expensetracker").Collection("users").FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching user: %w", err)
+	}
+
+	user.Password = ""
+	return &user, nil
+}
+
+func (r *Repository) LinkIdentity(ctx context.Context, id primitive.ObjectID, identity ExternalIdentity) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	identity.LinkedAt = time.Now()
+	// the filter guards against linking the same external account twice
+	filter := bson.M{
+		"_id":        id,
+		"identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"issuer": identity.Issuer, "subject": identity.Subject}}},
+	}
+	update := bson.M{"$push": bson.M{"identities": identity}, "$set": bson.M{"updated_at": time.Now()}}
+	if _, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("error linking identity: %w", err)
+	}
+	return nil
+}
+
+// RegisterExternalUser creates an account for someone signing in through an
+// identity provider. It has no local password until the user sets one.
+func (r *Repository) RegisterExternalUser(ctx context.Context, user *User, identity ExternalIdentity) (*User, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	exists, err := r.checkUserExists(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("user with email %s already exists", user.Email)
+	}
+
+	if err := validate.Struct(user); err != nil {
+		return nil, fmt.Errorf("validation error: %w", err)
+	}
+
+	now := time.Now()
+	identity.LinkedAt = now
+	user.Id = primitive.NewObjectID()
+	user.Role = RoleUser
+	user.Version = 1
+	user.Password = ""
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.Identities = []ExternalIdentity{identity}
+
+	if _, err := r.DB.Database("expensetracker").Collection("users").InsertOne(ctx, user); err != nil {
+		return nil, fmt.Errorf("error inserting user: %w", err)
+	}
+	return user, nil
+}
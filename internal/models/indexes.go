@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the queries rely on. Creating an index
+// that already exists is a no-op, so it runs on every start.
+func (r *Repository) EnsureIndexes(ctx context.Context) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	db := r.DB.Database("expensetracker")
+	indexes := map[string][]mongo.IndexModel{
+		"transactions": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}}},
+			{Keys: bson.D{{Key: "payee_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			// balances and running balances
+			{Keys: bson.D{{Key: "account_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+			{Keys: bson.D{{Key: "ledger_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		"ledgers": {
+			{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		},
+		"ledger_invitations": {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}},
+			{Keys: bson.D{{Key: "ledger_id", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"attachments": {
+			{Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+		"category_models": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"rules": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "priority", Value: 1}}},
+		},
+		"payees": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		"accounts": {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		"transaction_history": {
+			{Keys: bson.D{{Key: "transaction_id", Value: 1}, {Key: "version", Value: -1}}},
+		},
+	}
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("failed to create %s indexes: %w", collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A ledger is shared by a household or group. Transactions with a LedgerId
+// belong to the ledger rather than to the member who entered them: members
+// see and edit them according to their role, and they stay when their
+// author leaves.
+const (
+	LedgerOwner  = "owner"  // manages members, invitations and the ledger itself
+	LedgerEditor = "editor" // adds, edits and deletes transactions
+	LedgerViewer = "viewer" // reads transactions and reports
+)
+
+var ledgerRoleRank = map[string]int{LedgerViewer: 1, LedgerEditor: 2, LedgerOwner: 3}
+
+// LedgerRoleAllows reports whether role grants at least the access of need.
+func LedgerRoleAllows(role, need string) bool {
+	return ledgerRoleRank[role] > 0 && ledgerRoleRank[role] >= ledgerRoleRank[need]
+}
+
+// InvitationTTL is how long a ledger invitation can be accepted.
+const InvitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrLedgerNotEmpty          = errors.New("ledger still has transactions")
+	ErrLastLedgerOwner         = errors.New("a ledger needs at least one other owner")
+	ErrAlreadyLedgerMember     = errors.New("already a member of this ledger")
+	ErrInvitationInvalid       = errors.New("invalid or expired invitation")
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+)
+
+type Ledger struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name" validate:"required,max=100"`
+	Members   []LedgerMember     `bson:"members" json:"members"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type LedgerMember struct {
+	UserId   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Role     string             `bson:"role" json:"role"`
+	JoinedAt time.Time          `bson:"joined_at" json:"joined_at"`
+}
+
+// RoleOf returns the role of userID in l, or "" for non-members.
+func (l *Ledger) RoleOf(userID primitive.ObjectID) string {
+	for _, m := range l.Members {
+		if m.UserId == userID {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// LedgerInvitation lets whoever holds the emailed token join the ledger,
+// provided they are signed in with the invited email address. Only a hash of
+// the token is stored.
+type LedgerInvitation struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	LedgerId  primitive.ObjectID `bson:"ledger_id" json:"ledger_id"`
+	Email     string             `bson:"email" json:"email"`
+	Role      string             `bson:"role" json:"role"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	InvitedBy primitive.ObjectID `bson:"invited_by" json:"invited_by"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// Scope selects the transactions a query covers: a user's personal
+// transactions, or every transaction of a shared ledger whoever entered it.
+type Scope struct {
+	UserId   primitive.ObjectID
+	LedgerId *primitive.ObjectID
+}
+
+func PersonalScope(userID primitive.ObjectID) Scope {
+	return Scope{UserId: userID}
+}
+
+func LedgerScope(ledgerID primitive.ObjectID) Scope {
+	return Scope{LedgerId: &ledgerID}
+}
+
+func (s Scope) filter() bson.M {
+	if s.LedgerId != nil {
+		return bson.M{"ledger_id": *s.LedgerId}
+	}
+	// null also matches transactions stored before ledgers existed
+	return bson.M{"user_id": s.UserId, "ledger_id": nil}
+}
+
+type LedgerService interface {
+	CreateLedger(ctx context.Context, ledger *Ledger, ownerID primitive.ObjectID) error
+	GetLedger(ctx context.Context, id primitive.ObjectID) (*Ledger, error)
+	ListLedgers(ctx context.Context, userID primitive.ObjectID) ([]Ledger, error)
+	RenameLedger(ctx context.Context, id primitive.ObjectID, name string) (*Ledger, error)
+	DeleteLedger(ctx context.Context, id primitive.ObjectID) error
+	SetLedgerMemberRole(ctx context.Context, id, userID primitive.ObjectID, role string) error
+	RemoveLedgerMember(ctx context.Context, id, userID primitive.ObjectID) error
+
+	CreateLedgerInvitation(ctx context.Context, invitation *LedgerInvitation) error
+	ListLedgerInvitations(ctx context.Context, ledgerID primitive.ObjectID) ([]LedgerInvitation, error)
+	DeleteLedgerInvitation(ctx context.Context, id, ledgerID primitive.ObjectID) error
+	AcceptLedgerInvitation(ctx context.Context, tokenHash string, user *User) (*Ledger, error)
+}
+
+func (r *Repository) CreateLedger(ctx context.Context, ledger *Ledger, ownerID primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if err := validate.Struct(ledger); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	now := time.Now()
+	ledger.Id = primitive.NewObjectID()
+	ledger.Members = []LedgerMember{{UserId: ownerID, Role: LedgerOwner, JoinedAt: now}}
+	ledger.CreatedAt = now
+	ledger.UpdatedAt = now
+	if _, err := r.DB.Database("expensetracker").Collection("ledgers").InsertOne(ctx, ledger); err != nil {
+		return fmt.Errorf("failed to create ledger: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetLedger(ctx context.Context, id primitive.ObjectID) (*Ledger, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var ledger Ledger
+	if err := r.DB.Database("expensetracker").Collection("ledgers").FindOne(ctx, bson.M{"_id": id}).Decode(&ledger); err != nil {
+		return nil, fmt.Errorf("error fetching ledger: %w", err)
+	}
+	return &ledger, nil
+}
+
+// ListLedgers returns the ledgers userID is a member of, by name.
+func (r *Repository) ListLedgers(ctx context.Context, userID primitive.ObjectID) ([]Ledger, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("ledgers").Find(ctx, bson.M{"members.user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ledgers: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	ledgers := []Ledger{}
+	if err := cursor.All(ctx, &ledgers); err != nil {
+		return nil, fmt.Errorf("failed to decode ledgers: %w", err)
+	}
+	return ledgers, nil
+}
+
+func (r *Repository) RenameLedger(ctx context.Context, id primitive.ObjectID, name string) (*Ledger, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"name": name, "updated_at": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var ledger Ledger
+	if err := r.DB.Database("expensetracker").Collection("ledgers").FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&ledger); err != nil {
+		return nil, fmt.Errorf("failed to rename ledger: %w", err)
+	}
+	return &ledger, nil
+}
+
+// DeleteLedger deletes an empty ledger and its pending invitations. Ledgers
+// with transactions, including trashed ones, return ErrLedgerNotEmpty.
+func (r *Repository) DeleteLedger(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	db := r.DB.Database("expensetracker")
+	return r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		n, err := db.Collection("transactions").CountDocuments(sc, bson.M{"ledger_id": id}, options.Count().SetLimit(1))
+		if err != nil {
+			return fmt.Errorf("failed to count ledger transactions: %w", err)
+		}
+		if n > 0 {
+			return ErrLedgerNotEmpty
+		}
+		if _, err := db.Collection("ledger_invitations").DeleteMany(sc, bson.M{"ledger_id": id}); err != nil {
+			return fmt.Errorf("failed to delete ledger invitations: %w", err)
+		}
+		if _, err := db.Collection("ledgers").DeleteOne(sc, bson.M{"_id": id}); err != nil {
+			return fmt.Errorf("failed to delete ledger: %w", err)
+		}
+		return nil
+	})
+}
+
+// anotherOwner matches the ledger only while an owner other than userID
+// remains, so no update guarded by it can leave the ledger without one.
+func anotherOwner(id, userID primitive.ObjectID) bson.M {
+	return bson.M{
+		"_id":     id,
+		"members": bson.M{"$elemMatch": bson.M{"user_id": bson.M{"$ne": userID}, "role": LedgerOwner}},
+	}
+}
+
+// SetLedgerMemberRole changes the role of a member. Demoting the last owner
+// returns ErrLastLedgerOwner.
+func (r *Repository) SetLedgerMemberRole(ctx context.Context, id, userID primitive.ObjectID, role string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "members.user_id": userID}
+	if role != LedgerOwner {
+		filter = anotherOwner(id, userID)
+		filter["members.user_id"] = userID
+	}
+	update := bson.M{"$set": bson.M{"members.$[member].role": role, "updated_at": time.Now()}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"member.user_id": userID}}})
+	result, err := r.DB.Database("expensetracker").Collection("ledgers").UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return fmt.Errorf("failed to change member role: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrLastLedgerOwner
+	}
+	return nil
+}
+
+// RemoveLedgerMember removes a member, who keeps no access to the ledger's
+// transactions, including the ones they entered. The last owner can't leave;
+// they delete the ledger or hand it over first.
+func (r *Repository) RemoveLedgerMember(ctx context.Context, id, userID primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{"$pull": bson.M{"members": bson.M{"user_id": userID}}, "$set": bson.M{"updated_at": time.Now()}}
+	result, err := r.DB.Database("expensetracker").Collection("ledgers").UpdateOne(ctx, anotherOwner(id, userID), update)
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrLastLedgerOwner
+	}
+	return nil
+}
+
+// CreateLedgerInvitation stores an invitation, replacing a pending one for
+// the same email so inviting again sends a fresh link.
+func (r *Repository) CreateLedgerInvitation(ctx context.Context, invitation *LedgerInvitation) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	invitation.CreatedAt = time.Now()
+	invitation.ExpiresAt = invitation.CreatedAt.Add(InvitationTTL)
+	filter := bson.M{"ledger_id": invitation.LedgerId, "email": invitation.Email}
+	update := bson.M{"$set": bson.M{
+		"role":       invitation.Role,
+		"token_hash": invitation.TokenHash,
+		"invited_by": invitation.InvitedBy,
+		"created_at": invitation.CreatedAt,
+		"expires_at": invitation.ExpiresAt,
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := r.DB.Database("expensetracker").Collection("ledger_invitations").FindOneAndUpdate(ctx, filter, update, opts).Decode(invitation); err != nil {
+		return fmt.Errorf("failed to save invitation: %w", err)
+	}
+	return nil
+}
+
+// ListLedgerInvitations returns the invitations of a ledger that can still be
+// accepted.
+func (r *Repository) ListLedgerInvitations(ctx context.Context, ledgerID primitive.ObjectID) ([]LedgerInvitation, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"ledger_id": ledgerID, "expires_at": bson.M{"$gt": time.Now()}}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("ledger_invitations").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	invitations := []LedgerInvitation{}
+	if err := cursor.All(ctx, &invitations); err != nil {
+		return nil, fmt.Errorf("failed to decode invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+func (r *Repository) DeleteLedgerInvitation(ctx context.Context, id, ledgerID primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	result, err := r.DB.Database("expensetracker").Collection("ledger_invitations").DeleteOne(ctx, bson.M{"_id": id, "ledger_id": ledgerID})
+	if err != nil {
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AcceptLedgerInvitation adds user to the ledger with the invited role and
+// consumes the invitation in the same transaction.
+func (r *Repository) AcceptLedgerInvitation(ctx context.Context, tokenHash string, user *User) (*Ledger, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	db := r.DB.Database("expensetracker")
+	var ledger Ledger
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		var invitation LedgerInvitation
+		filter := bson.M{"token_hash": tokenHash, "expires_at": bson.M{"$gt": time.Now()}}
+		if err := db.Collection("ledger_invitations").FindOne(sc, filter).Decode(&invitation); err != nil {
+			return ErrInvitationInvalid
+		}
+		if !strings.EqualFold(invitation.Email, strings.TrimSpace(user.Email)) {
+			return ErrInvitationEmailMismatch
+		}
+
+		member := LedgerMember{UserId: user.Id, Role: invitation.Role, JoinedAt: time.Now()}
+		update := bson.M{"$push": bson.M{"members": member}, "$set": bson.M{"updated_at": member.JoinedAt}}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := db.Collection("ledgers").FindOneAndUpdate(sc, bson.M{"_id": invitation.LedgerId, "members.user_id": bson.M{"$ne": user.Id}}, update, opts).Decode(&ledger)
+		if err == mongo.ErrNoDocuments {
+			if _, err := r.GetLedger(sc, invitation.LedgerId); err != nil {
+				return ErrInvitationInvalid
+			}
+			return ErrAlreadyLedgerMember
+		}
+		if err != nil {
+			return fmt.Errorf("failed to join ledger: %w", err)
+		}
+		if _, err := db.Collection("ledger_invitations").DeleteOne(sc, bson.M{"_id": invitation.Id}); err != nil {
+			return fmt.Errorf("failed to consume invitation: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledger, nil
+}
+
+// leaveLedgers removes an erased user from every ledger. The transactions
+// they entered stay, still carrying their id as the author, so the other
+// members' balances and history don't change. A ledger left without an owner
+// is handed to its longest-standing member; one left without members is
+// deleted with its transactions, which nobody could reach any more.
+func (r *Repository) leaveLedgers(sc mongo.SessionContext, userID primitive.ObjectID) error {
+	db := r.DB.Database("expensetracker")
+	if _, err := db.Collection("ledger_invitations").DeleteMany(sc, bson.M{"invited_by": userID}); err != nil {
+		return fmt.Errorf("error deleting ledger invitations: %w", err)
+	}
+
+	cursor, err := db.Collection("ledgers").Find(sc, bson.M{"members.user_id": userID})
+	if err != nil {
+		return fmt.Errorf("error finding ledgers: %w", err)
+	}
+	var ledgers []Ledger
+	if err := cursor.All(sc, &ledgers); err != nil {
+		return fmt.Errorf("error decoding ledgers: %w", err)
+	}
+
+	for _, ledger := range ledgers {
+		members := make([]LedgerMember, 0, len(ledger.Members))
+		hasOwner := false
+		for _, m := range ledger.Members {
+			if m.UserId != userID {
+				members = append(members, m)
+				hasOwner = hasOwner || m.Role == LedgerOwner
+			}
+		}
+
+		if len(members) == 0 {
+			if _, err := db.Collection("transactions").DeleteMany(sc, bson.M{"ledger_id": ledger.Id}); err != nil {
+				return fmt.Errorf("error deleting ledger transactions: %w", err)
+			}
+			// their attachments are orphaned now and cleaned up with the files
+			if _, err := db.Collection("transaction_history").DeleteMany(sc, bson.M{"snapshot.ledger_id": ledger.Id}); err != nil {
+				return fmt.Errorf("error deleting ledger transaction history: %w", err)
+			}
+			if _, err := db.Collection("ledger_invitations").DeleteMany(sc, bson.M{"ledger_id": ledger.Id}); err != nil {
+				return fmt.Errorf("error deleting ledger invitations: %w", err)
+			}
+			if _, err := db.Collection("ledgers").DeleteOne(sc, bson.M{"_id": ledger.Id}); err != nil {
+				return fmt.Errorf("error deleting ledger: %w", err)
+			}
+			continue
+		}
+
+		if !hasOwner {
+			sort.SliceStable(members, func(i, j int) bool { return members[i].JoinedAt.Before(members[j].JoinedAt) })
+			members[0].Role = LedgerOwner
+		}
+		update := bson.M{"$set": bson.M{"members": members, "updated_at": time.Now()}}
+		if _, err := db.Collection("ledgers").UpdateOne(sc, bson.M{"_id": ledger.Id}, update); err != nil {
+			return fmt.Errorf("error leaving ledger: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MFAService interface {
+	BeginTOTPEnrollment(ctx context.Context, id primitive.ObjectID, secret string) error
+	ConfirmTOTPEnrollment(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error
+	DisableTOTP(ctx context.Context, id primitive.ObjectID) error
+	ReplaceRecoveryCodes(ctx context.Context, id primitive.ObjectID, recoveryCodes []string) error
+	ConsumeRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error)
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
+}
+
+// BeginTOTPEnrollment stores a secret that only becomes active once the user
+// proves their authenticator produces valid codes for it.
+func (r *Repository) BeginTOTPEnrollment(ctx context.Context, id primitive.ObjectID, secret string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "mfa_enabled": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"pending_totp_secret": secret, "updated_at": time.Now()}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error starting totp enrollment: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+	return nil
+}
+
+// ConfirmTOTPEnrollment activates the pending secret and stores the hashed
+// recovery codes. step is the time step of the code used to confirm so it
+// cannot be replayed at login.
+func (r *Repository) ConfirmTOTPEnrollment(ctx context.Context, id primitive.ObjectID, step int64, recoveryCodes []string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	user, err := r.GetUserProfile(ctx, id)
+	if err != nil {
+		return err
+	}
+	if user.PendingTOTPSecret == "" {
+		return fmt.Errorf("no totp enrollment in progress")
+	}
+
+	filter := bson.M{"_id": id, "pending_totp_secret": user.PendingTOTPSecret}
+	update := bson.M{
+		"$set": bson.M{
+			"mfa_enabled":    true,
+			"totp_secret":    user.PendingTOTPSecret,
+			"totp_last_step": step,
+			"recovery_codes": recoveryCodes,
+			"updated_at":     time.Now(),
+		},
+		"$unset": bson.M{"pending_totp_secret": ""},
+	}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error confirming totp enrollment: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("totp enrollment changed, please start again")
+	}
+	return nil
+}
+
+func (r *Repository) DisableTOTP(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"mfa_enabled": false, "updated_at": time.Now()},
+		"$unset": bson.M{"totp_secret": "", "pending_totp_secret": "", "totp_last_step": "", "recovery_codes": ""},
+	}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("error disabling totp: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no user found with id %s", id)
+	}
+	return nil
+}
+
+func (r *Repository) ReplaceRecoveryCodes(ctx context.Context, id primitive.ObjectID, recoveryCodes []string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "mfa_enabled": true}
+	update := bson.M{"$set": bson.M{"recovery_codes": recoveryCodes, "updated_at": time.Now()}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error replacing recovery codes: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode removes the code in a single update so two concurrent
+// logins cannot both spend it.
+func (r *Repository) ConsumeRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	if r.DB == nil {
+		return false, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "mfa_enabled": true, "recovery_codes": codeHash}
+	update := bson.M{"$pull": bson.M{"recovery_codes": codeHash}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("error consuming recovery code: %w", err)
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// UseTOTPStep records step as the last accepted code. It returns false if a
+// code from the same or a later step was already used.
+func (r *Repository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	if r.DB == nil {
+		return false, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{
+		"_id":         id,
+		"mfa_enabled": true,
+		"$or": []bson.M{
+			{"totp_last_step": bson.M{"$exists": false}},
+			{"totp_last_step": bson.M{"$lt": step}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"totp_last_step": step}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, fmt.Errorf("error recording totp use: %w", err)
+	}
+	return result.ModifiedCount == 1, nil
+}
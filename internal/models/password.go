@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+)
+
+type PasswordService interface {
+	UpdatePassword(ctx context.Context, id primitive.ObjectID, password string) error
+	SetPasswordResetToken(ctx context.Context, id primitive.ObjectID, tokenHash string, expiresAt time.Time) error
+	GetUserByPasswordResetToken(ctx context.Context, tokenHash string) (*User, error)
+	ResetPassword(ctx context.Context, tokenHash, password string) error
+	UserHasPassword(ctx context.Context, id primitive.ObjectID) (bool, error)
+}
+
+func passwordUpdate(password string) (bson.M, error) {
+	hashedPassword, err := helpers.HashPassword(strings.TrimSpace(password))
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+	// a new password signs out every session issued before it
+	now := time.Now()
+	return bson.M{
+		"$set":   bson.M{"password": hashedPassword, "password_changed_at": now, "sessions_revoked_at": now, "updated_at": now},
+		"$unset": bson.M{"password_reset_hash": "", "password_reset_expires": "", "password_reset_required": ""},
+		"$inc":   bson.M{"version": 1},
+	}, nil
+}
+
+// UpdatePassword stores a new password and ends the user's sessions. The
+// caller is responsible for checking it against the password policy.
+func (r *Repository) UpdatePassword(ctx context.Context, id primitive.ObjectID, password string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update, err := passwordUpdate(password)
+	if err != nil {
+		return err
+	}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("error updating password: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no user found with id %s", id)
+	}
+	return nil
+}
+
+// UserHasPassword reports whether the user has a local password. Accounts
+// created through single sign-on have none until they set one. The loaders
+// that return a User blank the hash, so this reads it on its own.
+func (r *Repository) UserHasPassword(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	if r.DB == nil {
+		return false, fmt.Errorf("database connection is not initialized")
+	}
+
+	var user User
+	opts := options.FindOne().SetProjection(bson.M{"password": 1})
+	err := r.DB.Database("expensetracker").Collection("users").FindOne(ctx, bson.M{"_id": id}, opts).Decode(&user)
+	if err != nil {
+		return false, fmt.Errorf("error fetching user: %w", err)
+	}
+	return user.Password != "", nil
+}
+
+func (r *Repository) SetPasswordResetToken(ctx context.Context, id primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"password_reset_hash": tokenHash, "password_reset_expires": expiresAt}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return fmt.Errorf("error storing password reset token: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("no user found with id %s", id)
+	}
+	return nil
+}
+
+func (r *Repository) GetUserByPasswordResetToken(ctx context.Context, tokenHash string) (*User, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	user := User{}
+	filter := bson.M{"password_reset_hash": tokenHash, "password_reset_expires": bson.M{"$gt": time.Now()}}
+	err := r.DB.Database("expensetracker").Collection("users").FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("invalid or expired reset token")
+		}
+		return nil, fmt.Errorf("error fetching user: %w", err)
+	}
+
+	user.Password = ""
+	return &user, nil
+}
+
+// ResetPassword sets the password for the user holding a valid reset token and
+// consumes the token in the same update.
+func (r *Repository) ResetPassword(ctx context.Context, tokenHash, password string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update, err := passwordUpdate(password)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"password_reset_hash": tokenHash, "password_reset_expires": bson.M{"$gt": time.Now()}}
+	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("error resetting password: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AliasExact    = "exact"
+	AliasPrefix   = "prefix"
+	AliasContains = "contains"
+)
+
+// Payee is a merchant or person money goes to or comes from. Transactions
+// are matched to a payee through its aliases, so "AMZN Mktp US*2K4" and
+// "Amazon.com" can both end up as Amazon.
+type Payee struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId    primitive.ObjectID `bson:"user_id" json:"-"`
+	Name      string             `bson:"name" json:"name" validate:"required,max=100"`
+	Aliases   []PayeeAlias       `bson:"aliases" json:"aliases" validate:"max=50,dive"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// PayeeAlias matches normalized transaction descriptions, see
+// NormalizeDescription. The payee's own name always acts as an exact alias.
+type PayeeAlias struct {
+	Pattern string `bson:"pattern" json:"pattern" validate:"required,max=100"`
+	Match   string `bson:"match" json:"match" validate:"oneof=exact prefix contains"`
+}
+
+var (
+	ErrPayeeNotFound  = errors.New("payee not found")
+	ErrTooManyAliases = errors.New("the merged payee would have more than 50 aliases")
+)
+
+// PayeeTotals sums the live transactions of one payee.
+type PayeeTotals struct {
+	Income  float64    `bson:"income" json:"income"`
+	Expense float64    `bson:"expense" json:"expense"`
+	Count   int        `bson:"count" json:"count"`
+	FirstAt *time.Time `bson:"first_at,omitempty" json:"first_at,omitempty"`
+	LastAt  *time.Time `bson:"last_at,omitempty" json:"last_at,omitempty"`
+}
+
+type PayeeService interface {
+	CreatePayee(ctx context.Context, payee *Payee) error
+	GetPayee(ctx context.Context, id, userID primitive.ObjectID) (*Payee, error)
+	ListPayees(ctx context.Context, userID primitive.ObjectID, search string) ([]Payee, error)
+	UpdatePayee(ctx context.Context, payee *Payee) error
+	DeletePayee(ctx context.Context, id, userID primitive.ObjectID) ([]TransactionChange, error)
+	MatchPayee(ctx context.Context, userID primitive.ObjectID, description string) (*Payee, error)
+	MergePayees(ctx context.Context, userID primitive.ObjectID, from []primitive.ObjectID, into primitive.ObjectID) ([]TransactionChange, error)
+	GetPayeeTotals(ctx context.Context, id primitive.ObjectID) (*PayeeTotals, error)
+	ListPayeeTransactions(ctx context.Context, id primitive.ObjectID, limit, offset int) ([]Transaction, error)
+}
+
+var (
+	// card processors append store numbers and references after these
+	referenceMarker = regexp.MustCompile(`[*#]`)
+	nonWord         = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	longNumber      = regexp.MustCompile(`^\p{N}{3,}$`)
+)
+
+// NormalizeDescription reduces a raw bank description to the words that
+// identify the payee: lowercase, without the reference after a * or #,
+// punctuation or long numbers. "AMZN Mktp US*2K4" becomes "amzn mktp us".
+func NormalizeDescription(description string) string {
+	description = strings.ToLower(description)
+	if loc := referenceMarker.FindStringIndex(description); loc != nil && loc[0] > 0 {
+		description = description[:loc[0]]
+	}
+	var words []string
+	for _, word := range strings.Fields(nonWord.ReplaceAllString(description, " ")) {
+		if !longNumber.MatchString(word) {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// normalizeAliases normalizes the patterns and drops empty and duplicate
+// aliases.
+func normalizeAliases(aliases []PayeeAlias) []PayeeAlias {
+	seen := map[PayeeAlias]bool{}
+	normalized := []PayeeAlias{}
+	for _, alias := range aliases {
+		alias.Pattern = NormalizeDescription(alias.Pattern)
+		if alias.Pattern == "" || seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		normalized = append(normalized, alias)
+	}
+	return normalized
+}
+
+// matchRank scores how well alias matches the normalized description; 0 is
+// no match. Exact beats prefix beats contains, and longer patterns beat
+// shorter ones of the same kind.
+func matchRank(alias PayeeAlias, description string) int {
+	var kind int
+	switch {
+	case alias.Match == AliasExact && description == alias.Pattern:
+		kind = 3
+	case alias.Match == AliasPrefix && (description == alias.Pattern || strings.HasPrefix(description, alias.Pattern+" ")):
+		kind = 2
+	case alias.Match == AliasContains && strings.Contains(" "+description+" ", " "+alias.Pattern+" "):
+		kind = 1
+	default:
+		return 0
+	}
+	return kind*1000 + len(alias.Pattern)
+}
+
+// bestPayee picks the payee whose aliases match description best.
+func bestPayee(payees []Payee, description string) *Payee {
+	description = NormalizeDescription(description)
+	if description == "" {
+		return nil
+	}
+	var best *Payee
+	bestRank := 0
+	for i := range payees {
+		aliases := append([]PayeeAlias{{Pattern: NormalizeDescription(payees[i].Name), Match: AliasExact}}, payees[i].Aliases...)
+		for _, alias := range aliases {
+			if rank := matchRank(alias, description); rank > bestRank {
+				best, bestRank = &payees[i], rank
+			}
+		}
+	}
+	return best
+}
+
+func (r *Repository) CreatePayee(ctx context.Context, payee *Payee) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	payee.Aliases = normalizeAliases(payee.Aliases)
+	if err := validate.Struct(payee); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	now := time.Now()
+	payee.Id = primitive.NewObjectID()
+	payee.CreatedAt = now
+	payee.UpdatedAt = now
+	if _, err := r.DB.Database("expensetracker").Collection("payees").InsertOne(ctx, payee); err != nil {
+		return fmt.Errorf("failed to create payee: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetPayee(ctx context.Context, id, userID primitive.ObjectID) (*Payee, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var payee Payee
+	err := r.DB.Database("expensetracker").Collection("payees").FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&payee)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPayeeNotFound
+		}
+		return nil, fmt.Errorf("error fetching payee: %w", err)
+	}
+	return &payee, nil
+}
+
+func (r *Repository) ListPayees(ctx context.Context, userID primitive.ObjectID, search string) ([]Payee, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"user_id": userID}
+	if search != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(search), "$options": "i"}
+	}
+	cursor, err := r.DB.Database("expensetracker").Collection("payees").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payees: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	payees := []Payee{}
+	if err := cursor.All(ctx, &payees); err != nil {
+		return nil, fmt.Errorf("failed to decode payees: %w", err)
+	}
+	return payees, nil
+}
+
+// UpdatePayee saves the name and aliases of payee.
+func (r *Repository) UpdatePayee(ctx context.Context, payee *Payee) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	payee.Aliases = normalizeAliases(payee.Aliases)
+	if err := validate.Struct(payee); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	payee.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{"name": payee.Name, "aliases": payee.Aliases, "updated_at": payee.UpdatedAt}}
+	result, err := r.DB.Database("expensetracker").Collection("payees").UpdateOne(ctx, bson.M{"_id": payee.Id, "user_id": payee.UserId}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update payee: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("payee not found")
+	}
+	return nil
+}
+
+// DeletePayee deletes the payee and unlinks its transactions, which it
+// returns. Rules setting the payee stop doing so and rules testing for it are
+// disabled.
+func (r *Repository) DeletePayee(ctx context.Context, id, userID primitive.ObjectID) ([]TransactionChange, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	db := r.DB.Database("expensetracker")
+	var changes []TransactionChange
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		result, err := db.Collection("payees").DeleteOne(sc, bson.M{"_id": id, "user_id": userID})
+		if err != nil {
+			return fmt.Errorf("failed to delete payee: %w", err)
+		}
+		if result.DeletedCount == 0 {
+			return ErrPayeeNotFound
+		}
+		update := bson.M{"$unset": bson.M{"payee_id": ""}, "$set": bson.M{"updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+		changes, err = updateTransactions(sc, db, bson.M{"payee_id": id}, update)
+		if err != nil {
+			return fmt.Errorf("failed to unlink payee transactions: %w", err)
+		}
+		return repointRulePayees(sc, db, userID, []primitive.ObjectID{id}, nil)
+	})
+	return changes, err
+}
+
+// MatchPayee returns the user's payee whose aliases best match description,
+// or nil when none does.
+func (r *Repository) MatchPayee(ctx context.Context, userID primitive.ObjectID, description string) (*Payee, error) {
+	payees, err := r.ListPayees(ctx, userID, "")
+	if err != nil {
+		return nil, err
+	}
+	return bestPayee(payees, description), nil
+}
+
+// MergePayees folds the payees in from into the payee into: their names and
+// aliases become aliases of into, their transactions and rules move over and
+// they are deleted, all in one transaction. It returns the transactions that moved.
+func (r *Repository) MergePayees(ctx context.Context, userID primitive.ObjectID, from []primitive.ObjectID, into primitive.ObjectID) ([]TransactionChange, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	db := r.DB.Database("expensetracker")
+	var moved []TransactionChange
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		target, err := r.GetPayee(sc, into, userID)
+		if err != nil {
+			return err
+		}
+		cursor, err := db.Collection("payees").Find(sc, bson.M{"_id": bson.M{"$in": from, "$ne": into}, "user_id": userID})
+		if err != nil {
+			return fmt.Errorf("failed to find payees: %w", err)
+		}
+		var sources []Payee
+		if err := cursor.All(sc, &sources); err != nil {
+			return fmt.Errorf("failed to decode payees: %w", err)
+		}
+		if len(sources) != len(from) {
+			return ErrPayeeNotFound
+		}
+
+		ids := make([]primitive.ObjectID, len(sources))
+		aliases := target.Aliases
+		for i, source := range sources {
+			ids[i] = source.Id
+			aliases = append(aliases, PayeeAlias{Pattern: source.Name, Match: AliasExact})
+			aliases = append(aliases, source.Aliases...)
+		}
+		target.Aliases = normalizeAliases(aliases)
+		if len(target.Aliases) > 50 {
+			return ErrTooManyAliases
+		}
+		if err := r.UpdatePayee(sc, target); err != nil {
+			return err
+		}
+
+		update := bson.M{"$set": bson.M{"payee_id": into, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+		moved, err = updateTransactions(sc, db, bson.M{"payee_id": bson.M{"$in": ids}}, update)
+		if err != nil {
+			return fmt.Errorf("failed to move payee transactions: %w", err)
+		}
+		if err := repointRulePayees(sc, db, userID, ids, &into); err != nil {
+			return err
+		}
+		if _, err := db.Collection("payees").DeleteMany(sc, bson.M{"_id": bson.M{"$in": ids}, "user_id": userID}); err != nil {
+			return fmt.Errorf("failed to delete merged payees: %w", err)
+		}
+		return nil
+	})
+	return moved, err
+}
+
+func (r *Repository) GetPayeeTotals(ctx context.Context, id primitive.ObjectID) (*PayeeTotals, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"payee_id": id, "deleted_at": live}},
+		{"$group": bson.M{
+			"_id":      nil,
+			"income":   bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$type", "income"}}, "$amount", 0}}},
+			"expense":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$type", "expense"}}, "$amount", 0}}},
+			"count":    bson.M{"$sum": 1},
+			"first_at": bson.M{"$min": "$created_at"},
+			"last_at":  bson.M{"$max": "$created_at"},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute payee totals: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []PayeeTotals
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode payee totals: %w", err)
+	}
+	if len(totals) == 0 {
+		return &PayeeTotals{}, nil
+	}
+	return &totals[0], nil
+}
+
+func (r *Repository) ListPayeeTransactions(ctx context.Context, id primitive.ObjectID, limit, offset int) ([]Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Find(ctx, bson.M{"payee_id": id, "deleted_at": live}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payee transactions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %w", err)
+	}
+	return transactions, nil
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Joshua-takyi/expense/server/internal/period"
+)
+
+// Preferences control how a user's data is displayed and how dates are
+// grouped into periods.
+type Preferences struct {
+	UserId    primitive.ObjectID `bson:"user_id" json:"-"`
+	Currency  string             `bson:"currency" json:"currency" validate:"iso4217"`
+	Locale    string             `bson:"locale" json:"locale" validate:"bcp47_language_tag"`
+	Timezone  string             `bson:"timezone" json:"timezone" validate:"timezone"`
+	WeekStart string             `bson:"week_start" json:"week_start" validate:"oneof=sunday monday saturday"`
+	// day of the month (1-28) on which the user's month, and so their
+	// quarters and years, begin
+	FiscalMonthStart int       `bson:"fiscal_month_start" json:"fiscal_month_start" validate:"min=1,max=28"`
+	UpdatedAt        time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+func DefaultPreferences(userID primitive.ObjectID) *Preferences {
+	return &Preferences{
+		UserId:           userID,
+		Currency:         "USD",
+		Locale:           "en-US",
+		Timezone:         "UTC",
+		WeekStart:        "monday",
+		FiscalMonthStart: 1,
+	}
+}
+
+// Calendar returns the period calendar described by the preferences.
+func (p *Preferences) Calendar() period.Calendar {
+	cal := period.Default
+	if loc, err := time.LoadLocation(p.Timezone); err == nil {
+		cal.Location = loc
+	}
+	switch strings.ToLower(p.WeekStart) {
+	case "sunday":
+		cal.WeekStart = time.Sunday
+	case "saturday":
+		cal.WeekStart = time.Saturday
+	}
+	if p.FiscalMonthStart >= 1 && p.FiscalMonthStart <= 28 {
+		cal.MonthStartDay = p.FiscalMonthStart
+	}
+	return cal
+}
+
+type PreferenceService interface {
+	GetPreferences(ctx context.Context, userID primitive.ObjectID) (*Preferences, error)
+	UpdatePreferences(ctx context.Context, prefs *Preferences) error
+}
+
+// GetPreferences returns the user's preferences, or the defaults if they have
+// never saved any.
+func (r *Repository) GetPreferences(ctx context.Context, userID primitive.ObjectID) (*Preferences, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var prefs Preferences
+	err := r.DB.Database("expensetracker").Collection("preferences").FindOne(ctx, bson.M{"user_id": userID}).Decode(&prefs)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return DefaultPreferences(userID), nil
+		}
+		return nil, fmt.Errorf("error fetching preferences: %w", err)
+	}
+	return &prefs, nil
+}
+
+func (r *Repository) UpdatePreferences(ctx context.Context, prefs *Preferences) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if err := validate.Struct(prefs); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	prefs.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	if _, err := r.DB.Database("expensetracker").Collection("preferences").ReplaceOne(ctx, bson.M{"user_id": prefs.UserId}, prefs, opts); err != nil {
+		return fmt.Errorf("error updating preferences: %w", err)
+	}
+	return nil
+}
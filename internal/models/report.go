@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/Joshua-takyi/expense/server/internal/period"
+)
+
+// PeriodTotal sums the transactions of one period, [Start, End).
+type PeriodTotal struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Income  float64   `json:"income"`
+	Expense float64   `json:"expense"`
+	Net     float64   `json:"net"`
+	Count   int       `json:"count"`
+}
+
+// SummarizeTransactions groups txs into consecutive periods of unit in the
+// user's calendar, covering [from, to) including empty periods. When the
+// range is open it is narrowed to the transactions themselves. With
+// categories, only the split lines in those categories are counted.
+func SummarizeTransactions(txs []Transaction, cal period.Calendar, unit period.Unit, from, to time.Time, categories []string) []PeriodTotal {
+	categories = NormalizeCategories(categories)
+	totals := []PeriodTotal{}
+	if len(txs) == 0 && (from.IsZero() || to.IsZero()) {
+		return totals
+	}
+	for _, tx := range txs {
+		if from.IsZero() || tx.CreatedAt.Before(from) {
+			from = tx.CreatedAt
+		}
+		if to.IsZero() || !tx.CreatedAt.Before(to) {
+			to = tx.CreatedAt.Add(time.Nanosecond)
+		}
+	}
+
+	index := map[time.Time]int{}
+	for start := cal.Start(unit, from); start.Before(to); start = cal.Next(unit, start) {
+		index[start] = len(totals)
+		totals = append(totals, PeriodTotal{Start: start, End: cal.Next(unit, start)})
+	}
+
+	for _, tx := range txs {
+		// moving money between accounts or settling up between members is
+		// neither income nor expense
+		if tx.Type == TypeTransfer || tx.Type == TypeSettlement {
+			continue
+		}
+		i, ok := index[cal.Start(unit, tx.CreatedAt)]
+		if !ok {
+			continue
+		}
+		amount, ok := tx.amountIn(categories)
+		if !ok {
+			continue
+		}
+		switch tx.Type {
+		case "income":
+			totals[i].Income += amount
+			totals[i].Net += amount
+		case "expense":
+			totals[i].Expense += amount
+			totals[i].Net -= amount
+		}
+		totals[i].Count++
+	}
+	return totals
+}
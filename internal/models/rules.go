@@ -0,0 +1,414 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rule fields and operators. Text fields take contains, equals and regex,
+// amount takes range and payee and account take equals with an id.
+const (
+	RuleFieldDescription = "description"
+	RuleFieldNote        = "note"
+	RuleFieldAmount      = "amount"
+	RuleFieldPayee       = "payee"
+	RuleFieldAccount     = "account"
+
+	RuleOpContains = "contains"
+	RuleOpEquals   = "equals"
+	RuleOpRegex    = "regex"
+	RuleOpRange    = "range"
+)
+
+const (
+	maxRuleConditions = 20
+	maxRulePattern    = 200
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule categorizes transactions automatically. When all of its conditions
+// match a new transaction, its actions are applied. Rules run in ascending
+// priority; the first rule to set a field wins, and tags accumulate.
+type Rule struct {
+	Id         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId     primitive.ObjectID `bson:"user_id" json:"-"`
+	Name       string             `bson:"name" json:"name"`
+	Priority   int                `bson:"priority" json:"priority"`
+	Enabled    bool               `bson:"enabled" json:"enabled"`
+	Conditions []RuleCondition    `bson:"conditions" json:"conditions"`
+	Actions    RuleActions        `bson:"actions" json:"actions"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type RuleCondition struct {
+	Field    string   `bson:"field" json:"field"`
+	Operator string   `bson:"operator" json:"operator"`
+	Value    string   `bson:"value,omitempty" json:"value,omitempty"`
+	Min      *float64 `bson:"min,omitempty" json:"min,omitempty"`
+	Max      *float64 `bson:"max,omitempty" json:"max,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// RuleActions are the fields a matching rule sets. Empty ones are left
+// alone.
+type RuleActions struct {
+	Category string              `bson:"category,omitempty" json:"category,omitempty"`
+	Tags     []string            `bson:"tags,omitempty" json:"tags,omitempty"`
+	PayeeId  *primitive.ObjectID `bson:"payee_id,omitempty" json:"payee_id,omitempty"`
+	Type     string              `bson:"type,omitempty" json:"type,omitempty"`
+}
+
+// Validate checks and normalizes the rule before it is saved.
+func (rule *Rule) Validate() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" || len(rule.Name) > 100 {
+		return fmt.Errorf("%w: name must be 1 to 100 characters", ErrInvalidRule)
+	}
+	if len(rule.Conditions) == 0 || len(rule.Conditions) > maxRuleConditions {
+		return fmt.Errorf("%w: between 1 and %d conditions are required", ErrInvalidRule, maxRuleConditions)
+	}
+	for i := range rule.Conditions {
+		if err := rule.Conditions[i].validate(); err != nil {
+			return fmt.Errorf("%w: condition %d: %v", ErrInvalidRule, i+1, err)
+		}
+	}
+
+	a := &rule.Actions
+	a.Category = strings.ToLower(strings.TrimSpace(a.Category))
+	if a.Type != "" && a.Type != "income" && a.Type != "expense" {
+		return fmt.Errorf("%w: type must be income or expense", ErrInvalidRule)
+	}
+	tags, err := NormalizeTags(a.Tags)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	a.Tags = tags
+	if a.Category == "" && len(a.Tags) == 0 && a.PayeeId == nil && a.Type == "" {
+		return fmt.Errorf("%w: at least one action is required", ErrInvalidRule)
+	}
+	return nil
+}
+
+func (cond *RuleCondition) validate() error {
+	switch cond.Field {
+	case RuleFieldDescription, RuleFieldNote:
+		if cond.Value == "" || len(cond.Value) > maxRulePattern {
+			return fmt.Errorf("value must be 1 to %d characters", maxRulePattern)
+		}
+		switch cond.Operator {
+		case RuleOpContains, RuleOpEquals:
+		case RuleOpRegex:
+			re, err := regexp.Compile("(?i)" + cond.Value)
+			if err != nil {
+				return fmt.Errorf("invalid regex: %v", err)
+			}
+			cond.re = re
+		default:
+			return fmt.Errorf("%s takes contains, equals or regex", cond.Field)
+		}
+		cond.Min, cond.Max = nil, nil
+	case RuleFieldAmount:
+		if cond.Operator != RuleOpRange {
+			return fmt.Errorf("amount takes range")
+		}
+		if cond.Min == nil && cond.Max == nil {
+			return fmt.Errorf("range needs min, max or both")
+		}
+		if cond.Min != nil && cond.Max != nil && *cond.Min > *cond.Max {
+			return fmt.Errorf("min is greater than max")
+		}
+		cond.Value = ""
+	case RuleFieldPayee, RuleFieldAccount:
+		if cond.Operator != RuleOpEquals {
+			return fmt.Errorf("%s takes equals", cond.Field)
+		}
+		if _, err := primitive.ObjectIDFromHex(cond.Value); err != nil {
+			return fmt.Errorf("value must be a %s id", cond.Field)
+		}
+		cond.Min, cond.Max = nil, nil
+	default:
+		return fmt.Errorf("unknown field %q", cond.Field)
+	}
+	return nil
+}
+
+func idEquals(id *primitive.ObjectID, hex string) bool {
+	return id != nil && id.Hex() == hex
+}
+
+func (cond *RuleCondition) matches(tx *Transaction) bool {
+	switch cond.Field {
+	case RuleFieldDescription, RuleFieldNote:
+		text := tx.Description
+		if cond.Field == RuleFieldNote {
+			text = tx.Note
+		}
+		switch cond.Operator {
+		case RuleOpContains:
+			return strings.Contains(strings.ToLower(text), strings.ToLower(cond.Value))
+		case RuleOpEquals:
+			return strings.EqualFold(strings.TrimSpace(text), strings.TrimSpace(cond.Value))
+		case RuleOpRegex:
+			if cond.re == nil {
+				re, err := regexp.Compile("(?i)" + cond.Value)
+				if err != nil {
+					return false
+				}
+				cond.re = re
+			}
+			return cond.re.MatchString(text)
+		}
+	case RuleFieldAmount:
+		return (cond.Min == nil || tx.Amount >= *cond.Min) && (cond.Max == nil || tx.Amount <= *cond.Max)
+	case RuleFieldPayee:
+		return idEquals(tx.PayeeId, cond.Value)
+	case RuleFieldAccount:
+		return idEquals(tx.AccountId, cond.Value)
+	}
+	return false
+}
+
+// Matches reports whether every condition of the rule matches tx. Transfers
+// never match.
+func (rule *Rule) Matches(tx *Transaction) bool {
+	if tx.IsTransfer() || tx.Type == TypeTransfer {
+		return false
+	}
+	for i := range rule.Conditions {
+		if !rule.Conditions[i].matches(tx) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyRules runs the enabled rules over tx in priority order, changing it
+// in place, and returns the ids of the rules that matched. A field set by
+// one rule isn't overwritten by a later one; split transactions keep their
+// split category.
+func ApplyRules(rules []Rule, tx *Transaction) []primitive.ObjectID {
+	ordered := append([]Rule(nil), rules...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Priority < ordered[j].Priority })
+
+	applied := []primitive.ObjectID{}
+	set := map[string]bool{}
+	for i := range ordered {
+		rule := &ordered[i]
+		if !rule.Enabled || !rule.Matches(tx) {
+			continue
+		}
+		applied = append(applied, rule.Id)
+
+		a := rule.Actions
+		if a.Category != "" && !set["category"] && len(tx.Splits) == 0 {
+			tx.Category, set["category"] = a.Category, true
+		}
+		if a.Type != "" && !set["type"] {
+			tx.Type, set["type"] = a.Type, true
+		}
+		if a.PayeeId != nil && !set["payee_id"] {
+			payeeID := *a.PayeeId
+			tx.PayeeId, set["payee_id"] = &payeeID, true
+		}
+		for _, tag := range a.Tags {
+			if !containsString(tx.Tags, tag) && len(tx.Tags) < maxTags {
+				tx.Tags = append(tx.Tags, tag)
+			}
+		}
+	}
+	return applied
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleUpdates returns the changes rule would make to an existing
+// transaction, by stored name as accepted by UpdateTransaction, or nil when
+// it doesn't match or changes nothing. Disabled rules are evaluated too.
+func RuleUpdates(rule *Rule, tx *Transaction) map[string]interface{} {
+	changed := *tx
+	changed.Tags = append([]string(nil), tx.Tags...)
+	enabled := *rule
+	enabled.Enabled = true
+	if len(ApplyRules([]Rule{enabled}, &changed)) == 0 {
+		return nil
+	}
+
+	updates := map[string]interface{}{}
+	if changed.Category != tx.Category {
+		updates["category"] = changed.Category
+	}
+	if changed.Type != tx.Type {
+		updates["type"] = changed.Type
+	}
+	if !reflect.DeepEqual(changed.PayeeId, tx.PayeeId) {
+		updates["payee_id"] = changed.PayeeId
+	}
+	if len(changed.Tags) != len(tx.Tags) {
+		updates["tags"] = changed.Tags
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return updates
+}
+
+type RuleService interface {
+	CreateRule(ctx context.Context, rule *Rule) error
+	GetRule(ctx context.Context, id, userID primitive.ObjectID) (*Rule, error)
+	ListRules(ctx context.Context, userID primitive.ObjectID) ([]Rule, error)
+	UpdateRule(ctx context.Context, rule *Rule) error
+	DeleteRule(ctx context.Context, id, userID primitive.ObjectID) error
+}
+
+func (r *Repository) CreateRule(ctx context.Context, rule *Rule) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rule.Id = primitive.NewObjectID()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if _, err := r.DB.Database("expensetracker").Collection("rules").InsertOne(ctx, rule); err != nil {
+		return fmt.Errorf("failed to create rule: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetRule(ctx context.Context, id, userID primitive.ObjectID) (*Rule, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var rule Rule
+	err := r.DB.Database("expensetracker").Collection("rules").FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("rule not found")
+		}
+		return nil, fmt.Errorf("error fetching rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// ListRules returns the user's rules in the order they run.
+func (r *Repository) ListRules(ctx context.Context, userID primitive.ObjectID) ([]Rule, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "created_at", Value: 1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("rules").Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	rules := []Rule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, fmt.Errorf("failed to decode rules: %w", err)
+	}
+	return rules, nil
+}
+
+// UpdateRule replaces everything but the rule's id, owner and creation time.
+func (r *Repository) UpdateRule(ctx context.Context, rule *Rule) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	rule.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"name":       rule.Name,
+		"priority":   rule.Priority,
+		"enabled":    rule.Enabled,
+		"conditions": rule.Conditions,
+		"actions":    rule.Actions,
+		"updated_at": rule.UpdatedAt,
+	}}
+	result, err := r.DB.Database("expensetracker").Collection("rules").UpdateOne(ctx, bson.M{"_id": rule.Id, "user_id": rule.UserId}, update)
+	if err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	return nil
+}
+
+func (r *Repository) DeleteRule(ctx context.Context, id, userID primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	result, err := r.DB.Database("expensetracker").Collection("rules").DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("rule not found")
+	}
+	return nil
+}
+
+// repointRulePayees makes rules that act on or test for the payees in from
+// use into instead, or drop the payee when into is nil.
+func repointRulePayees(sc mongo.SessionContext, db *mongo.Database, userID primitive.ObjectID, from []primitive.ObjectID, into *primitive.ObjectID) error {
+	rules := db.Collection("rules")
+	hexes := make([]string, len(from))
+	for i, id := range from {
+		hexes[i] = id.Hex()
+	}
+
+	action := bson.M{"$unset": bson.M{"actions.payee_id": ""}}
+	if into != nil {
+		action = bson.M{"$set": bson.M{"actions.payee_id": *into}}
+	}
+	if _, err := rules.UpdateMany(sc, bson.M{"user_id": userID, "actions.payee_id": bson.M{"$in": from}}, action); err != nil {
+		return fmt.Errorf("failed to update rule actions: %w", err)
+	}
+
+	conditions := bson.M{"user_id": userID, "conditions": bson.M{"$elemMatch": bson.M{"field": RuleFieldPayee, "value": bson.M{"$in": hexes}}}}
+	if into == nil {
+		// a rule testing for a payee that no longer exists can't match again
+		_, err := rules.UpdateMany(sc, conditions, bson.M{"$set": bson.M{"enabled": false}})
+		if err != nil {
+			return fmt.Errorf("failed to disable rules: %w", err)
+		}
+		return nil
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+		bson.M{"c.field": RuleFieldPayee, "c.value": bson.M{"$in": hexes}},
+	}})
+	if _, err := rules.UpdateMany(sc, conditions, bson.M{"$set": bson.M{"conditions.$[c].value": into.Hex()}}, opts); err != nil {
+		return fmt.Errorf("failed to update rule conditions: %w", err)
+	}
+	return nil
+}
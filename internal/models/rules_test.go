@@ -0,0 +1,188 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestRuleMatches(t *testing.T) {
+	payee, account := primitive.NewObjectID(), primitive.NewObjectID()
+	tx := &Transaction{Type: "expense", Amount: 42.5, Description: "AMZN Mktp US*2K4", Note: "Birthday present", PayeeId: &payee, AccountId: &account}
+
+	tests := []struct {
+		name string
+		cond RuleCondition
+		want bool
+	}{
+		{"contains ignores case", RuleCondition{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "amzn mktp"}, true},
+		{"contains", RuleCondition{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "ebay"}, false},
+		{"equals ignores case and spaces", RuleCondition{Field: RuleFieldNote, Operator: RuleOpEquals, Value: " birthday PRESENT "}, true},
+		{"equals is not contains", RuleCondition{Field: RuleFieldNote, Operator: RuleOpEquals, Value: "birthday"}, false},
+		{"regex ignores case", RuleCondition{Field: RuleFieldDescription, Operator: RuleOpRegex, Value: `^amzn .*\*[0-9a-z]+$`}, true},
+		{"regex", RuleCondition{Field: RuleFieldDescription, Operator: RuleOpRegex, Value: `^amazon`}, false},
+		{"range includes its bounds", RuleCondition{Field: RuleFieldAmount, Operator: RuleOpRange, Min: float(42.5), Max: float(42.5)}, true},
+		{"range with min only", RuleCondition{Field: RuleFieldAmount, Operator: RuleOpRange, Min: float(50)}, false},
+		{"range with max only", RuleCondition{Field: RuleFieldAmount, Operator: RuleOpRange, Max: float(100)}, true},
+		{"payee", RuleCondition{Field: RuleFieldPayee, Operator: RuleOpEquals, Value: payee.Hex()}, true},
+		{"other payee", RuleCondition{Field: RuleFieldPayee, Operator: RuleOpEquals, Value: primitive.NewObjectID().Hex()}, false},
+		{"account", RuleCondition{Field: RuleFieldAccount, Operator: RuleOpEquals, Value: account.Hex()}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := Rule{Name: "test", Conditions: []RuleCondition{tt.cond}, Actions: RuleActions{Category: "shopping"}}
+			if err := rule.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if got := rule.Matches(tx); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleNeverMatchesTransfers(t *testing.T) {
+	rule := Rule{Conditions: []RuleCondition{{Field: RuleFieldAmount, Operator: RuleOpRange, Min: float(0)}}}
+	linked := primitive.NewObjectID()
+	for _, tx := range []*Transaction{{Type: TypeTransfer, Amount: 10}, {Type: "expense", Amount: 10, LinkedId: &linked}} {
+		if rule.Matches(tx) {
+			t.Errorf("rule matched transfer %+v", tx)
+		}
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	payee := primitive.NewObjectID()
+	coffee := []RuleCondition{{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "coffee"}}
+	rules := []Rule{
+		// listed out of order: priority decides
+		{Id: second, Priority: 2, Enabled: true, Conditions: coffee, Actions: RuleActions{Category: "eating out", Tags: []string{"work", "caffeine"}}},
+		{Id: first, Priority: 1, Enabled: true, Conditions: coffee, Actions: RuleActions{Category: "coffee", Tags: []string{"caffeine"}, PayeeId: &payee}},
+		{Id: primitive.NewObjectID(), Priority: 0, Enabled: false, Conditions: coffee, Actions: RuleActions{Category: "disabled"}},
+		{Id: primitive.NewObjectID(), Priority: 3, Enabled: true, Conditions: []RuleCondition{{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "tea"}}, Actions: RuleActions{Category: "tea"}},
+	}
+
+	tx := &Transaction{Type: "expense", Description: "Coffee shop", Category: "uncategorized", Tags: []string{"work"}}
+	applied := ApplyRules(rules, tx)
+	if want := []primitive.ObjectID{first, second}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied = %v, want %v", applied, want)
+	}
+	if tx.Category != "coffee" {
+		t.Errorf("category = %q, want the first rule's", tx.Category)
+	}
+	if tx.PayeeId == nil || *tx.PayeeId != payee {
+		t.Errorf("payee = %v, want %v", tx.PayeeId, payee)
+	}
+	if want := []string{"work", "caffeine"}; !reflect.DeepEqual(tx.Tags, want) {
+		t.Errorf("tags = %v, want %v", tx.Tags, want)
+	}
+
+	split := &Transaction{Type: "expense", Description: "coffee and cake", Category: CategorySplit, Splits: []Split{{Category: "a", Amount: 1}, {Category: "b", Amount: 1}}}
+	ApplyRules(rules, split)
+	if split.Category != CategorySplit {
+		t.Errorf("split transaction got category %q", split.Category)
+	}
+}
+
+func TestApplyRulesCapsTags(t *testing.T) {
+	tags := make([]string, maxTags)
+	for i := range tags {
+		tags[i] = string(rune('a' + i))
+	}
+	tx := &Transaction{Description: "coffee", Tags: tags}
+	rule := Rule{Enabled: true, Conditions: []RuleCondition{{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "coffee"}}, Actions: RuleActions{Tags: []string{"one-too-many"}}}
+	ApplyRules([]Rule{rule}, tx)
+	if len(tx.Tags) != maxTags {
+		t.Errorf("%d tags, want at most %d", len(tx.Tags), maxTags)
+	}
+}
+
+func TestRuleUpdates(t *testing.T) {
+	payee := primitive.NewObjectID()
+	rule := &Rule{
+		Enabled:    false, // disabled rules are applied when asked to explicitly
+		Conditions: []RuleCondition{{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "coffee"}},
+		Actions:    RuleActions{Category: "coffee", Tags: []string{"caffeine"}, PayeeId: &payee},
+	}
+
+	tests := []struct {
+		name string
+		tx   Transaction
+		want map[string]interface{}
+	}{
+		{"no match", Transaction{Description: "tea", Category: "drinks"}, nil},
+		{"everything changes", Transaction{Description: "coffee", Category: "drinks"}, map[string]interface{}{
+			"category": "coffee", "payee_id": &payee, "tags": []string{"caffeine"},
+		}},
+		{"only the missing tag", Transaction{Description: "coffee", Category: "coffee", PayeeId: &payee, Tags: []string{"work"}}, map[string]interface{}{
+			"tags": []string{"work", "caffeine"},
+		}},
+		{"already applied", Transaction{Description: "coffee", Category: "coffee", PayeeId: &payee, Tags: []string{"caffeine"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.tx
+			before.Tags = append([]string(nil), tt.tx.Tags...)
+			got := RuleUpdates(rule, &tt.tx)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RuleUpdates = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.tx, before) {
+				t.Errorf("RuleUpdates changed the transaction to %+v", tt.tx)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	valid := func() Rule {
+		return Rule{
+			Name:       " Coffee ",
+			Conditions: []RuleCondition{{Field: RuleFieldDescription, Operator: RuleOpContains, Value: "coffee"}},
+			Actions:    RuleActions{Category: " Coffee ", Tags: []string{"Caffeine"}},
+		}
+	}
+	rule := valid()
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rule.Name != "Coffee" || rule.Actions.Category != "coffee" || !reflect.DeepEqual(rule.Actions.Tags, []string{"caffeine"}) {
+		t.Errorf("rule wasn't normalized: %+v", rule)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*Rule)
+	}{
+		{"no name", func(r *Rule) { r.Name = " " }},
+		{"no conditions", func(r *Rule) { r.Conditions = nil }},
+		{"no actions", func(r *Rule) { r.Actions = RuleActions{} }},
+		{"bad type", func(r *Rule) { r.Actions.Type = "transfer" }},
+		{"bad regex", func(r *Rule) {
+			r.Conditions[0] = RuleCondition{Field: RuleFieldNote, Operator: RuleOpRegex, Value: "("}
+		}},
+		{"range on text", func(r *Rule) { r.Conditions[0].Operator = RuleOpRange }},
+		{"empty range", func(r *Rule) { r.Conditions[0] = RuleCondition{Field: RuleFieldAmount, Operator: RuleOpRange} }},
+		{"inverted range", func(r *Rule) {
+			r.Conditions[0] = RuleCondition{Field: RuleFieldAmount, Operator: RuleOpRange, Min: float(10), Max: float(5)}
+		}},
+		{"payee that isn't an id", func(r *Rule) {
+			r.Conditions[0] = RuleCondition{Field: RuleFieldPayee, Operator: RuleOpEquals, Value: "amazon"}
+		}},
+		{"unknown field", func(r *Rule) { r.Conditions[0].Field = "category" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := valid()
+			tt.edit(&rule)
+			if err := rule.Validate(); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("Validate = %v, want ErrInvalidRule", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TypeSettlement is the type of a payment between ledger members that
+// settles what one owes the other. Its Sharing reads as if the payer had
+// paid the whole amount on the receiver's behalf, which moves both balances
+// towards zero. Settlements are neither income nor expense in reports.
+const TypeSettlement = "settlement"
+
+// How the cost of a shared expense is divided between members.
+const (
+	ShareEqually = "equal"   // the same amount for each participant
+	ShareByShare = "shares"  // proportional to each participant's Value
+	ShareExactly = "exact"   // Value is each participant's amount
+	ShareByPct   = "percent" // Value is each participant's percentage
+)
+
+var ErrInvalidSharing = errors.New("invalid cost sharing")
+
+// Sharing records who paid for a shared expense in a ledger and what each
+// participant owes for it, Splitwise style.
+type Sharing struct {
+	PaidBy primitive.ObjectID `bson:"paid_by" json:"paid_by"`
+	Method string             `bson:"method" json:"method"`
+	Shares []MemberShare      `bson:"shares" json:"shares"`
+}
+
+type MemberShare struct {
+	UserId primitive.ObjectID `bson:"user_id" json:"user_id"`
+	// the number of shares, amount or percentage, depending on the method
+	Value float64 `bson:"value,omitempty" json:"value,omitempty"`
+	// what the participant owes, computed by Allocate
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+// Allocate validates s and works out each participant's amount of total to
+// the cent. Cents that don't divide evenly go to the participants with the
+// largest remainders, earliest listed first, so the amounts always add up to
+// the total.
+func (s *Sharing) Allocate(total float64) error {
+	if len(s.Shares) == 0 {
+		return fmt.Errorf("%w: at least one participant is required", ErrInvalidSharing)
+	}
+	if s.PaidBy.IsZero() {
+		return fmt.Errorf("%w: paid_by is required", ErrInvalidSharing)
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for _, share := range s.Shares {
+		if share.UserId.IsZero() || seen[share.UserId] {
+			return fmt.Errorf("%w: each participant must be listed once", ErrInvalidSharing)
+		}
+		seen[share.UserId] = true
+	}
+
+	cents := toCents(total)
+	if cents <= 0 {
+		return fmt.Errorf("%w: the amount must be positive", ErrInvalidSharing)
+	}
+
+	weights := make([]float64, len(s.Shares))
+	switch s.Method {
+	case ShareEqually:
+		for i := range s.Shares {
+			s.Shares[i].Value = 0
+			weights[i] = 1
+		}
+	case ShareByShare, ShareByPct:
+		var sum float64
+		for i, share := range s.Shares {
+			if share.Value <= 0 {
+				return fmt.Errorf("%w: participant %d needs a positive value", ErrInvalidSharing, i+1)
+			}
+			weights[i] = share.Value
+			sum += share.Value
+		}
+		if s.Method == ShareByPct && math.Abs(sum-100) >= 0.01 {
+			return fmt.Errorf("%w: percentages add up to %.2f, not 100", ErrInvalidSharing, sum)
+		}
+	case ShareExactly:
+		var sum int64
+		for i, share := range s.Shares {
+			if share.Value < 0 {
+				return fmt.Errorf("%w: participant %d can't owe a negative amount", ErrInvalidSharing, i+1)
+			}
+			sum += toCents(share.Value)
+		}
+		if sum != cents {
+			return fmt.Errorf("%w: amounts add up to %.2f, not %.2f", ErrInvalidSharing, float64(sum)/100, float64(cents)/100)
+		}
+		for i := range s.Shares {
+			s.Shares[i].Amount = float64(toCents(s.Shares[i].Value)) / 100
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: method must be equal, shares, exact or percent", ErrInvalidSharing)
+	}
+
+	var weightSum float64
+	for _, w := range weights {
+		weightSum += w
+	}
+	allocated := make([]int64, len(weights))
+	remainders := make([]float64, len(weights))
+	left := cents
+	for i, w := range weights {
+		exact := float64(cents) * w / weightSum
+		allocated[i] = int64(math.Floor(exact))
+		remainders[i] = exact - float64(allocated[i])
+		left -= allocated[i]
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := 0; left > 0; i, left = i+1, left-1 {
+		allocated[order[i%len(order)]]++
+	}
+	for i := range s.Shares {
+		s.Shares[i].Amount = float64(allocated[i]) / 100
+	}
+	return nil
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// MemberBalance is where a member stands in a ledger: positive balances are
+// owed money, negative ones owe money.
+type MemberBalance struct {
+	UserId  primitive.ObjectID `json:"user_id"`
+	Paid    float64            `json:"paid"`
+	Owed    float64            `json:"owed"`
+	Balance float64            `json:"balance"`
+}
+
+// Payment is a settle-up payment from one member to another.
+type Payment struct {
+	From   primitive.ObjectID `json:"from"`
+	To     primitive.ObjectID `json:"to"`
+	Amount float64            `json:"amount"`
+}
+
+// Balances sums what each member paid for and owes across the shared
+// expenses and settlements in txs, ordered from most owed to most owing.
+// Members who left still appear while their balance is open.
+func Balances(txs []Transaction) []MemberBalance {
+	paid := map[primitive.ObjectID]int64{}
+	owed := map[primitive.ObjectID]int64{}
+	var ids []primitive.ObjectID
+	track := func(id primitive.ObjectID) {
+		if _, ok := paid[id]; !ok {
+			paid[id], owed[id] = 0, 0
+			ids = append(ids, id)
+		}
+	}
+	for _, tx := range txs {
+		if tx.Sharing == nil || tx.DeletedAt != nil {
+			continue
+		}
+		track(tx.Sharing.PaidBy)
+		paid[tx.Sharing.PaidBy] += toCents(tx.Amount)
+		for _, share := range tx.Sharing.Shares {
+			track(share.UserId)
+			owed[share.UserId] += toCents(share.Amount)
+		}
+	}
+
+	balances := make([]MemberBalance, len(ids))
+	for i, id := range ids {
+		balances[i] = MemberBalance{
+			UserId:  id,
+			Paid:    float64(paid[id]) / 100,
+			Owed:    float64(owed[id]) / 100,
+			Balance: float64(paid[id]-owed[id]) / 100,
+		}
+	}
+	sort.SliceStable(balances, func(i, j int) bool { return balances[i].Balance > balances[j].Balance })
+	return balances
+}
+
+// maxExactSettle is the most open balances SettleUp finds the fewest
+// payments for. The search doubles with each one; 16 takes a few milliseconds.
+const maxExactSettle = 16
+
+// position is an open balance in cents, positive when owed money.
+type position struct {
+	id    primitive.ObjectID
+	cents int64
+}
+
+// SettleUp works out payments that bring every balance to zero. A group of
+// members whose balances add up to zero can settle among themselves in one
+// payment fewer than its size, so the fewest payments come from splitting
+// everyone into as many such groups as possible. With up to maxExactSettle
+// open balances SettleUp finds that split and returns the fewest payments;
+// with more it settles everyone as one group, which takes at most one
+// payment fewer than there are open balances.
+func SettleUp(balances []MemberBalance) []Payment {
+	var open []position
+	for _, b := range balances {
+		if cents := toCents(b.Balance); cents != 0 {
+			open = append(open, position{b.UserId, cents})
+		}
+	}
+	payments := []Payment{}
+	for _, group := range zeroSumGroups(open) {
+		payments = append(payments, settleGroup(group)...)
+	}
+	return payments
+}
+
+// zeroSumGroups splits open into the most groups that each add up to zero.
+// groups[mask] is the most zero-sum groups the members in mask can form when
+// added one at a time, counting each time the running total returns to zero;
+// walking back from all members recovers an order that achieves it.
+func zeroSumGroups(open []position) [][]position {
+	n := len(open)
+	if n == 0 || n > maxExactSettle {
+		return [][]position{open}
+	}
+
+	full := 1<<n - 1
+	sums := make([]int64, full+1)
+	groups := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		sums[mask] = sums[mask&(mask-1)] + open[bits.TrailingZeros(uint(mask))].cents
+		best := 0
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && groups[mask^(1<<i)] > best {
+				best = groups[mask^(1<<i)]
+			}
+		}
+		if sums[mask] == 0 {
+			best++
+		}
+		groups[mask] = best
+	}
+
+	order := make([]int, 0, n)
+	for mask := full; mask != 0; {
+		pick := -1
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && (pick < 0 || groups[mask^(1<<i)] > groups[mask^(1<<pick)]) {
+				pick = i
+			}
+		}
+		order = append(order, pick)
+		mask ^= 1 << pick
+	}
+
+	var result [][]position
+	var current []position
+	var total int64
+	for k := len(order) - 1; k >= 0; k-- {
+		current = append(current, open[order[k]])
+		total += open[order[k]].cents
+		if total == 0 {
+			result = append(result, current)
+			current = nil
+		}
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// settleGroup repeatedly pays the largest debt in group to the largest
+// creditor, which takes at most one payment fewer than the group's size.
+func settleGroup(group []position) []Payment {
+	var creditors, debtors []position
+	for _, p := range group {
+		if p.cents > 0 {
+			creditors = append(creditors, p)
+		} else {
+			debtors = append(debtors, position{p.id, -p.cents})
+		}
+	}
+
+	var payments []Payment
+	for len(creditors) > 0 && len(debtors) > 0 {
+		sort.SliceStable(creditors, func(i, j int) bool { return creditors[i].cents > creditors[j].cents })
+		sort.SliceStable(debtors, func(i, j int) bool { return debtors[i].cents > debtors[j].cents })
+
+		amount := creditors[0].cents
+		if debtors[0].cents < amount {
+			amount = debtors[0].cents
+		}
+		payments = append(payments, Payment{From: debtors[0].id, To: creditors[0].id, Amount: float64(amount) / 100})
+		creditors[0].cents -= amount
+		debtors[0].cents -= amount
+		if creditors[0].cents == 0 {
+			creditors = creditors[1:]
+		}
+		if debtors[0].cents == 0 {
+			debtors = debtors[1:]
+		}
+	}
+	return payments
+}
+
+type SharingService interface {
+	ListSharedTransactions(ctx context.Context, ledgerID primitive.ObjectID) ([]Transaction, error)
+}
+
+// ListSharedTransactions returns the live shared expenses and settlements of
+// a ledger, with only the fields balances need.
+func (r *Repository) ListSharedTransactions(ctx context.Context, ledgerID primitive.ObjectID) ([]Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"ledger_id": ledgerID, "sharing": bson.M{"$ne": nil}, "deleted_at": live}
+	opts := options.Find().SetProjection(bson.M{"amount": 1, "type": 1, "sharing": 1, "created_at": 1})
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shared transactions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %w", err)
+	}
+	return transactions, nil
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func memberIDs(n int) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, n)
+	for i := range ids {
+		ids[i] = primitive.NewObjectID()
+	}
+	return ids
+}
+
+func TestAllocate(t *testing.T) {
+	ids := memberIDs(3)
+	shares := func(values ...float64) []MemberShare {
+		out := make([]MemberShare, len(values))
+		for i, v := range values {
+			out[i] = MemberShare{UserId: ids[i], Value: v}
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		shares []MemberShare
+		total  float64
+		want   []float64
+	}{
+		{"equal", ShareEqually, shares(0, 0, 0), 30, []float64{10, 10, 10}},
+		{"equal with a leftover cent", ShareEqually, shares(0, 0, 0), 10, []float64{3.34, 3.33, 3.33}},
+		{"equal with two leftover cents", ShareEqually, shares(0, 0, 0), 0.05, []float64{0.02, 0.02, 0.01}},
+		{"equal ignores values", ShareEqually, shares(5, 1, 1), 9, []float64{3, 3, 3}},
+		{"shares", ShareByShare, shares(2, 1, 1), 10, []float64{5, 2.5, 2.5}},
+		{"shares go to the largest remainder", ShareByShare, shares(1, 2), 0.1, []float64{0.03, 0.07}},
+		{"percent", ShareByPct, shares(50, 30, 20), 99.99, []float64{49.99, 30, 20}},
+		{"exact", ShareExactly, shares(12.5, 7.5), 20, []float64{12.5, 7.5}},
+		{"exact with a zero share", ShareExactly, shares(20, 0), 20, []float64{20, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Sharing{PaidBy: ids[0], Method: tt.method, Shares: tt.shares}
+			if err := s.Allocate(tt.total); err != nil {
+				t.Fatalf("Allocate: %v", err)
+			}
+			var sum int64
+			for i, share := range s.Shares {
+				if share.Amount != tt.want[i] {
+					t.Errorf("share %d = %v, want %v", i, share.Amount, tt.want[i])
+				}
+				sum += toCents(share.Amount)
+			}
+			if sum != toCents(tt.total) {
+				t.Errorf("shares add up to %d cents, want %d", sum, toCents(tt.total))
+			}
+		})
+	}
+}
+
+func TestAllocateRejects(t *testing.T) {
+	ids := memberIDs(2)
+	tests := []struct {
+		name    string
+		sharing Sharing
+		total   float64
+	}{
+		{"no participants", Sharing{PaidBy: ids[0], Method: ShareEqually}, 10},
+		{"no payer", Sharing{Method: ShareEqually, Shares: []MemberShare{{UserId: ids[0]}}}, 10},
+		{"participant listed twice", Sharing{PaidBy: ids[0], Method: ShareEqually, Shares: []MemberShare{{UserId: ids[1]}, {UserId: ids[1]}}}, 10},
+		{"zero amount", Sharing{PaidBy: ids[0], Method: ShareEqually, Shares: []MemberShare{{UserId: ids[0]}}}, 0.004},
+		{"zero shares", Sharing{PaidBy: ids[0], Method: ShareByShare, Shares: []MemberShare{{UserId: ids[0], Value: 1}, {UserId: ids[1]}}}, 10},
+		{"percent short of 100", Sharing{PaidBy: ids[0], Method: ShareByPct, Shares: []MemberShare{{UserId: ids[0], Value: 50}, {UserId: ids[1], Value: 49}}}, 10},
+		{"exact short of the total", Sharing{PaidBy: ids[0], Method: ShareExactly, Shares: []MemberShare{{UserId: ids[0], Value: 5}, {UserId: ids[1], Value: 4.99}}}, 10},
+		{"exact negative", Sharing{PaidBy: ids[0], Method: ShareExactly, Shares: []MemberShare{{UserId: ids[0], Value: 15}, {UserId: ids[1], Value: -5}}}, 10},
+		{"unknown method", Sharing{PaidBy: ids[0], Method: "halves", Shares: []MemberShare{{UserId: ids[0]}}}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sharing.Allocate(tt.total); !errors.Is(err, ErrInvalidSharing) {
+				t.Errorf("Allocate = %v, want ErrInvalidSharing", err)
+			}
+		})
+	}
+}
+
+func TestBalances(t *testing.T) {
+	ids := memberIDs(3)
+	ada, bob, cy := ids[0], ids[1], ids[2]
+	deleted := time.Now()
+	txs := []Transaction{
+		// ada pays 30 for all three
+		{Amount: 30, Type: "expense", Sharing: &Sharing{PaidBy: ada, Shares: []MemberShare{{UserId: ada, Amount: 10}, {UserId: bob, Amount: 10}, {UserId: cy, Amount: 10}}}},
+		// bob pays 12 for himself and cy
+		{Amount: 12, Type: "expense", Sharing: &Sharing{PaidBy: bob, Shares: []MemberShare{{UserId: bob, Amount: 6}, {UserId: cy, Amount: 6}}}},
+		// cy pays ada back 5
+		{Amount: 5, Type: TypeSettlement, Sharing: &Sharing{PaidBy: cy, Shares: []MemberShare{{UserId: ada, Amount: 5}}}},
+		// neither trashed nor unshared transactions count
+		{Amount: 100, Type: "expense", DeletedAt: &deleted, Sharing: &Sharing{PaidBy: cy, Shares: []MemberShare{{UserId: ada, Amount: 100}}}},
+		{Amount: 50, Type: "expense"},
+	}
+
+	got := Balances(txs)
+	want := []MemberBalance{
+		{UserId: ada, Paid: 30, Owed: 15, Balance: 15},
+		{UserId: bob, Paid: 12, Owed: 16, Balance: -4},
+		{UserId: cy, Paid: 5, Owed: 16, Balance: -11},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Balances = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("balance %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// settles checks that payments bring every balance to zero.
+func settles(t *testing.T, balances []MemberBalance, payments []Payment) {
+	t.Helper()
+	left := map[primitive.ObjectID]int64{}
+	for _, b := range balances {
+		left[b.UserId] = toCents(b.Balance)
+	}
+	for _, p := range payments {
+		if p.Amount <= 0 || p.From == p.To {
+			t.Errorf("invalid payment %+v", p)
+		}
+		left[p.From] += toCents(p.Amount)
+		left[p.To] -= toCents(p.Amount)
+	}
+	for id, cents := range left {
+		if cents != 0 {
+			t.Errorf("%s is left with %d cents", id.Hex(), cents)
+		}
+	}
+}
+
+func balancesOf(cents ...int64) []MemberBalance {
+	ids := memberIDs(len(cents))
+	balances := make([]MemberBalance, len(cents))
+	for i, c := range cents {
+		balances[i] = MemberBalance{UserId: ids[i], Balance: float64(c) / 100}
+	}
+	return balances
+}
+
+func TestSettleUp(t *testing.T) {
+	tests := []struct {
+		name string
+		// balances in cents
+		cents []int64
+		want  int
+	}{
+		{"nothing open", []int64{0, 0}, 0},
+		{"one debt", []int64{500, -500}, 1},
+		{"one creditor", []int64{1500, -400, -1100}, 2},
+		{"two separate pairs", []int64{700, 300, -300, -700}, 2},
+		// paying the largest debt to the largest creditor takes 5 payments;
+		// settling 3 and -3 separately takes 4
+		{"beats greedy", []int64{600, 400, 300, -800, -300, -200}, 4},
+		{"no zero-sum subgroups", []int64{500, 500, -300, -700}, 3},
+		{"cents", []int64{1, 2, -3}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balances := balancesOf(tt.cents...)
+			payments := SettleUp(balances)
+			settles(t, balances, payments)
+			if len(payments) != tt.want {
+				t.Errorf("%d payments %+v, want %d", len(payments), payments, tt.want)
+			}
+		})
+	}
+}
+
+// fewestPayments is the minimum number of payments that settle cents, by
+// brute force over every way to split them into zero-sum groups.
+func fewestPayments(cents []int64) int {
+	var open []int64
+	for _, c := range cents {
+		if c != 0 {
+			open = append(open, c)
+		}
+	}
+	var best func(rest []int64) int
+	best = func(rest []int64) int {
+		if len(rest) == 0 {
+			return 0
+		}
+		// the first balance settles within some zero-sum group containing it
+		first, others := rest[0], rest[1:]
+		result := len(rest)
+		for mask := 0; mask < 1<<len(others); mask++ {
+			sum := first
+			var in, out []int64
+			for i, c := range others {
+				if mask&(1<<i) != 0 {
+					sum += c
+					in = append(in, c)
+				} else {
+					out = append(out, c)
+				}
+			}
+			if sum == 0 {
+				if n := len(in) + best(out); n < result {
+					result = n
+				}
+			}
+		}
+		return result
+	}
+	return best(open)
+}
+
+func TestSettleUpIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 300; round++ {
+		n := 2 + rng.Intn(7)
+		cents := make([]int64, n)
+		var sum int64
+		for i := 0; i < n-1; i++ {
+			cents[i] = int64(rng.Intn(19)-9) * 100
+			sum += cents[i]
+		}
+		cents[n-1] = -sum
+
+		balances := balancesOf(cents...)
+		payments := SettleUp(balances)
+		settles(t, balances, payments)
+		if want := fewestPayments(cents); len(payments) != want {
+			t.Fatalf("balances %v: %d payments, want %d", cents, len(payments), want)
+		}
+	}
+}
+
+func TestSettleUpLargeGroups(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, n := range []int{maxExactSettle, maxExactSettle + 5} {
+		cents := make([]int64, n)
+		var sum int64
+		for i := 0; i < n-1; i++ {
+			cents[i] = int64(rng.Intn(20001) - 10000)
+			sum += cents[i]
+		}
+		cents[n-1] = -sum
+
+		balances := balancesOf(cents...)
+		start := time.Now()
+		payments := SettleUp(balances)
+		settles(t, balances, payments)
+		if len(payments) > n-1 {
+			t.Errorf("%d balances took %d payments", n, len(payments))
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("settling %d balances took %v", n, elapsed)
+		}
+	}
+}
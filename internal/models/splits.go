@@ -0,0 +1,129 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// CategorySplit is the category of a transaction whose amount is divided
+// between split lines; the real categories are on the lines.
+const CategorySplit = "split"
+
+// Split is one line of a transaction spread over several categories, such
+// as the groceries and the household part of one receipt.
+type Split struct {
+	Category string  `bson:"category" json:"category"`
+	Amount   float64 `bson:"amount" json:"amount"`
+	Note     string  `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+var ErrInvalidSplits = errors.New("invalid split lines")
+
+// ValidateSplits checks that splits can divide amount: at least two lines,
+// each with a category and a positive amount, adding up to the total to the
+// cent. Categories are normalized in place the way category filters expect.
+func ValidateSplits(amount float64, splits []Split) error {
+	if len(splits) < 2 {
+		return fmt.Errorf("%w: at least two lines are required", ErrInvalidSplits)
+	}
+	var sum float64
+	for i := range splits {
+		splits[i].Category = strings.ToLower(strings.TrimSpace(splits[i].Category))
+		if splits[i].Category == "" {
+			return fmt.Errorf("%w: line %d has no category", ErrInvalidSplits, i+1)
+		}
+		if splits[i].Amount <= 0 {
+			return fmt.Errorf("%w: line %d must have a positive amount", ErrInvalidSplits, i+1)
+		}
+		sum += splits[i].Amount
+	}
+	if math.Abs(sum-amount) >= 0.005 {
+		return fmt.Errorf("%w: lines add up to %.2f, not %.2f", ErrInvalidSplits, sum, amount)
+	}
+	return nil
+}
+
+// Lines returns the split lines of t, or a single line covering the whole
+// amount when it isn't split. Reports use it so split amounts are
+// attributed to their own categories.
+func (t *Transaction) Lines() []Split {
+	if len(t.Splits) > 0 {
+		return t.Splits
+	}
+	return []Split{{Category: t.Category, Amount: t.Amount, Note: t.Note}}
+}
+
+// inCategories reports whether line is in one of the normalized categories;
+// every line is when there are none.
+func (line Split) inCategories(categories []string) bool {
+	if len(categories) == 0 {
+		return true
+	}
+	for _, cat := range categories {
+		if strings.ToLower(line.Category) == cat {
+			return true
+		}
+	}
+	return false
+}
+
+// amountIn sums the lines of t in categories, or all of t when categories is
+// empty. ok is false when no line matched.
+func (t *Transaction) amountIn(categories []string) (amount float64, ok bool) {
+	for _, line := range t.Lines() {
+		if line.inCategories(categories) {
+			amount += line.Amount
+			ok = true
+		}
+	}
+	return amount, ok
+}
+
+// CategoryTotal sums the income and expense lines of one category.
+type CategoryTotal struct {
+	Category string  `json:"category"`
+	Income   float64 `json:"income"`
+	Expense  float64 `json:"expense"`
+	Count    int     `json:"count"`
+}
+
+// SummarizeCategories totals txs per category, counting each split line
+// under its own category and, with categories, only lines in those. Transfers
+// are left out. The biggest expense categories come first.
+func SummarizeCategories(txs []Transaction, categories []string) []CategoryTotal {
+	categories = NormalizeCategories(categories)
+	index := map[string]int{}
+	totals := []CategoryTotal{}
+	for _, tx := range txs {
+		if tx.Type != "income" && tx.Type != "expense" {
+			continue
+		}
+		for _, line := range tx.Lines() {
+			if !line.inCategories(categories) {
+				continue
+			}
+			i, ok := index[line.Category]
+			if !ok {
+				i = len(totals)
+				index[line.Category] = i
+				totals = append(totals, CategoryTotal{Category: line.Category})
+			}
+			if tx.Type == "income" {
+				totals[i].Income += line.Amount
+			} else {
+				totals[i].Expense += line.Amount
+			}
+			totals[i].Count++
+		}
+	}
+	sort.SliceStable(totals, func(a, b int) bool {
+		if totals[a].Expense != totals[b].Expense {
+			return totals[a].Expense > totals[b].Expense
+		}
+		return totals[a].Category < totals[b].Category
+	})
+	return totals
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSplits(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  float64
+		splits  []Split
+		wantErr string
+	}{
+		{"two lines", 30, []Split{{Category: "groceries", Amount: 20}, {Category: "household", Amount: 10}}, ""},
+		{"cents add up", 10, []Split{{Category: "a", Amount: 3.33}, {Category: "b", Amount: 3.33}, {Category: "c", Amount: 3.34}}, ""},
+		{"float rounding within a cent", 0.3, []Split{{Category: "a", Amount: 0.1}, {Category: "b", Amount: 0.2}}, ""},
+		{"one line", 10, []Split{{Category: "a", Amount: 10}}, "at least two lines"},
+		{"no lines", 10, nil, "at least two lines"},
+		{"blank category", 10, []Split{{Category: "a", Amount: 5}, {Category: "  ", Amount: 5}}, "line 2 has no category"},
+		{"zero amount", 10, []Split{{Category: "a", Amount: 10}, {Category: "b", Amount: 0}}, "line 2 must have a positive amount"},
+		{"negative amount", 10, []Split{{Category: "a", Amount: -5}, {Category: "b", Amount: 15}}, "line 1 must have a positive amount"},
+		{"short by a cent", 10, []Split{{Category: "a", Amount: 5}, {Category: "b", Amount: 4.99}}, "add up to 9.99, not 10.00"},
+		{"over the total", 10, []Split{{Category: "a", Amount: 5}, {Category: "b", Amount: 6}}, "add up to 11.00, not 10.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSplits(tt.amount, tt.splits)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateSplits: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSplits) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateSplits: err = %v, want ErrInvalidSplits mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSplitsNormalizesCategories(t *testing.T) {
+	splits := []Split{{Category: " Groceries ", Amount: 6}, {Category: "HOUSEHOLD", Amount: 4}}
+	if err := ValidateSplits(10, splits); err != nil {
+		t.Fatal(err)
+	}
+	want := []Split{{Category: "groceries", Amount: 6}, {Category: "household", Amount: 4}}
+	if !reflect.DeepEqual(splits, want) {
+		t.Errorf("splits = %+v, want %+v", splits, want)
+	}
+}
+
+func TestSummarizeCategoriesCountsSplitLines(t *testing.T) {
+	txs := []Transaction{
+		{Type: "expense", Category: CategorySplit, Amount: 30, Splits: []Split{{Category: "groceries", Amount: 20}, {Category: "household", Amount: 10}}},
+		{Type: "expense", Category: "groceries", Amount: 5},
+		{Type: "income", Category: "salary", Amount: 100},
+		{Type: TypeTransfer, Category: "groceries", Amount: 50},
+	}
+
+	got := SummarizeCategories(txs, nil)
+	want := []CategoryTotal{
+		{Category: "groceries", Expense: 25, Count: 2},
+		{Category: "household", Expense: 10, Count: 1},
+		{Category: "salary", Income: 100, Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SummarizeCategories = %+v, want %+v", got, want)
+	}
+
+	got = SummarizeCategories(txs, []string{"Household"})
+	want = []CategoryTotal{{Category: "household", Expense: 10, Count: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SummarizeCategories(household) = %+v, want %+v", got, want)
+	}
+}
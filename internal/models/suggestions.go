@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/Joshua-takyi/expense/server/internal/classifier"
+)
+
+// CategoryModel is a user's category classifier, trained on their own
+// categorized transactions and kept up to date as they change.
+type CategoryModel struct {
+	UserId    primitive.ObjectID `bson:"user_id" json:"-"`
+	Model     classifier.Model   `bson:"model" json:"model"`
+	Version   int64              `bson:"version" json:"-"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// CategoryFeatures returns what the classifier learns from tx.
+func CategoryFeatures(tx *Transaction) []string {
+	return classifier.Features(tx.Description, tx.Note, tx.Amount, tx.Type)
+}
+
+// TrainingCategories returns the categories tx teaches the classifier: the
+// categories of its lines, none for transfers, settlements, trashed or
+// uncategorized transactions.
+func (t *Transaction) TrainingCategories() []string {
+	if t == nil || t.DeletedAt != nil || t.IsTransfer() || t.Type == TypeTransfer || t.Type == TypeSettlement {
+		return nil
+	}
+	var categories []string
+	seen := map[string]bool{}
+	for _, line := range t.Lines() {
+		if line.Category != "" && line.Category != CategorySplit && !seen[line.Category] {
+			seen[line.Category] = true
+			categories = append(categories, line.Category)
+		}
+	}
+	return categories
+}
+
+type SuggestionService interface {
+	GetCategoryModel(ctx context.Context, userID primitive.ObjectID) (*CategoryModel, error)
+	RebuildCategoryModel(ctx context.Context, userID primitive.ObjectID) (*CategoryModel, error)
+	UpdateCategoryModel(ctx context.Context, userID primitive.ObjectID, change func(m *classifier.Model)) error
+}
+
+// GetCategoryModel returns the user's model, or nil when none was built yet.
+func (r *Repository) GetCategoryModel(ctx context.Context, userID primitive.ObjectID) (*CategoryModel, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var model CategoryModel
+	err := r.DB.Database("expensetracker").Collection("category_models").FindOne(ctx, bson.M{"user_id": userID}).Decode(&model)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching category model: %w", err)
+	}
+	return &model, nil
+}
+
+// RebuildCategoryModel trains a new model from all of the user's live
+// transactions and replaces the stored one.
+func (r *Repository) RebuildCategoryModel(ctx context.Context, userID primitive.ObjectID) (*CategoryModel, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetProjection(bson.M{"description": 1, "note": 1, "amount": 1, "type": 1, "category": 1, "splits": 1, "linked_id": 1})
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Find(ctx, bson.M{"user_id": userID, "deleted_at": live}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transactions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	model := &CategoryModel{UserId: userID, UpdatedAt: time.Now()}
+	for cursor.Next(ctx) {
+		var tx Transaction
+		if err := cursor.Decode(&tx); err != nil {
+			return nil, fmt.Errorf("failed to decode transaction: %w", err)
+		}
+		features := CategoryFeatures(&tx)
+		for _, category := range tx.TrainingCategories() {
+			model.Model.Learn(category, features)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load transactions: %w", err)
+	}
+
+	update := bson.M{
+		"$set": bson.M{"model": model.Model, "updated_at": model.UpdatedAt},
+		"$inc": bson.M{"version": 1},
+	}
+	saveOpts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(bson.M{"version": 1})
+	var saved CategoryModel
+	if err := r.DB.Database("expensetracker").Collection("category_models").FindOneAndUpdate(ctx, bson.M{"user_id": userID}, update, saveOpts).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to save category model: %w", err)
+	}
+	model.Version = saved.Version
+	return model, nil
+}
+
+// UpdateCategoryModel applies change to the stored model. Concurrent updates
+// are retried against the latest version so no example is lost. Users
+// without a model are left alone; theirs is built from scratch on first use.
+func (r *Repository) UpdateCategoryModel(ctx context.Context, userID primitive.ObjectID, change func(m *classifier.Model)) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	collection := r.DB.Database("expensetracker").Collection("category_models")
+	for attempt := 0; attempt < 5; attempt++ {
+		model, err := r.GetCategoryModel(ctx, userID)
+		if err != nil || model == nil {
+			return err
+		}
+		change(&model.Model)
+
+		filter := bson.M{"user_id": userID, "version": model.Version}
+		update := bson.M{"$set": bson.M{"model": model.Model, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+		result, err := collection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to save category model: %w", err)
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+	}
+	return ErrVersionConflict
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+var (
+	ErrInvalidTag = errors.New("invalid tag")
+	tagPattern    = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_-]*$`)
+)
+
+// NormalizeTag lowercases tag and joins words with dashes, so "Trip Lisbon"
+// and "trip-lisbon" are the same label.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if tag == "" || len(tag) > maxTagLength || !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%w %q: use up to %d letters, digits, dashes or underscores", ErrInvalidTag, tag, maxTagLength)
+	}
+	return tag, nil
+}
+
+// NormalizeTags normalizes every tag and drops duplicates, keeping the order.
+func NormalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("%w: at most %d tags per transaction", ErrInvalidTag, maxTags)
+	}
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, t := range tags {
+		tag, err := NormalizeTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// TagFilter narrows a search by tags: transactions with any of Any, all of
+// All and none of None. Empty lists don't filter.
+type TagFilter struct {
+	Any  []string
+	All  []string
+	None []string
+}
+
+func (f TagFilter) filters() []bson.M {
+	var filters []bson.M
+	if len(f.Any) > 0 {
+		filters = append(filters, bson.M{"tags": bson.M{"$in": f.Any}})
+	}
+	if len(f.All) > 0 {
+		filters = append(filters, bson.M{"tags": bson.M{"$all": f.All}})
+	}
+	if len(f.None) > 0 {
+		filters = append(filters, bson.M{"tags": bson.M{"$nin": f.None}})
+	}
+	return filters
+}
+
+// TagCount is a tag with the number of live transactions carrying it.
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+type TagService interface {
+	ListTags(ctx context.Context, userID primitive.ObjectID, prefix string, limit int) ([]TagCount, error)
+	MergeTags(ctx context.Context, userID primitive.ObjectID, from []string, into string) ([]TransactionChange, error)
+}
+
+// ListTags returns the tags starting with prefix on the user's personal
+// transactions, most used first, for autocomplete. Ledger transactions are
+// left out like in MergeTags, so every tag listed can be renamed.
+func (r *Repository) ListTags(ctx context.Context, userID primitive.ObjectID, prefix string, limit int) ([]TagCount, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	match := bson.M{"user_id": userID, "ledger_id": nil, "deleted_at": live}
+	pipeline := []bson.M{{"$match": match}, {"$unwind": "$tags"}}
+	if prefix != "" {
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"tags": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}})
+	}
+	pipeline = append(pipeline,
+		bson.M{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	)
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	tags := []TagCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, fmt.Errorf("failed to decode tags: %w", err)
+	}
+	return tags, nil
+}
+
+// MergeTags replaces the tags in from with into on every personal transaction
+// of the user, including trashed ones, and returns the transactions it changed.
+// Renaming a tag is merging it into a new name. Tags are normalized by the caller.
+func (r *Repository) MergeTags(ctx context.Context, userID primitive.ObjectID, from []string, into string) ([]TransactionChange, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"user_id": userID, "ledger_id": nil, "tags": bson.M{"$in": from}}
+	// a pipeline update, so removing the old tags and adding the new one is a
+	// single write per transaction
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"tags":       bson.M{"$setUnion": bson.A{bson.M{"$setDifference": bson.A{"$tags", from}}, bson.A{into}}},
+		"updated_at": time.Now(),
+		"version":    bson.M{"$add": bson.A{"$version", 1}},
+	}}}}
+	var changes []TransactionChange
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		changes, err = updateTransactions(sc, r.DB.Database("expensetracker"), filter, update)
+		if err != nil {
+			return fmt.Errorf("failed to merge tags: %w", err)
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// TagTotal sums the transactions carrying one tag.
+type TagTotal struct {
+	Tag     string  `json:"tag"`
+	Income  float64 `json:"income"`
+	Expense float64 `json:"expense"`
+	Count   int     `json:"count"`
+}
+
+// SummarizeTags totals txs per tag. A transaction counts fully towards each of
+// its tags, so the totals of different tags can overlap. With categories only
+// the split lines in those categories count. Transfers are left out.
+func SummarizeTags(txs []Transaction, categories []string) []TagTotal {
+	categories = NormalizeCategories(categories)
+	index := map[string]int{}
+	totals := []TagTotal{}
+	for _, tx := range txs {
+		if tx.Type != "income" && tx.Type != "expense" {
+			continue
+		}
+		amount, ok := tx.amountIn(categories)
+		if !ok {
+			continue
+		}
+		for _, tag := range tx.Tags {
+			i, ok := index[tag]
+			if !ok {
+				i = len(totals)
+				index[tag] = i
+				totals = append(totals, TagTotal{Tag: tag})
+			}
+			if tx.Type == "income" {
+				totals[i].Income += amount
+			} else {
+				totals[i].Expense += amount
+			}
+			totals[i].Count++
+		}
+	}
+	sort.SliceStable(totals, func(a, b int) bool {
+		if totals[a].Expense != totals[b].Expense {
+			return totals[a].Expense > totals[b].Expense
+		}
+		return totals[a].Tag < totals[b].Tag
+	})
+	return totals
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginPolicy controls how failed logins are throttled. Backoff starts after
+// FreeAttempts failures and doubles with each further failure up to MaxDelay;
+// LockoutThreshold failures lock the key for LockoutDuration.
+type LoginPolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	// failures are forgotten after this much quiet time
+	Window time.Duration
+}
+
+var (
+	AccountLoginPolicy = LoginPolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  30 * time.Minute,
+		Window:           24 * time.Hour,
+	}
+	// a single IP may legitimately front many users (offices, NAT) so it gets
+	// more headroom before being blocked
+	IPLoginPolicy = LoginPolicy{
+		FreeAttempts:     10,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+		Window:           time.Hour,
+	}
+)
+
+// LoginThrottle holds the failed attempt state for one key, either an account
+// ("account:<email>") or a client ("ip:<address>").
+type LoginThrottle struct {
+	Key             string    `bson:"_id"`
+	Failures        int       `bson:"failures"`
+	LastFailure     time.Time `bson:"last_failure"`
+	LockedUntil     time.Time `bson:"locked_until,omitempty"`
+	UnlockTokenHash string    `bson:"unlock_token_hash,omitempty"`
+}
+
+// RetryAfter returns how long the key must wait before its next attempt.
+func (t *LoginThrottle) RetryAfter(policy LoginPolicy, now time.Time) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if now.Before(t.LockedUntil) {
+		return t.LockedUntil.Sub(now)
+	}
+	if now.Sub(t.LastFailure) > policy.Window || t.Failures <= policy.FreeAttempts {
+		return 0
+	}
+
+	exp := float64(t.Failures - policy.FreeAttempts - 1)
+	delay := time.Duration(float64(policy.BaseDelay) * math.Pow(2, exp))
+	if delay > policy.MaxDelay || delay <= 0 {
+		delay = policy.MaxDelay
+	}
+	if wait := t.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (t *LoginThrottle) Locked(now time.Time) bool {
+	return t != nil && now.Before(t.LockedUntil)
+}
+
+func AccountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPThrottleKey(ip string) string { return "ip:" + ip }
+
+type ThrottleService interface {
+	GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, policy LoginPolicy) (*LoginThrottle, error)
+	ClearLoginFailures(ctx context.Context, key string) error
+	SetUnlockToken(ctx context.Context, key, tokenHash string) error
+	UnlockAccount(ctx context.Context, tokenHash string) (bool, error)
+}
+
+func (r *Repository) GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var throttle LoginThrottle
+	err := r.DB.Database("expensetracker").Collection("login_throttles").FindOne(ctx, bson.M{"_id": key}).Decode(&throttle)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error fetching login throttle: %w", err)
+	}
+	return &throttle, nil
+}
+
+// RecordLoginFailure counts a failure for key and locks it once the policy's
+// threshold is reached. Failures older than the policy window start over.
+func (r *Repository) RecordLoginFailure(ctx context.Context, key string, policy LoginPolicy) (*LoginThrottle, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	now := time.Now()
+	collection := r.DB.Database("expensetracker").Collection("login_throttles")
+
+	// reset stale counters first so an old burst doesn't count towards a lockout
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": key, "last_failure": bson.M{"$lt": now.Add(-policy.Window)}, "locked_until": bson.M{"$not": bson.M{"$gt": now}}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error recording login failure: %w", err)
+	}
+
+	var throttle LoginThrottle
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err = collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last_failure": now}},
+		opts,
+	).Decode(&throttle)
+	if err != nil {
+		return nil, fmt.Errorf("error recording login failure: %w", err)
+	}
+
+	if throttle.Failures >= policy.LockoutThreshold && !throttle.Locked(now) {
+		throttle.LockedUntil = now.Add(policy.LockoutDuration)
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"locked_until": throttle.LockedUntil}})
+		if err != nil {
+			return nil, fmt.Errorf("error locking account: %w", err)
+		}
+	}
+	return &throttle, nil
+}
+
+func (r *Repository) ClearLoginFailures(ctx context.Context, key string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	if _, err := r.DB.Database("expensetracker").Collection("login_throttles").DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return fmt.Errorf("error clearing login failures: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) SetUnlockToken(ctx context.Context, key, tokenHash string) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"unlock_token_hash": tokenHash}}
+	if _, err := r.DB.Database("expensetracker").Collection("login_throttles").UpdateOne(ctx, bson.M{"_id": key}, update); err != nil {
+		return fmt.Errorf("error storing unlock token: %w", err)
+	}
+	return nil
+}
+
+// UnlockAccount clears the lockout matching the emailed unlock token.
+func (r *Repository) UnlockAccount(ctx context.Context, tokenHash string) (bool, error) {
+	if r.DB == nil {
+		return false, fmt.Errorf("database connection is not initialized")
+	}
+
+	result, err := r.DB.Database("expensetracker").Collection("login_throttles").DeleteOne(ctx, bson.M{"unlock_token_hash": tokenHash})
+	if err != nil {
+		return false, fmt.Errorf("error unlocking account: %w", err)
+	}
+	return result.DeletedCount == 1, nil
+}
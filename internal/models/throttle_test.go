@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleRetryAfter(t *testing.T) {
+	policy := LoginPolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  30 * time.Minute,
+		Window:           time.Hour,
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		throttle *LoginThrottle
+		want     time.Duration
+	}{
+		{"no state", nil, 0},
+		{"within free attempts", &LoginThrottle{Failures: 3, LastFailure: now}, 0},
+		{"first delayed attempt", &LoginThrottle{Failures: 4, LastFailure: now}, time.Second},
+		{"delay doubles", &LoginThrottle{Failures: 6, LastFailure: now}, 4 * time.Second},
+		{"part of the delay passed", &LoginThrottle{Failures: 6, LastFailure: now.Add(-3 * time.Second)}, time.Second},
+		{"delay passed", &LoginThrottle{Failures: 6, LastFailure: now.Add(-5 * time.Second)}, 0},
+		{"capped at max delay", &LoginThrottle{Failures: 10, LastFailure: now}, time.Minute},
+		{"huge failure count stays capped", &LoginThrottle{Failures: 5000, LastFailure: now}, time.Minute},
+		{"failures forgotten after the window", &LoginThrottle{Failures: 9, LastFailure: now.Add(-2 * time.Hour)}, 0},
+		{"locked", &LoginThrottle{Failures: 10, LastFailure: now, LockedUntil: now.Add(20 * time.Minute)}, 20 * time.Minute},
+		{"lock expired", &LoginThrottle{Failures: 3, LastFailure: now.Add(-time.Hour), LockedUntil: now.Add(-time.Minute)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.throttle.RetryAfter(policy, now); got != tt.want {
+				t.Errorf("RetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ScopeTransactionsRead  = "transactions:read"
+	ScopeTransactionsWrite = "transactions:write"
+	ScopeAdmin             = "admin"
+)
+
+// APIToken is a personal access token used by scripts and integrations. The
+// plaintext value is shown once at creation; only its hash is persisted.
+type APIToken struct {
+	Id         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name       string             `bson:"name" json:"name" validate:"required,max=100"`
+	TokenHash  string             `bson:"token_hash" json:"-"`
+	Hint       string             `bson:"hint" json:"hint"`
+	Scopes     []string           `bson:"scopes" json:"scopes" validate:"required,min=1,dive,oneof=transactions:read transactions:write admin"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+type TokenService interface {
+	CreateAPIToken(ctx context.Context, token *APIToken) error
+	ListAPITokens(ctx context.Context, userID primitive.ObjectID) ([]APIToken, error)
+	RevokeAPIToken(ctx context.Context, id, userID primitive.ObjectID) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	TouchAPIToken(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error
+}
+
+func (r *Repository) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if err := validate.Struct(token); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	token.Id = primitive.NewObjectID()
+	token.CreatedAt = time.Now()
+
+	_, err := r.DB.Database("expensetracker").Collection("api_tokens").InsertOne(ctx, token)
+	if err != nil {
+		return fmt.Errorf("error creating api token: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) ListAPITokens(ctx context.Context, userID primitive.ObjectID) ([]APIToken, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.DB.Database("expensetracker").Collection("api_tokens").Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []APIToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode api tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (r *Repository) RevokeAPIToken(ctx context.Context, id, userID primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	result, err := r.DB.Database("expensetracker").Collection("api_tokens").DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("error revoking api token: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("api token not found")
+	}
+	return nil
+}
+
+func (r *Repository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var token APIToken
+	err := r.DB.Database("expensetracker").Collection("api_tokens").FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("api token not found")
+		}
+		return nil, fmt.Errorf("error fetching api token: %w", err)
+	}
+	return &token, nil
+}
+
+// TouchAPIToken records usage at most once a minute so busy scripts don't
+// turn every request into a write.
+func (r *Repository) TouchAPIToken(ctx context.Context, id primitive.ObjectID, usedAt time.Time) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{
+		"_id": id,
+		"$or": []bson.M{
+			{"last_used_at": bson.M{"$exists": false}},
+			{"last_used_at": bson.M{"$lt": usedAt.Add(-time.Minute)}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"last_used_at": usedAt}}
+	if _, err := r.DB.Database("expensetracker").Collection("api_tokens").UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("error updating api token usage: %w", err)
+	}
+	return nil
+}
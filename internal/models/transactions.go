@@ -20,17 +20,69 @@ type Transaction struct {
 	Note        string             `bson:"note" json:"note"`
 	Category    string             `bson:"category" json:"category"`
 	UserId      primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
-	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	// the shared ledger that owns the transaction; UserId is then only who
+	// entered it. Personal transactions have none.
+	LedgerId *primitive.ObjectID `bson:"ledger_id,omitempty" json:"ledger_id,omitempty"`
+	// who paid for a shared expense and what each member owes for it
+	Sharing *Sharing `bson:"sharing,omitempty" json:"sharing,omitempty"`
+	// when set, the amount is divided between these lines and Category is
+	// CategorySplit, see ValidateSplits
+	Splits []Split `bson:"splits,omitempty" json:"splits,omitempty"`
+	// free-form labels across categories, see NormalizeTags
+	Tags []string `bson:"tags,omitempty" json:"tags,omitempty"`
+	// the merchant or person, matched from the description when not given
+	PayeeId *primitive.ObjectID `bson:"payee_id,omitempty" json:"payee_id,omitempty"`
+	// the account the money moved in or out of; required once the user has
+	// created accounts
+	AccountId *primitive.ObjectID `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	// set on both legs of a transfer: the other leg and whether money left
+	// (out) or arrived in (in) this leg's account
+	LinkedId  *primitive.ObjectID `bson:"linked_id,omitempty" json:"linked_id,omitempty"`
+	Direction string              `bson:"direction,omitempty" json:"direction,omitempty"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+	// incremented on every write, see ErrVersionConflict
+	Version int64 `bson:"version" json:"version"`
+	// set while the transaction is in the trash
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
 }
 
+// EditableFields returns the user-editable fields of t by stored name, as
+// accepted by UpdateTransaction.
+func (t *Transaction) EditableFields() map[string]interface{} {
+	return map[string]interface{}{
+		"amount":      t.Amount,
+		"type":        t.Type,
+		"description": t.Description,
+		"note":        t.Note,
+		"category":    t.Category,
+		"splits":      t.Splits,
+		"tags":        t.Tags,
+		"payee_id":    t.PayeeId,
+		"account_id":  t.AccountId,
+		"sharing":     t.Sharing,
+	}
+}
+
+// live and trashed match the deleted_at field of transactions outside and
+// inside the trash. Lists, searches and totals only ever see live ones.
+var (
+	live    = bson.M{"$exists": false}
+	trashed = bson.M{"$exists": true}
+)
+
 type TransactionService interface {
 	AddTransaction(ctx context.Context, tx *Transaction, userID primitive.ObjectID) error
-	UpdateTransaction(ctx context.Context, id primitive.ObjectID, updates map[string]interface{}) error
-	RemoveTransaction(ctx context.Context, id primitive.ObjectID) error
+	UpdateTransaction(ctx context.Context, id primitive.ObjectID, version int64, updates map[string]interface{}) (*Transaction, error)
+	RemoveTransaction(ctx context.Context, id primitive.ObjectID, version int64) (*Transaction, error)
+	RestoreTransaction(ctx context.Context, id primitive.ObjectID) (*Transaction, error)
+	PurgeTransaction(ctx context.Context, id primitive.ObjectID) error
+	ListDeletedTransactions(ctx context.Context, scope Scope, limit, offset int) ([]Transaction, error)
+	PurgeDeletedTransactions(ctx context.Context, deletedBefore time.Time) (int64, error)
 	GetTransactionDetails(ctx context.Context, id primitive.ObjectID) (*Transaction, error)
-	ListUserTransactions(ctx context.Context, userID primitive.ObjectID, limit, offset int) ([]Transaction, error)
-	GetTransactionByQuery(ctx context.Context, userID primitive.ObjectID, query string, category []string, order string, limit, offset int) ([]Transaction, error)
+	ListUserTransactions(ctx context.Context, scope Scope, limit, offset int) ([]Transaction, error)
+	ListTransactionsAfter(ctx context.Context, scope Scope, after primitive.ObjectID, limit int) ([]Transaction, error)
+	GetTransactionByQuery(ctx context.Context, scope Scope, query string, category []string, tags TagFilter, from, to time.Time, order string, limit, offset int) ([]Transaction, error)
 }
 
 func (r *Repository) AddTransaction(ctx context.Context, tx *Transaction, userID primitive.ObjectID) error {
@@ -40,51 +92,185 @@ func (r *Repository) AddTransaction(ctx context.Context, tx *Transaction, userID
 	if err := validate.Struct(tx); err != nil {
 		return fmt.Errorf("validation error: %v", err)
 	}
+	if len(tx.Splits) > 0 {
+		if err := ValidateSplits(tx.Amount, tx.Splits); err != nil {
+			return fmt.Errorf("validation error: %v", err)
+		}
+		tx.Category = CategorySplit
+	}
+	tags, err := NormalizeTags(tx.Tags)
+	if err != nil {
+		return fmt.Errorf("validation error: %v", err)
+	}
+	tx.Tags = tags
 
 	tx.UserId = userID
 	tx.CreatedAt = time.Now()
 	tx.UpdatedAt = time.Now()
 	tx.Id = primitive.NewObjectID()
+	tx.Version = 1
 
 	collection := r.DB.Database("expensetracker").Collection("transactions")
-	_, err := collection.InsertOne(ctx, tx)
+	_, err = collection.InsertOne(ctx, tx)
 	if err != nil {
 		return fmt.Errorf("failed to add transaction: %v", err)
 	}
 	return nil
 }
 
-func (r *Repository) UpdateTransaction(ctx context.Context, id primitive.ObjectID, updates map[string]interface{}) error {
+// UpdateTransaction applies updates only if the transaction is still at
+// version and returns the updated document, or ErrVersionConflict when
+// another write got there first.
+func (r *Repository) UpdateTransaction(ctx context.Context, id primitive.ObjectID, version int64, updates map[string]interface{}) (*Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
 	if len(updates) == 0 {
-		return fmt.Errorf("no updates provided")
+		return nil, fmt.Errorf("no updates provided")
 	}
 
 	// Add updated_at timestamp to the updates
 	updates["updated_at"] = time.Now()
 
-	filter := bson.M{"_id": id}
-	update := bson.M{"$set": updates}
+	filter := bson.M{"_id": id, "version": versionMatch(version), "deleted_at": live}
+	update := bson.M{"$set": updates, "$inc": bson.M{"version": 1}}
 
 	collection := r.DB.Database("expensetracker").Collection("transactions")
-	_, err := collection.UpdateOne(ctx, filter, update)
+	var tx Transaction
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&tx)
+	if err == mongo.ErrNoDocuments {
+		return nil, r.versionConflictOrMissing(ctx, id)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update transaction: %v", err)
+		return nil, fmt.Errorf("failed to update transaction: %v", err)
 	}
-	return nil
+	return &tx, nil
+}
+
+// RemoveTransaction moves the transaction to the trash, only if it is still
+// at version. Trashed transactions are purged after the trash retention.
+func (r *Repository) RemoveTransaction(ctx context.Context, id primitive.ObjectID, version int64) (*Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "version": versionMatch(version), "deleted_at": live}
+	update := bson.M{"$set": bson.M{"deleted_at": time.Now()}, "$inc": bson.M{"version": 1}}
+	collection := r.DB.Database("expensetracker").Collection("transactions")
+	var tx Transaction
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&tx)
+	if err == mongo.ErrNoDocuments {
+		return nil, r.versionConflictOrMissing(ctx, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove transaction: %v", err)
+	}
+	return &tx, nil
 }
 
-func (r *Repository) RemoveTransaction(ctx context.Context, id primitive.ObjectID) error {
+// RestoreTransaction takes a transaction back out of the trash.
+func (r *Repository) RestoreTransaction(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"_id": id, "deleted_at": trashed}
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}, "$set": bson.M{"updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
+	collection := r.DB.Database("expensetracker").Collection("transactions")
+	var tx Transaction
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&tx)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("transaction not found in trash")
+		}
+		return nil, fmt.Errorf("failed to restore transaction: %v", err)
+	}
+	return &tx, nil
+}
+
+// PurgeTransaction permanently deletes a transaction that is in the trash,
+// together with its history.
+func (r *Repository) PurgeTransaction(ctx context.Context, id primitive.ObjectID) error {
 	if r.DB == nil {
 		return fmt.Errorf("database connection is not initialized")
 	}
 
-	filter := bson.M{"_id": id}
+	filter := bson.M{"_id": id, "deleted_at": trashed}
+	result, err := r.DB.Database("expensetracker").Collection("transactions").DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to purge transaction: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("transaction not found in trash")
+	}
+	return r.deleteTransactionHistory(ctx, []primitive.ObjectID{id})
+}
+
+func (r *Repository) ListDeletedTransactions(ctx context.Context, scope Scope, limit, offset int) ([]Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := scope.filter()
+	filter["deleted_at"] = trashed
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted transactions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %v", err)
+	}
+	return transactions, nil
+}
+
+// PurgeDeletedTransactions permanently deletes everything trashed before
+// deletedBefore, returning how many transactions were removed.
+func (r *Repository) PurgeDeletedTransactions(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if r.DB == nil {
+		return 0, fmt.Errorf("database connection is not initialized")
+	}
+
 	collection := r.DB.Database("expensetracker").Collection("transactions")
-	_, err := collection.DeleteOne(ctx, filter)
+	filter := bson.M{"deleted_at": bson.M{"$lte": deletedBefore}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
 	if err != nil {
-		return fmt.Errorf("failed to remove transaction: %v", err)
+		return 0, fmt.Errorf("failed to find deleted transactions: %v", err)
 	}
-	return nil
+	var expired []Transaction
+	if err := cursor.All(ctx, &expired); err != nil {
+		return 0, fmt.Errorf("failed to decode transactions: %v", err)
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]primitive.ObjectID, len(expired))
+	for i, tx := range expired {
+		ids[i] = tx.Id
+	}
+	result, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": trashed})
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge deleted transactions: %v", err)
+	}
+	return result.DeletedCount, r.deleteTransactionHistory(ctx, ids)
+}
+
+// versionConflictOrMissing explains why a conditional write matched nothing.
+func (r *Repository) versionConflictOrMissing(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := r.GetTransactionDetails(ctx, id); err != nil {
+		return err
+	}
+	return ErrVersionConflict
 }
 
 func (r *Repository) GetTransactionDetails(ctx context.Context, id primitive.ObjectID) (*Transaction, error) {
@@ -104,12 +290,13 @@ func (r *Repository) GetTransactionDetails(ctx context.Context, id primitive.Obj
 	return &tx, nil
 }
 
-func (r *Repository) ListUserTransactions(ctx context.Context, userID primitive.ObjectID, limit, offset int) ([]Transaction, error) {
+func (r *Repository) ListUserTransactions(ctx context.Context, scope Scope, limit, offset int) ([]Transaction, error) {
 	if r.DB == nil {
 		return nil, fmt.Errorf("database connection is not initialized")
 	}
 
-	filter := bson.M{"user_id": userID}
+	filter := scope.filter()
+	filter["deleted_at"] = live
 	options := options.Find()
 	if limit > 0 {
 		options.SetLimit(int64(limit))
@@ -133,14 +320,59 @@ func (r *Repository) ListUserTransactions(ctx context.Context, userID primitive.
 	return transactions, nil
 }
 
-func (r *Repository) GetTransactionByQuery(ctx context.Context, userID primitive.ObjectID, query string, category []string, order string, limit, offset int) ([]Transaction, error) {
+// NormalizeCategories lowercases and trims category filter values, dropping
+// empty ones and "all".
+func NormalizeCategories(category []string) []string {
+	var lowerCategories []string
+	for _, cat := range category {
+		lowerCat := strings.ToLower(strings.TrimSpace(cat))
+		if lowerCat != "" && lowerCat != "all" {
+			lowerCategories = append(lowerCategories, lowerCat)
+		}
+	}
+	return lowerCategories
+}
+
+// ListTransactionsAfter returns up to limit live transactions in scope in id
+// order, starting after the transaction with id after (from the first with a
+// zero id). Unlike an offset, the position holds while transactions are
+// added or deleted, so callers walking all of them don't skip or repeat any.
+func (r *Repository) ListTransactionsAfter(ctx context.Context, scope Scope, after primitive.ObjectID, limit int) ([]Transaction, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := scope.filter()
+	filter["deleted_at"] = live
+	if !after.IsZero() {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list transactions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []Transaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %w", err)
+	}
+	return transactions, nil
+}
+
+// GetTransactionByQuery searches the transactions in scope. from and to bound
+// created_at as a half-open range [from, to); zero values leave it open.
+func (r *Repository) GetTransactionByQuery(ctx context.Context, scope Scope, query string, category []string, tags TagFilter, from, to time.Time, order string, limit, offset int) ([]Transaction, error) {
 	if r.DB == nil {
 		return nil, fmt.Errorf("database connection is not initialized")
 	}
 	order = strings.ToLower(order)
 
-	// Start with base filter for user
-	filters := []bson.M{{"user_id": userID}}
+	// Start with base filter for the user or ledger
+	base := scope.filter()
+	base["deleted_at"] = live
+	filters := []bson.M{base}
 
 	// Add search query filter if provided
 	if query != "" {
@@ -150,29 +382,35 @@ func (r *Repository) GetTransactionByQuery(ctx context.Context, userID primitive
 				{"note": bson.M{"$regex": query, "$options": "i"}},
 				{"type": bson.M{"$regex": query, "$options": "i"}},
 				{"category": bson.M{"$regex": query, "$options": "i"}},
+				{"splits.category": bson.M{"$regex": query, "$options": "i"}},
+				{"splits.note": bson.M{"$regex": query, "$options": "i"}},
+				{"tags": bson.M{"$regex": query, "$options": "i"}},
 			},
 		}
 		filters = append(filters, queryFilter)
 	}
 
-	// Add category filter
-	if len(category) > 0 {
-		// Convert categories to lowercase
-		var lowerCategories []string
-		for _, cat := range category {
-			lowerCat := strings.ToLower(strings.TrimSpace(cat))
-			if lowerCat != "" && lowerCat != "all" {
-				lowerCategories = append(lowerCategories, lowerCat)
-			}
-		}
+	// Add category filter, matching split lines as well
+	if lowerCategories := NormalizeCategories(category); len(lowerCategories) > 0 {
+		filters = append(filters, bson.M{"$or": []bson.M{
+			{"category": bson.M{"$in": lowerCategories}},
+			{"splits.category": bson.M{"$in": lowerCategories}},
+		}})
+	}
+
+	// Add tag filters
+	filters = append(filters, tags.filters()...)
 
-		if len(lowerCategories) > 0 {
-			if len(lowerCategories) == 1 {
-				filters = append(filters, bson.M{"category": lowerCategories[0]})
-			} else {
-				filters = append(filters, bson.M{"category": bson.M{"$in": lowerCategories}})
-			}
+	// Add date range filter
+	if !from.IsZero() || !to.IsZero() {
+		createdAt := bson.M{}
+		if !from.IsZero() {
+			createdAt["$gte"] = from
+		}
+		if !to.IsZero() {
+			createdAt["$lt"] = to
 		}
+		filters = append(filters, bson.M{"created_at": createdAt})
 	}
 
 	// Build the amount filter
@@ -0,0 +1,260 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A transfer moves money between two of the user's accounts. It is stored as
+// two transactions of type transfer, one leg per account, each pointing at
+// the other through LinkedId. Transfers change account balances but are
+// neither income nor expense, so reports leave them out.
+const (
+	TypeTransfer = "transfer"
+	TransferOut  = "out"
+	TransferIn   = "in"
+)
+
+var (
+	// ErrTransferSameAccount is returned when an edit would book both legs of
+	// a transfer to the same account.
+	ErrTransferSameAccount = errors.New("both legs of a transfer can't use the same account")
+	// ErrTransferCurrency is returned when an edit would move a leg to an
+	// account in another currency than the other leg's, since both legs
+	// always carry the same amount.
+	ErrTransferCurrency = errors.New("both accounts of a transfer must use the same currency")
+)
+
+// transferShared are the fields both legs of a transfer always agree on. The
+// account is the only thing that differs between them.
+var transferShared = []string{"amount", "description", "note", "category", "tags"}
+
+// PeerChange is what an operation on one leg of a transfer did to the other
+// leg, so callers can record its history too.
+type PeerChange struct {
+	Before *Transaction
+	After  *Transaction
+}
+
+type TransferService interface {
+	CreateTransfer(ctx context.Context, userID primitive.ObjectID, out, in *Transaction) error
+	UpdateTransfer(ctx context.Context, id primitive.ObjectID, version int64, updates map[string]interface{}) (*Transaction, *PeerChange, error)
+	RemoveTransfer(ctx context.Context, id primitive.ObjectID, version int64) (*Transaction, *PeerChange, error)
+	RestoreTransfer(ctx context.Context, id primitive.ObjectID) (*Transaction, *PeerChange, error)
+	PurgeTransfer(ctx context.Context, id primitive.ObjectID) error
+}
+
+// IsTransfer reports whether t is one leg of a transfer.
+func (t *Transaction) IsTransfer() bool {
+	return t.LinkedId != nil
+}
+
+// inTransaction runs fn in a multi-document transaction, retrying it on
+// transient errors. MongoDB must run as a replica set.
+func (r *Repository) inTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := r.DB.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// CreateTransfer stores out and in as the two legs of one transfer; either
+// both are written or neither is. The caller fills in the accounts and the
+// shared fields.
+func (r *Repository) CreateTransfer(ctx context.Context, userID primitive.ObjectID, out, in *Transaction) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	now := time.Now()
+	outID, inID := primitive.NewObjectID(), primitive.NewObjectID()
+	out.Id, out.Direction, out.LinkedId = outID, TransferOut, &inID
+	in.Id, in.Direction, in.LinkedId = inID, TransferIn, &outID
+	for _, leg := range []*Transaction{out, in} {
+		leg.UserId = userID
+		leg.Type = TypeTransfer
+		leg.CreatedAt = now
+		leg.UpdatedAt = now
+		leg.Version = 1
+	}
+
+	collection := r.DB.Database("expensetracker").Collection("transactions")
+	return r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		if _, err := collection.InsertMany(sc, []interface{}{out, in}); err != nil {
+			return fmt.Errorf("failed to add transfer: %v", err)
+		}
+		return nil
+	})
+}
+
+// updatePeer applies update to the other leg of a transfer. The user acted on
+// the leg they saw, so the other one is changed whatever its version.
+func (r *Repository) updatePeer(ctx context.Context, leg *Transaction, update bson.M) (*PeerChange, error) {
+	collection := r.DB.Database("expensetracker").Collection("transactions")
+	var before, after Transaction
+	if err := collection.FindOne(ctx, bson.M{"_id": leg.LinkedId}).Decode(&before); err != nil {
+		return nil, fmt.Errorf("failed to find the other leg of transfer: %v", err)
+	}
+
+	update["$inc"] = bson.M{"version": 1}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": before.Id}, update, opts).Decode(&after); err != nil {
+		return nil, fmt.Errorf("failed to update the other leg of transfer: %v", err)
+	}
+	return &PeerChange{Before: &before, After: &after}, nil
+}
+
+// UpdateTransfer is UpdateTransaction for a transfer leg: amount,
+// description, note, category and tags are copied to the other leg in the same
+// transaction, while an account change only applies to this one and must keep
+// both accounts in the same currency.
+func (r *Repository) UpdateTransfer(ctx context.Context, id primitive.ObjectID, version int64, updates map[string]interface{}) (*Transaction, *PeerChange, error) {
+	if r.DB == nil {
+		return nil, nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var leg *Transaction
+	var peer *PeerChange
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		leg, err = r.UpdateTransaction(sc, id, version, updates)
+		if err != nil {
+			return err
+		}
+
+		set := bson.M{}
+		for _, field := range transferShared {
+			if v, ok := updates[field]; ok {
+				set[field] = v
+			}
+		}
+		var other *Transaction
+		if len(set) == 0 {
+			peer = nil
+			if other, err = r.GetTransactionDetails(sc, *leg.LinkedId); err != nil {
+				return err
+			}
+		} else {
+			set["updated_at"] = leg.UpdatedAt
+			if peer, err = r.updatePeer(sc, leg, bson.M{"$set": set}); err != nil {
+				return err
+			}
+			other = peer.After
+		}
+		if leg.AccountId == nil || other.AccountId == nil {
+			return nil
+		}
+		if *leg.AccountId == *other.AccountId {
+			return ErrTransferSameAccount
+		}
+		if _, ok := updates["account_id"]; ok {
+			return r.sameCurrency(sc, leg.UserId, *leg.AccountId, *other.AccountId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return leg, peer, nil
+}
+
+// sameCurrency returns ErrTransferCurrency unless both accounts use the same
+// currency.
+func (r *Repository) sameCurrency(ctx context.Context, userID, a, b primitive.ObjectID) error {
+	from, err := r.GetAccount(ctx, a, userID)
+	if err != nil {
+		return err
+	}
+	to, err := r.GetAccount(ctx, b, userID)
+	if err != nil {
+		return err
+	}
+	if from.Currency != to.Currency {
+		return ErrTransferCurrency
+	}
+	return nil
+}
+
+// RemoveTransfer moves both legs of a transfer to the trash. They share the
+// same deleted_at so they are also purged together.
+func (r *Repository) RemoveTransfer(ctx context.Context, id primitive.ObjectID, version int64) (*Transaction, *PeerChange, error) {
+	if r.DB == nil {
+		return nil, nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var leg *Transaction
+	var peer *PeerChange
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		leg, err = r.RemoveTransaction(sc, id, version)
+		if err != nil {
+			return err
+		}
+		peer, err = r.updatePeer(sc, leg, bson.M{"$set": bson.M{"deleted_at": *leg.DeletedAt}})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return leg, peer, nil
+}
+
+// RestoreTransfer takes both legs of a transfer back out of the trash.
+func (r *Repository) RestoreTransfer(ctx context.Context, id primitive.ObjectID) (*Transaction, *PeerChange, error) {
+	if r.DB == nil {
+		return nil, nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var leg *Transaction
+	var peer *PeerChange
+	err := r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		var err error
+		leg, err = r.RestoreTransaction(sc, id)
+		if err != nil {
+			return err
+		}
+		peer, err = r.updatePeer(sc, leg, bson.M{"$unset": bson.M{"deleted_at": ""}, "$set": bson.M{"updated_at": leg.UpdatedAt}})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return leg, peer, nil
+}
+
+// PurgeTransfer permanently deletes both legs of a trashed transfer together
+// with their history.
+func (r *Repository) PurgeTransfer(ctx context.Context, id primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	collection := r.DB.Database("expensetracker").Collection("transactions")
+	return r.inTransaction(ctx, func(sc mongo.SessionContext) error {
+		var leg Transaction
+		if err := collection.FindOne(sc, bson.M{"_id": id, "deleted_at": trashed}).Decode(&leg); err != nil {
+			return fmt.Errorf("transaction not found in trash")
+		}
+		ids := []primitive.ObjectID{leg.Id}
+		if leg.LinkedId != nil {
+			ids = append(ids, *leg.LinkedId)
+		}
+		if _, err := collection.DeleteMany(sc, bson.M{"_id": bson.M{"$in": ids}, "deleted_at": trashed}); err != nil {
+			return fmt.Errorf("failed to purge transfer: %v", err)
+		}
+		return r.deleteTransactionHistory(sc, ids)
+	})
+}
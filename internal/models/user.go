@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -11,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type User struct {
@@ -18,18 +20,78 @@ type User struct {
 	Name      string             `bson:"name" json:"name" validate:"required"`
 	Email     string             `bson:"email" json:"email" validate:"required,email"`
 	Password  string             `bson:"password" json:"-"`
+	Role      string             `bson:"role,omitempty" json:"role"`
+	Disabled  bool               `bson:"disabled" json:"disabled"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	// incremented on every profile or account change, see ErrVersionConflict
+	Version int64 `bson:"version" json:"version"`
+
+	// sessions issued before this are rejected by the auth middleware
+	SessionsRevokedAt time.Time `bson:"sessions_revoked_at,omitempty" json:"-"`
+
+	// two-factor authentication
+	MFAEnabled        bool     `bson:"mfa_enabled" json:"mfa_enabled"`
+	TOTPSecret        string   `bson:"totp_secret,omitempty" json:"-"`
+	PendingTOTPSecret string   `bson:"pending_totp_secret,omitempty" json:"-"`
+	TOTPLastStep      int64    `bson:"totp_last_step,omitempty" json:"-"`
+	RecoveryCodes     []string `bson:"recovery_codes,omitempty" json:"-"`
+
+	// password resets
+	PasswordChangedAt    time.Time `bson:"password_changed_at,omitempty" json:"-"`
+	PasswordResetHash    string    `bson:"password_reset_hash,omitempty" json:"-"`
+	PasswordResetExpires time.Time `bson:"password_reset_expires,omitempty" json:"-"`
+	// set by an admin; login is refused until the password has been reset
+	PasswordResetRequired bool `bson:"password_reset_required,omitempty" json:"password_reset_required,omitempty"`
+
+	// accounts at external OpenID Connect providers linked to this user
+	Identities []ExternalIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+
+	// set while a requested account deletion is in its grace period
+	DeletionScheduledAt *time.Time `bson:"deletion_scheduled_at,omitempty" json:"deletion_scheduled_at,omitempty"`
+}
+
+type ExternalIdentity struct {
+	Issuer   string    `bson:"issuer" json:"issuer"`
+	Subject  string    `bson:"subject" json:"-"`
+	Email    string    `bson:"email" json:"email"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// IsAdmin treats accounts created before roles existed as regular users.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
 }
 
 var validate = validator.New()
 
+// ErrInvalidCredentials is returned by AuthenticateUser for both unknown
+// emails and wrong passwords so callers can't tell the two apart.
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// These are only returned once the password has been verified, so they
+// don't leak anything to someone guessing.
+var (
+	ErrAccountDisabled       = errors.New("account is disabled")
+	ErrPasswordResetRequired = errors.New("password reset required")
+)
+
+// dummyPasswordHash is compared against when the email is unknown so that a
+// miss costs the same bcrypt work as a wrong password.
+var dummyPasswordHash, _ = helpers.HashPassword("dummy-password-for-timing")
+
 type UserService interface {
 	RegisterUser(ctx context.Context, user *User) (*User, error)
 	AuthenticateUser(ctx context.Context, email, password string) (*User, error)
-	UpdateUserProfile(ctx context.Context, id primitive.ObjectID, user *User) error
+	UpdateUserProfile(ctx context.Context, id primitive.ObjectID, version int64, user *User) (int64, error)
 	DeleteUserAccount(ctx context.Context, id primitive.ObjectID) error
 	GetUserProfile(ctx context.Context, id primitive.ObjectID) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
 }
 
 type Repository struct {
@@ -38,6 +100,26 @@ type Repository struct {
 
 type Service interface {
 	UserService
+	MFAService
+	TokenService
+	ThrottleService
+	IdentityService
+	PasswordService
+	AdminService
+	AuditService
+	AccountService
+	PreferenceService
+	IdempotencyService
+	HistoryService
+	WalletService
+	TransferService
+	TagService
+	PayeeService
+	RuleService
+	SuggestionService
+	AttachmentService
+	LedgerService
+	SharingService
 	TransactionService
 }
 
@@ -72,6 +154,11 @@ func (r *Repository) RegisterUser(ctx context.Context, user *User) (*User, error
 	if err := validate.Struct(user); err != nil {
 		return nil, fmt.Errorf("validation error: %w", err)
 	}
+	// the password policy is enforced by the handler; this only guards the
+	// limits bcrypt itself can't handle
+	if user.Password == "" || len(user.Password) > 72 {
+		return nil, fmt.Errorf("validation error: password must be between 1 and 72 bytes")
+	}
 
 	hashedPassword, err := helpers.HashPassword(user.Password)
 	if err != nil {
@@ -80,6 +167,8 @@ func (r *Repository) RegisterUser(ctx context.Context, user *User) (*User, error
 
 	now := time.Now()
 	user.Id = primitive.NewObjectID()
+	user.Role = RoleUser
+	user.Version = 1
 	user.CreatedAt = now
 	user.UpdatedAt = now
 	user.Password = hashedPassword
@@ -104,14 +193,21 @@ func (r *Repository) AuthenticateUser(ctx context.Context, email, password strin
 	err := r.DB.Database("expensetracker").Collection("users").FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user with email %s not found", email)
+			helpers.CheckPasswordHash(password, dummyPasswordHash)
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("error fetching user: %w", err)
 	}
 
 	ok := helpers.CheckPasswordHash(password, user.Password)
 	if !ok {
-		return nil, fmt.Errorf("invalid password")
+		return nil, ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	if user.PasswordResetRequired {
+		return nil, ErrPasswordResetRequired
 	}
 
 	// Remove password before returning user
@@ -120,38 +216,116 @@ func (r *Repository) AuthenticateUser(ctx context.Context, email, password strin
 
 }
 
-func (r *Repository) UpdateUserProfile(ctx context.Context, id primitive.ObjectID, user *User) error {
+// UpdateUserProfile changes the editable profile fields if the user is still
+// at version, returning the new version or ErrVersionConflict. Credentials,
+// role and security settings have their own dedicated methods.
+func (r *Repository) UpdateUserProfile(ctx context.Context, id primitive.ObjectID, version int64, user *User) (int64, error) {
 
 	if r.DB == nil {
-		return fmt.Errorf("database connection is not initialized")
+		return 0, fmt.Errorf("database connection is not initialized")
 	}
-	filter := bson.M{"_id": id}
-	update := bson.M{"$set": user}
-	update["$set"].(bson.M)["updated_at"] = time.Now()
+	if err := validate.Var(user.Name, "required,max=100"); err != nil {
+		return 0, fmt.Errorf("validation error: %w", err)
+	}
+	filter := bson.M{"_id": id, "version": versionMatch(version)}
+	update := bson.M{"$set": bson.M{"name": user.Name, "updated_at": time.Now()}, "$inc": bson.M{"version": 1}}
 
-	result, err := r.DB.Database("expensetracker").Collection("users").UpdateOne(ctx, filter, update)
-	if err != nil {
-		return fmt.Errorf("error updating user profile: %w", err)
+	var updated User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"version": 1})
+	err := r.DB.Database("expensetracker").Collection("users").FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		if _, err := r.GetUserProfile(ctx, id); err != nil {
+			return 0, fmt.Errorf("no user found with id %s", id)
+		}
+		return 0, ErrVersionConflict
 	}
-	if result.MatchedCount == 0 {
-		return fmt.Errorf("no user found with id %s", id)
+	if err != nil {
+		return 0, fmt.Errorf("error updating user profile: %w", err)
 	}
-	return nil
+	return updated.Version, nil
+}
+
+// userOwnedCollections lists every collection holding records that belong to
+// a user through a user_id field. DeleteUserAccount and CollectUserData both
+// walk it, so new per-user collections must be added here.
+var userOwnedCollections = []string{
+	"transactions",
+	"api_tokens",
+	"data_exports",
+	"preferences",
+	"idempotency_keys",
+	"transaction_history",
+	"accounts",
+	"payees",
+	"rules",
+	"category_models",
+	"attachments",
 }
 
+// DeleteUserAccount hard-deletes the user and everything that belongs to them
+// in a single multi-document transaction, so a failure can't leave orphaned
+// records behind. Transactions the user entered into shared ledgers belong
+// to the ledger and stay, with their history and attachments; see
+// leaveLedgers. Files referenced by data exports and personal attachments
+// must be removed by the caller.
 func (r *Repository) DeleteUserAccount(ctx context.Context, id primitive.ObjectID) error {
 	if r.DB == nil {
 		return fmt.Errorf("database connection is not initialized")
 	}
-	filter := bson.M{"_id": id}
-	result, err := r.DB.Database("expensetracker").Collection("users").DeleteOne(ctx, filter)
+
+	user, err := r.GetUserProfile(ctx, id)
 	if err != nil {
-		return fmt.Errorf("error deleting user: %w", err)
-	}
-	if result.DeletedCount == 0 {
 		return fmt.Errorf("no user found with id %s", id)
 	}
-	return nil
+
+	session, err := r.DB.StartSession()
+	if err != nil {
+		return fmt.Errorf("error starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		db := r.DB.Database("expensetracker")
+		attachments, err := personalAttachments(sc, db, id)
+		if err != nil {
+			return nil, err
+		}
+		// records of ledger transactions are kept, everything else goes
+		filters := map[string]bson.M{
+			"transactions":        {"user_id": id, "ledger_id": nil},
+			"transaction_history": {"user_id": id, "snapshot.ledger_id": nil},
+			"attachments":         attachments,
+		}
+		for _, name := range userOwnedCollections {
+			filter, ok := filters[name]
+			if !ok {
+				filter = bson.M{"user_id": id}
+			}
+			if _, err := db.Collection(name).DeleteMany(sc, filter); err != nil {
+				return nil, fmt.Errorf("error deleting %s: %w", name, err)
+			}
+		}
+		// erasure takes precedence over the append-only audit log
+		if _, err := db.Collection("audit_events").DeleteMany(sc, bson.M{"$or": []bson.M{{"actor_id": id}, {"target_id": id}}}); err != nil {
+			return nil, fmt.Errorf("error deleting audit events: %w", err)
+		}
+		if _, err := db.Collection("login_throttles").DeleteOne(sc, bson.M{"_id": AccountThrottleKey(user.Email)}); err != nil {
+			return nil, fmt.Errorf("error deleting login throttle: %w", err)
+		}
+		if err := r.leaveLedgers(sc, id); err != nil {
+			return nil, err
+		}
+
+		result, err := db.Collection("users").DeleteOne(sc, bson.M{"_id": id})
+		if err != nil {
+			return nil, fmt.Errorf("error deleting user: %w", err)
+		}
+		if result.DeletedCount == 0 {
+			return nil, fmt.Errorf("no user found with id %s", id)
+		}
+		return nil, nil
+	})
+	return err
 }
 
 func (r *Repository) GetUserProfile(ctx context.Context, id primitive.ObjectID) (*User, error) {
@@ -173,3 +347,23 @@ func (r *Repository) GetUserProfile(ctx context.Context, id primitive.ObjectID)
 	user.Password = ""
 	return &user, nil
 }
+
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	user := User{}
+	filter := bson.M{"email": email}
+	err := r.DB.Database("expensetracker").Collection("users").FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("user with email %s not found", email)
+		}
+		return nil, fmt.Errorf("error fetching user: %w", err)
+	}
+
+	// Remove password before returning user
+	user.Password = ""
+	return &user, nil
+}
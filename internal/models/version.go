@@ -0,0 +1,20 @@
+package models
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrVersionConflict is returned by conditional writes when the document was
+// changed since the caller read it.
+var ErrVersionConflict = errors.New("resource was modified by another request")
+
+// versionMatch filters on the version the caller last saw. Documents written
+// before versioning have no version field and count as version 0.
+func versionMatch(version int64) bson.M {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return bson.M{"$eq": version}
+}
@@ -0,0 +1,337 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AccountCash     = "cash"
+	AccountChecking = "checking"
+	AccountCredit   = "credit"
+	AccountSavings  = "savings"
+)
+
+// Account is somewhere money is held, such as a wallet, a bank account or a
+// credit card. Its balance is the opening balance plus income minus expenses
+// of the transactions booked to it, plus transfers in minus transfers out; a
+// credit card therefore usually has a negative balance.
+type Account struct {
+	Id             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserId         primitive.ObjectID `bson:"user_id" json:"-"`
+	Name           string             `bson:"name" json:"name" validate:"required,max=100"`
+	Kind           string             `bson:"kind" json:"kind" validate:"oneof=cash checking credit savings"`
+	Currency       string             `bson:"currency" json:"currency" validate:"iso4217"`
+	OpeningBalance float64            `bson:"opening_balance" json:"opening_balance"`
+	Archived       bool               `bson:"archived" json:"archived"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// filled in by the balance queries, not stored
+	Balance *float64 `bson:"-" json:"balance,omitempty"`
+}
+
+// RunningBalance is a transaction with the account balance right after it.
+type RunningBalance struct {
+	Transaction `bson:",inline"`
+	Balance     float64 `json:"balance"`
+}
+
+type WalletService interface {
+	CreateAccount(ctx context.Context, account *Account) error
+	GetAccount(ctx context.Context, id, userID primitive.ObjectID) (*Account, error)
+	ListAccounts(ctx context.Context, userID primitive.ObjectID, includeArchived bool) ([]Account, error)
+	UpdateAccount(ctx context.Context, id, userID primitive.ObjectID, updates map[string]interface{}) (*Account, error)
+	DeleteAccount(ctx context.Context, id, userID primitive.ObjectID) error
+	CountAccounts(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	AccountBalance(ctx context.Context, account *Account, asOf time.Time) (float64, error)
+	AccountBalances(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]float64, error)
+	ListAccountTransactions(ctx context.Context, account *Account, limit, offset int) ([]RunningBalance, error)
+}
+
+func (r *Repository) CreateAccount(ctx context.Context, account *Account) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+	if err := validate.Struct(account); err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	now := time.Now()
+	account.Id = primitive.NewObjectID()
+	account.CreatedAt = now
+	account.UpdatedAt = now
+	if _, err := r.DB.Database("expensetracker").Collection("accounts").InsertOne(ctx, account); err != nil {
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) GetAccount(ctx context.Context, id, userID primitive.ObjectID) (*Account, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	var account Account
+	err := r.DB.Database("expensetracker").Collection("accounts").FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("account not found")
+		}
+		return nil, fmt.Errorf("error fetching account: %w", err)
+	}
+	return &account, nil
+}
+
+func (r *Repository) ListAccounts(ctx context.Context, userID primitive.ObjectID, includeArchived bool) ([]Account, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"user_id": userID}
+	if !includeArchived {
+		filter["archived"] = false
+	}
+	cursor, err := r.DB.Database("expensetracker").Collection("accounts").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	accounts := []Account{}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to decode accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// UpdateAccount sets the given fields, which the caller has validated. The
+// currency of an account with transactions can't change, since their amounts
+// are in it; the caller should leave out an unchanged currency.
+func (r *Repository) UpdateAccount(ctx context.Context, id, userID primitive.ObjectID, updates map[string]interface{}) (*Account, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+	if _, ok := updates["currency"]; ok {
+		inUse, err := r.accountInUse(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			return nil, ErrAccountInUse
+		}
+	}
+
+	updates["updated_at"] = time.Now()
+	var account Account
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.DB.Database("expensetracker").Collection("accounts").FindOneAndUpdate(ctx, bson.M{"_id": id, "user_id": userID}, bson.M{"$set": updates}, opts).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("account not found")
+		}
+		return nil, fmt.Errorf("failed to update account: %w", err)
+	}
+	return &account, nil
+}
+
+// ErrAccountInUse is returned when deleting an account that still has
+// transactions, or changing its currency; archive it instead.
+var ErrAccountInUse = errors.New("account has transactions")
+
+// accountInUse reports whether any transaction, transfer legs included, is
+// booked to the account.
+func (r *Repository) accountInUse(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	n, err := r.DB.Database("expensetracker").Collection("transactions").CountDocuments(ctx, bson.M{"account_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("failed to check account transactions: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (r *Repository) DeleteAccount(ctx context.Context, id, userID primitive.ObjectID) error {
+	if r.DB == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	inUse, err := r.accountInUse(ctx, id)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrAccountInUse
+	}
+
+	result, err := r.DB.Database("expensetracker").Collection("accounts").DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("account not found")
+	}
+	return nil
+}
+
+func (r *Repository) CountAccounts(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	if r.DB == nil {
+		return 0, fmt.Errorf("database connection is not initialized")
+	}
+
+	n, err := r.DB.Database("expensetracker").Collection("accounts").CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count accounts: %w", err)
+	}
+	return n, nil
+}
+
+// signedAmount adds income and incoming transfers and subtracts expenses and
+// outgoing transfers in an aggregation.
+var signedAmount = bson.M{"$switch": bson.M{
+	"branches": bson.A{
+		bson.M{"case": bson.M{"$eq": bson.A{"$type", "income"}}, "then": "$amount"},
+		bson.M{"case": bson.M{"$eq": bson.A{"$type", "expense"}}, "then": bson.M{"$multiply": bson.A{"$amount", -1}}},
+		bson.M{"case": bson.M{"$eq": bson.A{"$direction", TransferIn}}, "then": "$amount"},
+		bson.M{"case": bson.M{"$eq": bson.A{"$direction", TransferOut}}, "then": bson.M{"$multiply": bson.A{"$amount", -1}}},
+	},
+	"default": 0,
+}}
+
+func signed(tx *Transaction) float64 {
+	switch {
+	case tx.Type == "income", tx.Direction == TransferIn:
+		return tx.Amount
+	case tx.Type == "expense", tx.Direction == TransferOut:
+		return -tx.Amount
+	}
+	return 0
+}
+
+// sumTransactions totals the signed amounts of live transactions matching
+// filter, served by the account_id/created_at index.
+func (r *Repository) sumTransactions(ctx context.Context, filter bson.M) (float64, error) {
+	filter["deleted_at"] = live
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Aggregate(ctx, []bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": signedAmount}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute balance: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Total float64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return 0, fmt.Errorf("failed to decode balance: %w", err)
+	}
+	if len(totals) == 0 {
+		return 0, nil
+	}
+	return totals[0].Total, nil
+}
+
+// AccountBalance returns the balance including every transaction before asOf.
+func (r *Repository) AccountBalance(ctx context.Context, account *Account, asOf time.Time) (float64, error) {
+	if r.DB == nil {
+		return 0, fmt.Errorf("database connection is not initialized")
+	}
+
+	total, err := r.sumTransactions(ctx, bson.M{"account_id": account.Id, "created_at": bson.M{"$lt": asOf}})
+	if err != nil {
+		return 0, err
+	}
+	return account.OpeningBalance + total, nil
+}
+
+// AccountBalances returns the current balance of each of the accounts in a
+// single aggregation, excluding opening balances. Like AccountBalance it
+// counts every transaction booked to an account, whoever's ledger it is in.
+func (r *Repository) AccountBalances(ctx context.Context, ids []primitive.ObjectID) (map[primitive.ObjectID]float64, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+	if len(ids) == 0 {
+		return map[primitive.ObjectID]float64{}, nil
+	}
+
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"account_id": bson.M{"$in": ids}, "deleted_at": live}},
+		{"$group": bson.M{"_id": "$account_id", "total": bson.M{"$sum": signedAmount}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute balances: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		AccountId primitive.ObjectID `bson:"_id"`
+		Total     float64            `bson:"total"`
+	}
+	if err := cursor.All(ctx, &totals); err != nil {
+		return nil, fmt.Errorf("failed to decode balances: %w", err)
+	}
+	balances := make(map[primitive.ObjectID]float64, len(totals))
+	for _, t := range totals {
+		balances[t.AccountId] = t.Total
+	}
+	return balances, nil
+}
+
+// ListAccountTransactions returns a page of the account's transactions, newest
+// first, each with the balance right after it. Only one aggregation is needed
+// per page: the balance at the top of the page is summed in the database and
+// the rest is derived by walking down the page.
+func (r *Repository) ListAccountTransactions(ctx context.Context, account *Account, limit, offset int) ([]RunningBalance, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	filter := bson.M{"account_id": account.Id, "deleted_at": live}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	if offset > 0 {
+		opts.SetSkip(int64(offset))
+	}
+	cursor, err := r.DB.Database("expensetracker").Collection("transactions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list account transactions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var transactions []Transaction
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to decode transactions: %w", err)
+	}
+	rows := make([]RunningBalance, len(transactions))
+	if len(transactions) == 0 {
+		return rows, nil
+	}
+
+	// everything up to and including the newest transaction on the page, in
+	// the same (created_at, _id) order used for sorting
+	top := transactions[0]
+	upTo, err := r.sumTransactions(ctx, bson.M{"account_id": account.Id, "$or": []bson.M{
+		{"created_at": bson.M{"$lt": top.CreatedAt}},
+		{"created_at": top.CreatedAt, "_id": bson.M{"$lte": top.Id}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	balance := account.OpeningBalance + upTo
+	for i := range transactions {
+		rows[i] = RunningBalance{Transaction: transactions[i], Balance: balance}
+		balance -= signed(&transactions[i])
+	}
+	return rows, nil
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// ConfigFromEnv reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and
+// OIDC_REDIRECT_URL. It reports false when single sign-on isn't configured.
+func ConfigFromEnv() (Config, bool) {
+	config := Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+	}
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return config, false
+	}
+	return config, true
+}
+
+// Client discovers the provider on first use so the API can start while the
+// identity provider is unreachable.
+type Client struct {
+	config   Config
+	mu       sync.Mutex
+	provider *Provider
+}
+
+func NewClient(config Config) *Client {
+	return &Client{config: config}
+}
+
+func (c *Client) Provider(ctx context.Context) (*Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider != nil {
+		return c.provider, nil
+	}
+	provider, err := Discover(ctx, c.config)
+	if err != nil {
+		return nil, err
+	}
+	c.provider = provider
+	return provider, nil
+}
@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// IDToken holds the claims this application uses from a verified ID token.
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token (OpenID Connect Core 3.1.3.7).
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if !claims.VerifyIssuer(p.discovery.Issuer, true) {
+		return nil, fmt.Errorf("id token issuer mismatch")
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, fmt.Errorf("id token audience mismatch")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, fmt.Errorf("id token authorized party mismatch")
+	}
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("id token nonce mismatch")
+	}
+
+	idToken := &IDToken{Issuer: p.discovery.Issuer}
+	idToken.Subject, _ = claims["sub"].(string)
+	idToken.Email, _ = claims["email"].(string)
+	idToken.Name, _ = claims["name"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		idToken.EmailVerified = v
+	case string:
+		// some providers send the claim as a string
+		idToken.EmailVerified = v == "true"
+	}
+	if idToken.Subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+	return idToken, nil
+}
+
+// CodeChallenge derives the S256 PKCE challenge for verifier (RFC 7636).
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,179 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/Joshua-takyi/expense/server/internal/oidc"
+	"github.com/Joshua-takyi/expense/server/internal/oidc/oidctest"
+)
+
+const (
+	clientID    = "expense"
+	redirectURL = "http://app.test/callback"
+)
+
+func startProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	mock, err := oidctest.New("", "dev@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock.Handler())
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+
+	provider, err := oidc.Discover(context.Background(), oidc.Config{Issuer: server.URL, ClientID: clientID, RedirectURL: redirectURL})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return mock, provider
+}
+
+// authorize runs the authorization request and returns the issued code.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier)) + "&login_hint=ada@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("state = %q, want %q", got, state)
+	}
+	return location.Query().Get("code")
+}
+
+func TestCodeFlowWithPKCE(t *testing.T) {
+	_, provider := startProvider(t)
+	ctx := context.Background()
+
+	code := authorize(t, provider, "state-1", "nonce-1", "verifier-1")
+	raw, err := provider.Exchange(ctx, code, "verifier-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	idToken, err := provider.VerifyIDToken(ctx, raw, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if idToken.Email != "ada@example.com" || !idToken.EmailVerified || idToken.Subject == "" || idToken.Issuer != provider.Issuer() {
+		t.Errorf("unexpected id token %+v", idToken)
+	}
+
+	if _, err := provider.Exchange(ctx, code, "verifier-1"); err == nil {
+		t.Error("a code was redeemed twice")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	_, provider := startProvider(t)
+
+	code := authorize(t, provider, "state", "nonce", "the-real-verifier")
+	if _, err := provider.Exchange(context.Background(), code, "someone-elses-verifier"); err == nil || !strings.Contains(err.Error(), "pkce") {
+		t.Errorf("Exchange with the wrong verifier: err = %v, want a pkce failure", err)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	got := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge = %s, want %s", got, want)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mock, provider := startProvider(t)
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            mock.Issuer,
+			"sub":            "subject",
+			"aud":            clientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+			"nonce":          "nonce",
+			"email":          "ada@example.com",
+			"email_verified": "true",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(jwt.MapClaims)
+		nonce   string
+		wantErr string
+	}{
+		{"valid", func(jwt.MapClaims) {}, "nonce", ""},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, "nonce", "expired"},
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, "nonce", "issuer"},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "another-client" }, "nonce", "audience"},
+		{"other authorized party", func(c jwt.MapClaims) { c["azp"] = "another-client" }, "nonce", "authorized party"},
+		{"nonce mismatch", func(jwt.MapClaims) {}, "other-nonce", "nonce"},
+		{"no nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, "nonce", "nonce"},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, "nonce", "subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.edit(claims)
+			raw, err := mock.SignIDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			idToken, err := provider.VerifyIDToken(context.Background(), raw, tt.nonce)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyIDToken: %v", err)
+				}
+				if !idToken.EmailVerified {
+					t.Error("email_verified sent as a string wasn't accepted")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyIDToken: err = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherKeys(t *testing.T) {
+	mock, provider := startProvider(t)
+	claims := jwt.MapClaims{"iss": mock.Issuer, "sub": "s", "aud": clientID, "exp": time.Now().Add(time.Minute).Unix(), "nonce": "n"}
+
+	// an HMAC token signed with something public must never verify
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), hmac, "n"); err == nil {
+		t.Error("an HS256 token was accepted")
+	}
+
+	// a token signed by another provider's key
+	other, err := oidctest.New(mock.Issuer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := other.SignIDToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), forged, "n"); err == nil {
+		t.Error("a token signed with another key was accepted")
+	}
+}
@@ -0,0 +1,184 @@
+// Package oidctest is a minimal OpenID Connect provider for developing and
+// testing single sign-on. It signs in every authorization request as the
+// user given by the login_hint parameter (or DefaultEmail) without prompting.
+// Never expose it outside a development machine or a test.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const KeyID = "mock-key"
+
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	email         string
+	expires       time.Time
+}
+
+// Provider serves discovery, JWKS, authorization and token endpoints for
+// Issuer, which must be set before the first request.
+type Provider struct {
+	Issuer       string
+	DefaultEmail string
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+func New(issuer, defaultEmail string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{Issuer: issuer, DefaultEmail: defaultEmail, key: key, codes: map[string]authRequest{}}, nil
+}
+
+// SignIDToken signs claims with the provider's key, so tests can build ID
+// tokens the token endpoint would never issue.
+func (p *Provider) SignIDToken(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KeyID
+	return token.SignedString(p.key)
+}
+
+func (p *Provider) Handler() http.Handler {
+	r := gin.New()
+
+	r.GET("/.well-known/openid-configuration", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"issuer":                                p.Issuer,
+			"authorization_endpoint":                p.Issuer + "/authorize",
+			"token_endpoint":                        p.Issuer + "/token",
+			"jwks_uri":                              p.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+
+	r.GET("/jwks", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"keys": []gin.H{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": KeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+
+	r.GET("/authorize", func(c *gin.Context) {
+		if c.Query("response_type") != "code" || c.Query("code_challenge_method") != "S256" || c.Query("code_challenge") == "" {
+			c.String(http.StatusBadRequest, "expected response_type=code with an S256 code_challenge")
+			return
+		}
+		redirectURI, err := url.Parse(c.Query("redirect_uri"))
+		if err != nil || redirectURI.Scheme == "" {
+			c.String(http.StatusBadRequest, "invalid redirect_uri")
+			return
+		}
+
+		email := c.Query("login_hint")
+		if email == "" {
+			email = p.DefaultEmail
+		}
+
+		code := randomString()
+		p.mu.Lock()
+		p.codes[code] = authRequest{
+			clientID:      c.Query("client_id"),
+			redirectURI:   c.Query("redirect_uri"),
+			nonce:         c.Query("nonce"),
+			codeChallenge: c.Query("code_challenge"),
+			email:         email,
+			expires:       time.Now().Add(time.Minute),
+		}
+		p.mu.Unlock()
+
+		q := redirectURI.Query()
+		q.Set("code", code)
+		q.Set("state", c.Query("state"))
+		redirectURI.RawQuery = q.Encode()
+		c.Redirect(http.StatusFound, redirectURI.String())
+	})
+
+	r.POST("/token", func(c *gin.Context) {
+		code := c.PostForm("code")
+		p.mu.Lock()
+		req, ok := p.codes[code]
+		delete(p.codes, code)
+		p.mu.Unlock()
+
+		clientID := c.PostForm("client_id")
+		if user, _, hasBasic := c.Request.BasicAuth(); hasBasic {
+			clientID, _ = url.QueryUnescape(user)
+		}
+
+		sum := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+		switch {
+		case !ok || time.Now().After(req.expires):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "unknown or expired code"})
+			return
+		case c.PostForm("redirect_uri") != req.redirectURI || clientID != req.clientID:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "client or redirect_uri mismatch"})
+			return
+		case base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "pkce verification failed"})
+			return
+		}
+
+		subject := sha256.Sum256([]byte(strings.ToLower(req.email)))
+		now := time.Now()
+		idToken, err := p.SignIDToken(jwt.MapClaims{
+			"iss":            p.Issuer,
+			"sub":            hex.EncodeToString(subject[:8]),
+			"aud":            req.clientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"nonce":          req.nonce,
+			"email":          req.email,
+			"email_verified": true,
+			"name":           strings.Split(req.email, "@")[0],
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": randomString(),
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idToken,
+		})
+	})
+
+	return r
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
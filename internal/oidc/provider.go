@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config identifies this application to the identity provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type discoveryDocument struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Provider is an OpenID Connect relying party for a single issuer.
+type Provider struct {
+	config    Config
+	discovery discoveryDocument
+	client    *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+// Discover loads the issuer's metadata from its well-known configuration.
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+	if len(p.config.Scopes) == 0 {
+		p.config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &p.discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	// the issuer in the document must be exactly the one we were configured
+	// with, otherwise ID token issuer checks would be meaningless
+	if strings.TrimSuffix(p.discovery.Issuer, "/") != strings.TrimSuffix(config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery issuer mismatch: got %q", p.discovery.Issuer)
+	}
+	if p.discovery.AuthorizationEndpoint == "" || p.discovery.TokenEndpoint == "" || p.discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document is missing endpoints")
+	}
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.discovery.Issuer
+}
+
+// AuthCodeURL builds the authorization request for the code flow with PKCE.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + params.Encode()
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("token request rejected: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return token.IDToken, nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// publicKey returns the signing key for kid, refetching the JWKS when the
+// provider has rotated keys. Refetches are limited to once a minute.
+func (p *Provider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	p.keysFetched = time.Now()
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := rsaKey(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	p.keys = keys
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func rsaKey(k jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid rsa exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,68 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachList checks passwords against a local copy of a breached password
+// corpus laid out for k-anonymity lookups: one file per 5 character SHA-1
+// prefix (e.g. "21BD1"), each line holding the remaining 35 hex characters
+// and an optional ":count", the format served by the Pwned Passwords range
+// API. Only the prefix file for the candidate is ever read.
+type BreachList struct {
+	Dir string
+}
+
+// BreachListFromEnv returns nil when BREACHED_PASSWORDS_DIR isn't set, which
+// disables the check.
+func BreachListFromEnv() *BreachList {
+	dir := os.Getenv("BREACHED_PASSWORDS_DIR")
+	if dir == "" {
+		return nil
+	}
+	return &BreachList{Dir: dir}
+}
+
+// Contains reports whether password appears in the list. A missing prefix
+// file means no breached password shares the prefix.
+func (b *BreachList) Contains(password string) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(b.Dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = os.Open(filepath.Join(b.Dir, prefix+".txt"))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open breach list: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breach list: %w", err)
+	}
+	return false, nil
+}
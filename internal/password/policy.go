@@ -0,0 +1,131 @@
+package password
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything after the first 72 bytes, so longer passwords
+// would silently be truncated.
+const bcryptMaxBytes = 72
+
+const specialCharacters = "!@#$%^&*()-_=+[]{}|;:',.<>?/`~\"\\"
+
+// Policy describes what a new password must look like.
+type Policy struct {
+	MinLength      int
+	MaxBytes       int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+}
+
+var DefaultPolicy = Policy{
+	MinLength:      8,
+	MaxBytes:       bcryptMaxBytes,
+	RequireUpper:   true,
+	RequireLower:   true,
+	RequireDigit:   true,
+	RequireSpecial: true,
+}
+
+// PolicyFromEnv applies PASSWORD_MIN_LENGTH and PASSWORD_REQUIRE_UPPER,
+// _LOWER, _DIGIT and _SPECIAL ("true"/"false") on top of DefaultPolicy.
+func PolicyFromEnv() Policy {
+	policy := DefaultPolicy
+	if n, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && n > 0 {
+		policy.MinLength = n
+	}
+	envBool := func(name string, target *bool) {
+		if v, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+			*target = v
+		}
+	}
+	envBool("PASSWORD_REQUIRE_UPPER", &policy.RequireUpper)
+	envBool("PASSWORD_REQUIRE_LOWER", &policy.RequireLower)
+	envBool("PASSWORD_REQUIRE_DIGIT", &policy.RequireDigit)
+	envBool("PASSWORD_REQUIRE_SPECIAL", &policy.RequireSpecial)
+	return policy
+}
+
+// Validate returns a human readable message for every rule the password
+// breaks. userInputs (name, email) must not appear in the password.
+func (p Policy) Validate(password string, userInputs ...string) []string {
+	var problems []string
+
+	if n := len([]rune(password)); n < p.MinLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 || maxBytes > bcryptMaxBytes {
+		maxBytes = bcryptMaxBytes
+	}
+	if len(password) > maxBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes long", maxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSpecial bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		case strings.ContainsRune(specialCharacters, char), unicode.IsPunct(char), unicode.IsSymbol(char):
+			hasSpecial = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		problems = append(problems, "must contain a number")
+	}
+	if p.RequireSpecial && !hasSpecial {
+		problems = append(problems, "must contain a special character")
+	}
+
+	lower := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if local, _, found := strings.Cut(input, "@"); found {
+			input = local
+		}
+		if len(input) >= 4 && strings.Contains(lower, input) {
+			problems = append(problems, "must not contain your name or email")
+			break
+		}
+	}
+
+	return problems
+}
+
+// Checker combines the composition policy with the optional breach list.
+type Checker struct {
+	Policy   Policy
+	Breaches *BreachList
+}
+
+func CheckerFromEnv() *Checker {
+	return &Checker{Policy: PolicyFromEnv(), Breaches: BreachListFromEnv()}
+}
+
+// Check returns every problem with password. The error is only set when the
+// breach list couldn't be read; the policy result is still returned then.
+func (c *Checker) Check(password string, userInputs ...string) ([]string, error) {
+	problems := c.Policy.Validate(password, userInputs...)
+	breached, err := c.Breaches.Contains(password)
+	if breached {
+		problems = append(problems, "has appeared in a data breach, please choose a different one")
+	}
+	return problems, err
+}
@@ -0,0 +1,117 @@
+// Package period computes calendar periods (days, weeks, months...) in a
+// user's timezone, honoring their first day of the week and the day of the
+// month their fiscal month starts on.
+package period
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Unit string
+
+const (
+	Day     Unit = "day"
+	Week    Unit = "week"
+	Month   Unit = "month"
+	Quarter Unit = "quarter"
+	Year    Unit = "year"
+)
+
+func ParseUnit(s string) (Unit, error) {
+	switch u := Unit(strings.ToLower(strings.TrimSpace(s))); u {
+	case Day, Week, Month, Quarter, Year:
+		return u, nil
+	}
+	return "", fmt.Errorf("unknown period %q, expected day, week, month, quarter or year", s)
+}
+
+// Calendar describes how a user divides time into periods. Months (and so
+// quarters and years) begin on MonthStartDay, which is capped at 28 so every
+// month has one.
+type Calendar struct {
+	Location      *time.Location
+	WeekStart     time.Weekday
+	MonthStartDay int
+}
+
+// Default is the calendar used for users without preferences.
+var Default = Calendar{Location: time.UTC, WeekStart: time.Monday, MonthStartDay: 1}
+
+func (c Calendar) location() *time.Location {
+	if c.Location == nil {
+		return time.UTC
+	}
+	return c.Location
+}
+
+func (c Calendar) monthStartDay() int {
+	if c.MonthStartDay < 1 || c.MonthStartDay > 28 {
+		return 1
+	}
+	return c.MonthStartDay
+}
+
+// Start returns the beginning of the period of unit u containing t.
+func (c Calendar) Start(u Unit, t time.Time) time.Time {
+	loc := c.location()
+	t = t.In(loc)
+	y, m, d := t.Date()
+
+	switch u {
+	case Week:
+		back := (int(t.Weekday()) - int(c.WeekStart) + 7) % 7
+		return time.Date(y, m, d-back, 0, 0, 0, 0, loc)
+	case Month, Quarter, Year:
+		day := c.monthStartDay()
+		if d < day {
+			m--
+		}
+		switch u {
+		case Quarter:
+			m -= (m - 1 + 12) % 3
+		case Year:
+			m = time.January
+		}
+		// time.Date normalises month underflow into the previous year
+		start := time.Date(y, m, day, 0, 0, 0, 0, loc)
+		if u == Year && start.After(t) {
+			start = start.AddDate(-1, 0, 0)
+		}
+		return start
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+}
+
+// Next returns the start of the period following the one starting at start.
+func (c Calendar) Next(u Unit, start time.Time) time.Time {
+	switch u {
+	case Week:
+		return start.AddDate(0, 0, 7)
+	case Month:
+		return start.AddDate(0, 1, 0)
+	case Quarter:
+		return start.AddDate(0, 3, 0)
+	case Year:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Range returns the half-open interval [from, to) of the period containing t.
+func (c Calendar) Range(u Unit, t time.Time) (time.Time, time.Time) {
+	start := c.Start(u, t)
+	return start, c.Next(u, start)
+}
+
+// ParseDate parses a YYYY-MM-DD date as midnight in the calendar's timezone.
+func (c Calendar) ParseDate(s string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02", s, c.location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+	}
+	return t, nil
+}
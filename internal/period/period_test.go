@@ -0,0 +1,128 @@
+package period
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // the tests must not depend on the host's zoneinfo
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCalendarStart(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	at := func(loc *time.Location, y int, m time.Month, d, h int) time.Time {
+		return time.Date(y, m, d, h, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		cal  Calendar
+		unit Unit
+		t    time.Time
+		want time.Time
+	}{
+		{"day in UTC", Default, Day, at(time.UTC, 2026, 5, 14, 18), at(time.UTC, 2026, 5, 14, 0)},
+		{"day follows the user's timezone", Calendar{Location: ny}, Day, at(time.UTC, 2026, 1, 1, 3), at(ny, 2025, 12, 31, 0)},
+		{"week from monday", Default, Week, at(time.UTC, 2026, 3, 11, 9), at(time.UTC, 2026, 3, 9, 0)},
+		{"week from sunday", Calendar{WeekStart: time.Sunday}, Week, at(time.UTC, 2026, 3, 11, 9), at(time.UTC, 2026, 3, 8, 0)},
+		{"week from saturday on a saturday", Calendar{WeekStart: time.Saturday}, Week, at(time.UTC, 2026, 3, 14, 9), at(time.UTC, 2026, 3, 14, 0)},
+		{"week across a year", Default, Week, at(time.UTC, 2026, 1, 2, 0), at(time.UTC, 2025, 12, 29, 0)},
+		{"week across the DST change", Calendar{Location: ny, WeekStart: time.Sunday}, Week, at(ny, 2026, 3, 10, 12), at(ny, 2026, 3, 8, 0)},
+		{"month", Default, Month, at(time.UTC, 2026, 2, 28, 23), at(time.UTC, 2026, 2, 1, 0)},
+		{"fiscal month after its start day", Calendar{MonthStartDay: 25}, Month, at(time.UTC, 2026, 2, 26, 0), at(time.UTC, 2026, 2, 25, 0)},
+		{"fiscal month before its start day", Calendar{MonthStartDay: 25}, Month, at(time.UTC, 2026, 2, 24, 0), at(time.UTC, 2026, 1, 25, 0)},
+		{"fiscal month into the previous year", Calendar{MonthStartDay: 25}, Month, at(time.UTC, 2026, 1, 10, 0), at(time.UTC, 2025, 12, 25, 0)},
+		{"month start day above 28 falls back to 1", Calendar{MonthStartDay: 31}, Month, at(time.UTC, 2026, 4, 10, 0), at(time.UTC, 2026, 4, 1, 0)},
+		{"quarter", Default, Quarter, at(time.UTC, 2026, 8, 20, 0), at(time.UTC, 2026, 7, 1, 0)},
+		{"fiscal quarter into the previous year", Calendar{MonthStartDay: 15}, Quarter, at(time.UTC, 2026, 1, 10, 0), at(time.UTC, 2025, 10, 15, 0)},
+		{"year", Default, Year, at(time.UTC, 2026, 12, 31, 23), at(time.UTC, 2026, 1, 1, 0)},
+		{"fiscal year before its start", Calendar{MonthStartDay: 15}, Year, at(time.UTC, 2026, 1, 10, 0), at(time.UTC, 2025, 1, 15, 0)},
+		{"year in the user's timezone", Calendar{Location: ny}, Year, at(time.UTC, 2026, 1, 1, 2), at(ny, 2025, 1, 1, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cal.Start(tt.unit, tt.t); !got.Equal(tt.want) {
+				t.Errorf("Start(%s, %v) = %v, want %v", tt.unit, tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalendarRangeAcrossDST(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	cal := Calendar{Location: ny, WeekStart: time.Sunday, MonthStartDay: 1}
+
+	tests := []struct {
+		name     string
+		unit     Unit
+		t        time.Time
+		wantFrom time.Time
+		wantTo   time.Time
+		wantLen  time.Duration
+	}{
+		// clocks go forward on 8 March 2026 and back on 1 November 2026
+		{"spring forward day", Day, time.Date(2026, 3, 8, 12, 0, 0, 0, ny), time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 3, 9, 0, 0, 0, 0, ny), 23 * time.Hour},
+		{"fall back day", Day, time.Date(2026, 11, 1, 12, 0, 0, 0, ny), time.Date(2026, 11, 1, 0, 0, 0, 0, ny), time.Date(2026, 11, 2, 0, 0, 0, 0, ny), 25 * time.Hour},
+		{"spring forward week", Week, time.Date(2026, 3, 12, 0, 0, 0, 0, ny), time.Date(2026, 3, 8, 0, 0, 0, 0, ny), time.Date(2026, 3, 15, 0, 0, 0, 0, ny), 7*24*time.Hour - time.Hour},
+		{"month with both", Month, time.Date(2026, 3, 20, 0, 0, 0, 0, ny), time.Date(2026, 3, 1, 0, 0, 0, 0, ny), time.Date(2026, 4, 1, 0, 0, 0, 0, ny), 31*24*time.Hour - time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := cal.Range(tt.unit, tt.t)
+			if !from.Equal(tt.wantFrom) || !to.Equal(tt.wantTo) {
+				t.Errorf("Range(%s) = [%v, %v), want [%v, %v)", tt.unit, from, to, tt.wantFrom, tt.wantTo)
+			}
+			if got := to.Sub(from); got != tt.wantLen {
+				t.Errorf("Range(%s) lasts %v, want %v", tt.unit, got, tt.wantLen)
+			}
+			// local midnights on both ends
+			if h, m, _ := to.In(ny).Clock(); h != 0 || m != 0 {
+				t.Errorf("Range(%s) ends at %v, not midnight", tt.unit, to.In(ny))
+			}
+		})
+	}
+}
+
+func TestCalendarNextIsTheFollowingStart(t *testing.T) {
+	cal := Calendar{Location: mustLoad(t, "Europe/Berlin"), WeekStart: time.Monday, MonthStartDay: 28}
+	for _, unit := range []Unit{Day, Week, Month, Quarter, Year} {
+		start := cal.Start(unit, time.Date(2026, 3, 29, 1, 30, 0, 0, time.UTC))
+		for i := 0; i < 30; i++ {
+			next := cal.Next(unit, start)
+			if got := cal.Start(unit, next); !got.Equal(next) {
+				t.Fatalf("%s: Next(%v) = %v, which isn't the start of its own period (%v)", unit, start, next, got)
+			}
+			if got := cal.Start(unit, next.Add(-time.Nanosecond)); !got.Equal(start) {
+				t.Fatalf("%s: the instant before %v belongs to %v, want %v", unit, next, got, start)
+			}
+			start = next
+		}
+	}
+}
+
+func TestParseUnit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Unit
+		wantErr bool
+	}{
+		{"day", Day, false},
+		{" Month ", Month, false},
+		{"QUARTER", Quarter, false},
+		{"fortnight", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseUnit(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseUnit(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when the bucket will be full again, used for eviction
+}
+
+// MemoryStore is a process-local Store.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	capacity := float64(policy.Limit)
+	rate := policy.rate()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+
+	// refill for the time elapsed since the last request
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.last = now
+	}
+
+	result := Result{Limit: policy.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(b.tokens))
+	result.Reset = seconds((capacity - b.tokens) / rate)
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops buckets that have refilled completely; they are equivalent to a
+// fresh bucket so forgetting them is free.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Joshua-takyi/expense/server/internal/constants"
+	"github.com/Joshua-takyi/expense/server/internal/helpers"
+)
+
+// Middleware limits requests per authenticated user, or per client IP on
+// public routes. Register it after auth.Middleware on protected routes so the
+// user claims are available.
+func Middleware(store Store, policy Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if policy.Limit <= 0 {
+			c.Next()
+			return
+		}
+
+		result, err := store.Take(c.Request.Context(), policy.Name+":"+clientKey(c), policy, time.Now())
+		if err != nil {
+			// fail open: an unavailable limiter store shouldn't take the API down
+			log.Printf("rate limiter error: %v", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		c.Header("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(ceilSeconds(policy.Period)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.AbortWithStatusJSON(429, gin.H{"error": constants.ErrTooManyRequests, "message": "rate limit exceeded, slow down"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func clientKey(c *gin.Context) string {
+	if value, exists := c.Get("user"); exists {
+		if claims, ok := value.(*helpers.UseClaims); ok && claims.UserID != "" {
+			return "user:" + claims.UserID
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
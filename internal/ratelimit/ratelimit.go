@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a token bucket: Limit requests may burst at once and the bucket
+// refills at Limit per Period.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result describes the bucket after a Take.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, when denied
+}
+
+// Store keeps bucket state. The in-memory store suits a single instance; a
+// shared backend (Redis, Mongo) can implement Store for multi-instance
+// deployments.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error)
+}
+
+// PolicyFromEnv overrides def with RATE_LIMIT_<NAME>, formatted as
+// "<limit>/<period>" e.g. "100/1m". "off" disables the policy (Limit 0).
+func PolicyFromEnv(def Policy) (Policy, error) {
+	value := os.Getenv("RATE_LIMIT_" + strings.ToUpper(def.Name))
+	if value == "" {
+		return def, nil
+	}
+	if strings.EqualFold(value, "off") {
+		def.Limit = 0
+		return def, nil
+	}
+
+	limit, period, found := strings.Cut(value, "/")
+	if !found {
+		return def, fmt.Errorf("invalid rate limit %q, expected <limit>/<period>", value)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n < 0 {
+		return def, fmt.Errorf("invalid rate limit %q: bad limit", value)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return def, fmt.Errorf("invalid rate limit %q: bad period", value)
+	}
+
+	def.Limit = n
+	def.Period = d
+	return def, nil
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	policy := Policy{Name: "test", Limit: 3, Period: 3 * time.Second}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// one store for the whole sequence: each step depends on the previous ones
+	store := NewMemoryStore()
+	steps := []struct {
+		name          string
+		at            time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{"burst 1", 0, true, 2, 0},
+		{"burst 2", 0, true, 1, 0},
+		{"burst 3", 0, true, 0, 0},
+		{"empty bucket", 0, false, 0, time.Second},
+		{"half a token later", 500 * time.Millisecond, false, 0, 500 * time.Millisecond},
+		{"one token refilled", time.Second, true, 0, 0},
+		{"full again after the period", 10 * time.Second, true, 2, 0},
+	}
+	for _, step := range steps {
+		result, err := store.Take(context.Background(), "k", policy, start.Add(step.at))
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if result.Allowed != step.wantAllowed || result.Remaining != step.wantRemaining || result.RetryAfter != step.wantRetry {
+			t.Errorf("%s: got allowed=%v remaining=%d retry=%v, want allowed=%v remaining=%d retry=%v",
+				step.name, result.Allowed, result.Remaining, result.RetryAfter, step.wantAllowed, step.wantRemaining, step.wantRetry)
+		}
+		if result.Limit != policy.Limit {
+			t.Errorf("%s: limit = %d, want %d", step.name, result.Limit, policy.Limit)
+		}
+	}
+}
+
+func TestMemoryStoreKeysAreIndependent(t *testing.T) {
+	policy := Policy{Name: "test", Limit: 1, Period: time.Minute}
+	now := time.Now()
+	store := NewMemoryStore()
+
+	if r, _ := store.Take(context.Background(), "a", policy, now); !r.Allowed {
+		t.Fatal("first request for a denied")
+	}
+	if r, _ := store.Take(context.Background(), "a", policy, now); r.Allowed {
+		t.Error("second request for a allowed")
+	}
+	if r, _ := store.Take(context.Background(), "b", policy, now); !r.Allowed {
+		t.Error("b denied by a's bucket")
+	}
+}
+
+func TestPolicyFromEnv(t *testing.T) {
+	def := Policy{Name: "rule_apply", Limit: 10, Period: time.Hour}
+	tests := []struct {
+		value   string
+		want    Policy
+		wantErr bool
+	}{
+		{"", def, false},
+		{"30/1m", Policy{Name: "rule_apply", Limit: 30, Period: time.Minute}, false},
+		{" 5 / 2h ", Policy{Name: "rule_apply", Limit: 5, Period: 2 * time.Hour}, false},
+		{"OFF", Policy{Name: "rule_apply", Limit: 0, Period: time.Hour}, false},
+		{"30", def, true},
+		{"-1/1m", def, true},
+		{"30/0s", def, true},
+		{"30/soon", def, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("RATE_LIMIT_RULE_APPLY", tt.value)
+		got, err := PolicyFromEnv(def)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("PolicyFromEnv(%q) = %+v, %v, want %+v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
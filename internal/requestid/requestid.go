@@ -0,0 +1,44 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	Header     = "X-Request-ID"
+	ContextKey = "request_id"
+)
+
+// incoming IDs from proxies are reused only if they look sane, so they can't
+// be used to inject content into logs or the audit trail
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware tags every request with an ID, reusing one set by an upstream
+// proxy, and echoes it in the response.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !validID.MatchString(id) {
+			id = newID()
+		}
+		c.Set(ContextKey, id)
+		c.Header(Header, id)
+		c.Next()
+	}
+}
+
+func Get(c *gin.Context) string {
+	return c.GetString(ContextKey)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -1,18 +1,35 @@
 package router
 
 import (
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Joshua-takyi/expense/server/internal/auth"
 	"github.com/Joshua-takyi/expense/server/internal/handlers"
+	"github.com/Joshua-takyi/expense/server/internal/idempotency"
+	"github.com/Joshua-takyi/expense/server/internal/mailer"
 	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/oidc"
+	"github.com/Joshua-takyi/expense/server/internal/ratelimit"
+	"github.com/Joshua-takyi/expense/server/internal/requestid"
+	"github.com/Joshua-takyi/expense/server/internal/storage"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func Router(s models.Service) *gin.Engine {
+func Router(s models.Service, m mailer.Mailer, store ratelimit.Store, files storage.Store) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
+	// ClientIP keys the login throttle and rate limits, so X-Forwarded-For is
+	// only believed when it comes from a configured proxy
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("invalid TRUSTED_PROXIES: %v", err)
+	}
+	r.Use(requestid.Middleware())
 
 	allowedOrigins := []string{
 		"http://localhost:3000",
@@ -20,9 +37,9 @@ func Router(s models.Service) *gin.Engine {
 	}
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-CSRF-Token", "X-Request-ID", "Idempotency-Key", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Idempotent-Replayed", "ETag"},
 		AllowCredentials: true,
 	}))
 	r.GET("/", func(c *gin.Context) {
@@ -32,17 +49,44 @@ func Router(s models.Service) *gin.Engine {
 		})
 	})
 
+	limit := func(def ratelimit.Policy) gin.HandlerFunc {
+		policy, err := ratelimit.PolicyFromEnv(def)
+		if err != nil {
+			log.Printf("using default %s rate limit: %v", def.Name, err)
+		}
+		return ratelimit.Middleware(store, policy)
+	}
+
+	apiLimit := limit(ratelimit.Policy{Name: "api", Limit: 120, Period: time.Minute})
+	// expensive or abusable routes get their own buckets on top of auth/api
+	exportLimit := limit(ratelimit.Policy{Name: "export", Limit: 10, Period: time.Hour})
+	uploadLimit := limit(ratelimit.Policy{Name: "upload", Limit: 60, Period: time.Hour})
+	ruleApplyLimit := limit(ratelimit.Policy{Name: "rule_apply", Limit: 10, Period: time.Hour})
+	inviteLimit := limit(ratelimit.Policy{Name: "invite", Limit: 20, Period: 24 * time.Hour})
+	passwordResetLimit := limit(ratelimit.Policy{Name: "password_reset", Limit: 5, Period: time.Hour})
+
 	v1 := r.Group("/api/v1")
 
-	// public routes
+	// public routes, limited per client IP
+	public := v1.Group("/").Use(limit(ratelimit.Policy{Name: "auth", Limit: 10, Period: time.Minute}))
 	{
-		v1.POST("/register", handlers.RegisterUser(s))
-		v1.POST("/login", handlers.AuthenticateUser(s))
+		public.POST("/register", handlers.RegisterUser(s))
+		public.POST("/login", handlers.AuthenticateUser(s, m))
+		public.POST("/login/mfa", handlers.VerifyMFALogin(s, m))
+		public.POST("/login/unlock", handlers.UnlockAccount(s))
+		public.POST("/password/forgot", passwordResetLimit, handlers.ForgotPassword(s, m))
+		public.POST("/password/reset", passwordResetLimit, handlers.ResetPassword(s))
+
+		if config, ok := oidc.ConfigFromEnv(); ok {
+			client := oidc.NewClient(config)
+			public.GET("/auth/oidc/login", handlers.OIDCLogin(client))
+			public.GET("/auth/oidc/callback", handlers.OIDCCallback(s, m, client))
+		}
 	}
 
-	// protected routes
+	// protected routes, limited per user
 
-	protected := v1.Group("/").Use(auth.Middleware())
+	protected := v1.Group("/").Use(auth.Middleware(s), apiLimit)
 	{
 		protected.GET("/profile", func(c *gin.Context) {
 			user, exists := c.Get("user")
@@ -50,25 +94,127 @@ func Router(s models.Service) *gin.Engine {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 				return
 			}
+			if current, ok := c.Get(auth.ContextUser); ok {
+				c.Header("ETag", `"`+strconv.FormatInt(current.(*models.User).Version, 10)+`"`)
+			}
 			c.JSON(http.StatusOK, gin.H{"user": user})
 		})
+		protected.PUT("/profile", auth.RequireSession(), handlers.UpdateProfile(s))
+		protected.GET("/preferences", handlers.GetPreferences(s))
+		protected.PUT("/preferences", auth.RequireSession(), handlers.UpdatePreferences(s))
+		protected.GET("/security/events", auth.RequireSession(), handlers.ListSecurityEvents(s))
+
+		protected.GET("/csrf-token", auth.RequireSession(), handlers.CSRFHandler())
+		protected.POST("/logout", auth.RequireSession(), handlers.LogoutUser(s))
+		protected.PUT("/password", auth.RequireSession(), handlers.ChangePassword(s))
+
+		protected.POST("/mfa/totp/enroll", auth.RequireSession(), handlers.EnrollTOTP(s))
+		protected.POST("/mfa/totp/confirm", auth.RequireSession(), handlers.ConfirmTOTP(s))
+		protected.POST("/mfa/totp/disable", auth.RequireSession(), handlers.DisableTOTP(s))
+		protected.POST("/mfa/recovery-codes", auth.RequireSession(), handlers.RegenerateRecoveryCodes(s))
+
+		protected.POST("/account/exports", auth.RequireSession(), exportLimit, handlers.RequestDataExport(s))
+		protected.GET("/account/exports", auth.RequireSession(), handlers.ListDataExports(s))
+		protected.GET("/account/exports/:id/download", auth.RequireSession(), handlers.DownloadDataExport(s))
+		protected.POST("/account/deletion", auth.RequireSession(), handlers.ScheduleAccountDeletion(s))
+		protected.DELETE("/account/deletion", auth.RequireSession(), handlers.CancelAccountDeletion(s))
 
-		protected.GET("/csrf-token", handlers.CSRFHandler())
-		protected.POST("/logout", handlers.LogoutUser)
-		// protected.GET("/profile", handlers.GetProfile(s))
-		// protected.PUT("/profile", handlers.UpdateProfile(s))
+		protected.POST("/tokens", auth.RequireSession(), handlers.CreateAPIToken(s))
+		protected.GET("/tokens", auth.RequireSession(), handlers.ListAPITokens(s))
+		protected.DELETE("/tokens/:id", auth.RequireSession(), handlers.RevokeAPIToken(s))
+
+		protected.POST("/accounts", auth.RequireScope(models.ScopeTransactionsWrite), handlers.CreateAccount(s))
+		protected.GET("/accounts", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListAccounts(s))
+		protected.GET("/accounts/:id", auth.RequireScope(models.ScopeTransactionsRead), handlers.GetAccount(s))
+		protected.PUT("/accounts/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.UpdateAccount(s))
+		protected.DELETE("/accounts/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.DeleteAccount(s))
+		protected.GET("/accounts/:id/balance", auth.RequireScope(models.ScopeTransactionsRead), handlers.AccountBalance(s))
+		protected.GET("/accounts/:id/transactions", auth.RequireScope(models.ScopeTransactionsRead), handlers.AccountTransactions(s))
+
+		protected.POST("/ledgers", auth.RequireScope(models.ScopeTransactionsWrite), handlers.CreateLedger(s))
+		protected.GET("/ledgers", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListLedgers(s))
+		protected.POST("/ledgers/invitations/accept", auth.RequireSession(), handlers.AcceptLedgerInvitation(s))
+		protected.GET("/ledgers/:id", auth.RequireScope(models.ScopeTransactionsRead), handlers.GetLedger(s))
+		protected.PUT("/ledgers/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.UpdateLedger(s))
+		protected.DELETE("/ledgers/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.DeleteLedger(s))
+		protected.PUT("/ledgers/:id/members/:user_id", auth.RequireSession(), handlers.UpdateLedgerMember(s))
+		protected.DELETE("/ledgers/:id/members/:user_id", auth.RequireSession(), handlers.RemoveLedgerMember(s))
+		protected.POST("/ledgers/:id/invitations", auth.RequireSession(), inviteLimit, handlers.InviteLedgerMember(s, m))
+		protected.GET("/ledgers/:id/invitations", auth.RequireSession(), handlers.ListLedgerInvitations(s))
+		protected.DELETE("/ledgers/:id/invitations/:invitation_id", auth.RequireSession(), handlers.RevokeLedgerInvitation(s))
+		protected.GET("/ledgers/:id/balances", auth.RequireScope(models.ScopeTransactionsRead), handlers.LedgerBalances(s))
+		protected.POST("/ledgers/:id/settlements", auth.RequireScope(models.ScopeTransactionsWrite), handlers.RecordSettlement(s))
 
 		// protected.POST("/categories", handlers.CreateCategory(s))
 		// protected.GET("/categories", handlers.GetCategories(s))
 		// protected.PUT("/categories/:id", handlers.UpdateCategory(s))
 		// protected.DELETE("/categories/:id", handlers.DeleteCategory(s))
 
-		protected.POST("/transactions", handlers.AddTransaction(s))
-		protected.GET("/transactions-query/", handlers.QueryTransactions(s))
-		protected.GET("/transactions", handlers.ListUserTransactions(s))
-		// protected.PUT("/transactions/:id", handlers.UpdateTransaction(s))
-		protected.DELETE("/transactions/:id", handlers.RemoveTransaction(s))
+		protected.POST("/transactions", auth.RequireScope(models.ScopeTransactionsWrite), idempotency.Middleware(s), handlers.AddTransaction(s))
+		protected.POST("/payees", auth.RequireScope(models.ScopeTransactionsWrite), handlers.CreatePayee(s))
+		protected.GET("/payees", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListPayees(s))
+		protected.GET("/payees/match", auth.RequireScope(models.ScopeTransactionsRead), handlers.MatchPayee(s))
+		protected.POST("/payees/merge", auth.RequireScope(models.ScopeTransactionsWrite), handlers.MergePayees(s))
+		protected.GET("/payees/:id", auth.RequireScope(models.ScopeTransactionsRead), handlers.GetPayee(s))
+		protected.PUT("/payees/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.UpdatePayee(s))
+		protected.DELETE("/payees/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.DeletePayee(s))
+		protected.GET("/payees/:id/transactions", auth.RequireScope(models.ScopeTransactionsRead), handlers.PayeeTransactions(s))
+		protected.POST("/rules", auth.RequireScope(models.ScopeTransactionsWrite), handlers.CreateRule(s))
+		protected.GET("/rules", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListRules(s))
+		protected.POST("/rules/test", auth.RequireScope(models.ScopeTransactionsRead), handlers.TestRule(s))
+		protected.GET("/rules/:id", auth.RequireScope(models.ScopeTransactionsRead), handlers.GetRule(s))
+		protected.PUT("/rules/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.UpdateRule(s))
+		protected.DELETE("/rules/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.DeleteRule(s))
+		protected.POST("/rules/:id/apply", auth.RequireScope(models.ScopeTransactionsWrite), ruleApplyLimit, handlers.ApplyRule(s))
+		protected.GET("/tags", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListTags(s))
+		protected.PUT("/tags/:tag", auth.RequireScope(models.ScopeTransactionsWrite), handlers.RenameTag(s))
+		protected.POST("/tags/merge", auth.RequireScope(models.ScopeTransactionsWrite), handlers.MergeTags(s))
+		protected.POST("/transfers", auth.RequireScope(models.ScopeTransactionsWrite), idempotency.Middleware(s), handlers.CreateTransfer(s))
+		protected.GET("/transactions-query/", auth.RequireScope(models.ScopeTransactionsRead), handlers.QueryTransactions(s))
+		protected.GET("/transactions", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListUserTransactions(s))
+		protected.GET("/transactions/summary", auth.RequireScope(models.ScopeTransactionsRead), handlers.TransactionSummary(s))
+		protected.GET("/transactions/export", auth.RequireScope(models.ScopeTransactionsRead), exportLimit, handlers.ExportTransactions(s))
+		protected.GET("/transactions/suggest", auth.RequireScope(models.ScopeTransactionsRead), handlers.SuggestCategory(s))
+		protected.POST("/transactions/suggest/retrain", auth.RequireScope(models.ScopeTransactionsWrite), handlers.RetrainSuggestions(s))
+		protected.GET("/transactions/trash", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListTrash(s))
+		protected.POST("/transactions/:id/restore", auth.RequireScope(models.ScopeTransactionsWrite), handlers.RestoreTransaction(s))
+		protected.DELETE("/transactions/:id/permanent", auth.RequireScope(models.ScopeTransactionsWrite), handlers.PurgeTransaction(s, files))
+		protected.POST("/transactions/:id/attachments", auth.RequireScope(models.ScopeTransactionsWrite), uploadLimit, handlers.UploadAttachment(s, files))
+		protected.GET("/transactions/:id/attachments", auth.RequireScope(models.ScopeTransactionsRead), handlers.ListAttachments(s))
+		protected.GET("/transactions/:id/attachments/:attachment_id", auth.RequireScope(models.ScopeTransactionsRead), handlers.DownloadAttachment(s, files))
+		protected.DELETE("/transactions/:id/attachments/:attachment_id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.DeleteAttachment(s, files))
+		protected.GET("/transactions/:id/history", auth.RequireScope(models.ScopeTransactionsRead), handlers.TransactionHistory(s))
+		protected.POST("/transactions/:id/revert", auth.RequireScope(models.ScopeTransactionsWrite), handlers.RevertTransaction(s))
+		protected.GET("/transactions/:id", auth.RequireScope(models.ScopeTransactionsRead), handlers.GetTransaction(s))
+		protected.PUT("/transactions/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.UpdateTransaction(s))
+		protected.DELETE("/transactions/:id", auth.RequireScope(models.ScopeTransactionsWrite), handlers.RemoveTransaction(s))
+	}
+
+	// admin routes
+	admin := v1.Group("/admin").Use(auth.Middleware(s), apiLimit, auth.RequireRole(models.RoleAdmin), auth.RequireScope(models.ScopeAdmin))
+	{
+		admin.GET("/users", handlers.AdminListUsers(s))
+		admin.GET("/users/:id", handlers.AdminGetUser(s))
+		admin.POST("/users/:id/disable", handlers.AdminSetUserDisabled(s, true))
+		admin.POST("/users/:id/enable", handlers.AdminSetUserDisabled(s, false))
+		admin.PUT("/users/:id/role", handlers.AdminSetUserRole(s))
+		admin.POST("/users/:id/force-password-reset", handlers.AdminForcePasswordReset(s, m))
+		admin.POST("/users/:id/revoke-sessions", handlers.AdminRevokeSessions(s))
+		admin.GET("/stats", handlers.AdminSystemStats(s))
 	}
 	return r
 
 }
+
+// trustedProxies reads the comma separated IPs or CIDRs of the reverse
+// proxies in front of the API from TRUSTED_PROXIES. None are trusted by
+// default, so the client IP is the address of the connection.
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores objects in a bucket of an S3-compatible service (AWS S3, MinIO,
+// R2, or cmd/mocks3 locally), using path-style URLs and Signature Version 4.
+type S3 struct {
+	Endpoint        string // e.g. https://s3.eu-west-1.amazonaws.com
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+// S3FromEnv configures an S3 store from S3_ENDPOINT, S3_BUCKET, S3_REGION
+// (default us-east-1), S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY.
+func S3FromEnv() (*S3, error) {
+	s := &S3{
+		Endpoint:        strings.TrimRight(os.Getenv("S3_ENDPOINT"), "/"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		Region:          os.Getenv("S3_REGION"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Client:          &http.Client{Timeout: 5 * time.Minute},
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" || s.Bucket == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for the s3 storage backend")
+	}
+	if _, err := url.Parse(s.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT: %w", err)
+	}
+	return s, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	req, err := s.request(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return http.NewRequestWithContext(ctx, method, s.Endpoint+"/"+uriEncode(s.Bucket)+"/"+strings.Join(segments, "/"), body)
+}
+
+// do signs and sends req, turning error statuses into errors.
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s failed: %w", req.Method, err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s failed: %s: %s", req.Method, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header. Unless the
+// caller set X-Amz-Content-Sha256, the payload is left unsigned so uploads can
+// stream; TLS protects it in transit.
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = "UNSIGNED-PAYLOAD"
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.AccessKeyID == "" {
+		return
+	}
+
+	canonical, signedHeaders := canonicalRequest(req, payload)
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonical)
+	signature := hex.EncodeToString(hmacSHA256(signingKey(s.SecretAccessKey, day, s.Region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalRequest is the SigV4 canonical form of req, signing the host, the
+// content type, a range and every x-amz- header.
+func canonicalRequest(req *http.Request, payload string) (canonical, signedHeaders string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	canonical = strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+	return canonical, signedHeaders
+}
+
+// signingKey derives the key for one day, region and service from the secret.
+func signingKey(secret, day, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode escapes a path segment the way SigV4 expects: everything except
+// unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,54 @@
+// Package storage keeps uploaded files such as receipts, on local disk or in
+// an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for keys that hold no object.
+var ErrNotFound = errors.New("object not found")
+
+// Store saves objects under slash-separated keys. Delete of a missing key is
+// not an error so cleanup can be retried.
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FromEnv returns an S3 store when STORAGE_BACKEND is "s3" and otherwise a
+// local store in STORAGE_DIR, or a folder under the system temp dir.
+func FromEnv() (Store, error) {
+	switch backend := strings.ToLower(os.Getenv("STORAGE_BACKEND")); backend {
+	case "", "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "expense-files")
+		}
+		return &Local{Dir: dir}, nil
+	case "s3":
+		return S3FromEnv()
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// validKey rejects keys that could escape the store's root.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/Joshua-takyi/expense/server/internal/export"
+	"github.com/Joshua-takyi/expense/server/internal/models"
+	"github.com/Joshua-takyi/expense/server/internal/storage"
+)
+
+// EraseDueAccounts hard-deletes accounts whose deletion grace period is over,
+// removing their export archives and attachment files first. An account that
+// fails is logged and retried on the next run without holding up the others.
+func EraseDueAccounts(r models.Service, files storage.Store) func(context.Context) error {
+	return func(ctx context.Context) error {
+		users, err := r.ListDueAccountDeletions(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, user := range users {
+			if err := eraseAccount(ctx, r, files, user.Id); err != nil {
+				log.Printf("failed to erase account %s: %v", user.Id.Hex(), err)
+				failed++
+				continue
+			}
+			log.Printf("erased account %s", user.Id.Hex())
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d due accounts could not be erased", failed, len(users))
+		}
+		return nil
+	}
+}
+
+func eraseAccount(ctx context.Context, r models.Service, files storage.Store, userID primitive.ObjectID) error {
+	exports, err := r.ListDataExports(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if err := export.Remove(e.FilePath); err != nil {
+			return fmt.Errorf("failed to remove export %s: %w", e.Id.Hex(), err)
+		}
+	}
+	attachments, err := r.ListPersonalAttachments(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if err := files.Delete(ctx, a.StorageKey); err != nil {
+			return fmt.Errorf("failed to remove attachment %s: %w", a.Id.Hex(), err)
+		}
+	}
+	return r.DeleteUserAccount(ctx, userID)
+}
+
+// TrashRetention is how long deleted transactions stay restorable,
+// TRASH_RETENTION or 30 days.
+func TrashRetention() time.Duration {
+	if value := os.Getenv("TRASH_RETENTION"); value != "" {
+		if retention, err := time.ParseDuration(value); err == nil && retention >= 0 {
+			return retention
+		}
+		log.Printf("invalid TRASH_RETENTION %q, using default", value)
+	}
+	return 30 * 24 * time.Hour
+}
+
+// PurgeTrash permanently deletes transactions trashed longer than the
+// retention.
+func PurgeTrash(r models.Service) func(context.Context) error {
+	return func(ctx context.Context) error {
+		n, err := r.PurgeDeletedTransactions(ctx, time.Now().Add(-TrashRetention()))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("purged %d transactions from the trash", n)
+		}
+		return nil
+	}
+}
+
+// PurgeOrphanedAttachments deletes the files and records of attachments
+// whose transaction was permanently deleted, including by the trash purge.
+func PurgeOrphanedAttachments(r models.Service, files storage.Store) func(context.Context) error {
+	return func(ctx context.Context) error {
+		orphans, err := r.ListOrphanedAttachments(ctx, 500)
+		if err != nil {
+			return err
+		}
+		for _, a := range orphans {
+			if err := files.Delete(ctx, a.StorageKey); err != nil {
+				log.Printf("failed to remove attachment file %s: %v", a.StorageKey, err)
+				continue
+			}
+			if err := r.DeleteAttachment(ctx, a.Id); err != nil {
+				return err
+			}
+		}
+		if len(orphans) > 0 {
+			log.Printf("removed %d orphaned attachments", len(orphans))
+		}
+		return nil
+	}
+}
+
+// PurgeExpiredIdempotencyKeys deletes stored responses past their retention.
+func PurgeExpiredIdempotencyKeys(r models.Service) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := r.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+		return err
+	}
+}
+
+// PurgeExpiredExports deletes export archives past their retention.
+func PurgeExpiredExports(r models.Service) func(context.Context) error {
+	return func(ctx context.Context) error {
+		expired, err := r.DeleteExpiredDataExports(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, e := range expired {
+			if err := export.Remove(e.FilePath); err != nil {
+				log.Printf("failed to remove export file %s: %v", e.FilePath, err)
+			}
+		}
+		return nil
+	}
+}
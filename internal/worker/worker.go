@@ -0,0 +1,27 @@
+// Package worker runs periodic background jobs alongside the API server.
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every calls fn once immediately and then every interval until ctx is
+// cancelled. Errors are logged and the job keeps its schedule.
+func Every(ctx context.Context, interval time.Duration, name string, fn func(context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := fn(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("%s job failed: %v", name, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}